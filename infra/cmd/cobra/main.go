@@ -1,16 +1,25 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/exec"
-	"reflect"
+	"slices"
+	"strings"
+	"time"
 
-	"github.com/AlekSi/pointer"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/awsapi"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/pkg/ops"
 	"github.com/spf13/cobra"
 )
 
+const defaultRegion = "us-east-2"
+
 const figlet string = `    _   ___   ____  ______________  ______  __________  __________  ____  __  ______    _   ______________
    / | / / | / / / / /  _/ ____/ / / / __ \/ ____/ __ \/ ____/ __ \/ __ \/  |/  /   |  / | / / ____/ ____/
   /  |/ /  |/ / /_/ // // / __/ /_/ / /_/ / __/ / /_/ / /_  / / / / /_/ / /|_/ / /| | /  |/ / /   / __/   
@@ -18,102 +27,604 @@ const figlet string = `    _   ___   ____  ______________  ______  __________  _
 /_/ |_/_/ |_/_/ /_/___/\____/_/ /_/_/   /_____/_/ |_/_/    \____/_/ |_/_/  /_/_/  |_/_/ |_/\____/_____/   										  
 `
 
-type MetaflowConfig struct {
-	METAFLOW_BATCH_JOB_QUEUE            *string `json:"METAFLOW_BATCH_JOB_QUEUE"`
-	METAFLOW_DATASTORE_SYSROOT_S3       *string `json:"METAFLOW_DATASTORE_SYSROOT_S3"`
-	METAFLOW_DATATOOLS_S3ROOT           *string `json:"METAFLOW_DATATOOLS_S3ROOT"`
-	METAFLOW_DEFAULT_DATASTORE          *string `json:"METAFLOW_DEFAULT_DATASTORE"`
-	METAFLOW_DEFAULT_METADATA           *string `json:"METAFLOW_DEFAULT_METADATA"`
-	METAFLOW_ECS_S3_ACCESS_IAM_ROLE     *string `json:"METAFLOW_ECS_S3_ACCESS_IAM_ROLE"`
-	METAFLOW_EVENTS_SFN_ACCESS_IAM_ROLE *string `json:"METAFLOW_EVENTS_SFN_ACCESS_IAM_ROLE"`
-	METAFLOW_SERVICE_INTERNAL_URL       *string `json:"METAFLOW_SERVICE_INTERNAL_URL"`
-	METAFLOW_SERVICE_URL                *string `json:"METAFLOW_SERVICE_URL"`
-	METAFLOW_SFN_DYNAMO_DB_TABLE        *string `json:"METAFLOW_SFN_DYNAMO_DB_TABLE"`
-	METAFLOW_SFN_IAM_ROLE               *string `json:"METAFLOW_SFN_IAM_ROLE"`
-	METAFLOW_ECS_FARGATE_EXECUTION_ROLE *string `json:"METAFLOW_ECS_FARGATE_EXECUTION_ROLE"`
+func main() {
+	if err := rootCommand().ExecuteContext(context.Background()); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err))
+	}
 }
 
-func main() {
+// exitCode is the code the CLI exits with after err, a failed cdk run exits with its own code.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	return 1
+}
+
+func rootCommand() *cobra.Command {
+	var awsOptions awsapi.Options
+
 	rootCmd := &cobra.Command{
-		Use:   "app",
-		Short: "app application entry point",
+		Use:           "app",
+		Short:         "app application entry point",
+		SilenceUsage:  true,
+		SilenceErrors: true,
 	}
+	rootCmd.PersistentFlags().StringVar(&awsOptions.Profile, "profile", "", "AWS shared config profile")
+	rootCmd.PersistentFlags().StringVar(&awsOptions.Region, "region", defaultRegion, "AWS region of the deployment")
+	rootCmd.PersistentFlags().StringVar(&awsOptions.EndpointURL, "endpoint-url", "", "send the AWS API calls to this endpoint, e.g. a LocalStack server")
 
 	deployCmd := &cobra.Command{
-		Use:   "deploy",
-		Short: "Deploy the CDK application",
-		Run: func(cmd *cobra.Command, args []string) {
+		Use:   "deploy [stack|alias]...",
+		Short: "Deploy the CDK application, or the given stacks and the stacks they depend on",
+		Long:  ops.AliasesHelp(),
+		RunE: func(cmd *cobra.Command, args []string) error {
 			fmt.Print(figlet)
-			execCmd := exec.Command("cdk", "deploy", "--all", "--require-approval", "never", "--concurrency", "100")
-			execCmd.Stdout = os.Stdout
-			execCmd.Stderr = os.Stderr
-			execCmd.Run()
+			ctx := cmd.Context()
+			_, outputs, err := session(ctx, awsOptions)
+			if err != nil {
+				return err
+			}
+
+			var stacks []string
+			if len(args) > 0 {
+				graph, err := ops.Synth(ctx, cdk(awsOptions))
+				if err != nil {
+					return err
+				}
+				selected, err := graph.Resolve(args)
+				if err != nil {
+					return err
+				}
+				stacks = graph.WithDependencies(selected)
+				fmt.Println("Deploying", strings.Join(stacks, ", "))
+			}
+
+			if err := ops.Deploy(ctx, cdk(awsOptions), outputs, stacks); err != nil {
+				return err
+			}
+			return printOutputsPath(ctx, outputs)
 		},
 	}
 
+	var destroyYes bool
+
 	destroyCmd := &cobra.Command{
-		Use:   "destroy",
-		Short: "Destroy the CDK application",
-		Run: func(cmd *cobra.Command, args []string) {
+		Use:   "destroy [stack|alias]...",
+		Short: "Destroy the CDK application, or the given stacks and the stacks depending on them",
+		Long:  ops.AliasesHelp(),
+		RunE: func(cmd *cobra.Command, args []string) error {
 			fmt.Print(figlet)
-			execCmd := exec.Command("cdk", "destroy", "--all", "--force", "--concurrency", "100")
-			execCmd.Stdout = os.Stdout
-			execCmd.Stderr = os.Stderr
-			execCmd.Run()
+			ctx := cmd.Context()
+			_, outputs, err := session(ctx, awsOptions)
+			if err != nil {
+				return err
+			}
+
+			var stacks []string
+			prompt := "Destroy every stack of the Metaflow deployment?"
+			if len(args) > 0 {
+				graph, err := ops.Synth(ctx, cdk(awsOptions))
+				if err != nil {
+					return err
+				}
+				selected, err := graph.Resolve(args)
+				if err != nil {
+					return err
+				}
+				stacks = graph.WithDependents(selected)
+				prompt = fmt.Sprintf("Destroy %s?", strings.Join(stacks, ", "))
+			}
+
+			if !destroyYes {
+				ok, err := confirm(cmd, prompt)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return fmt.Errorf("destroy not confirmed")
+				}
+			}
+			if err := ops.Destroy(ctx, cdk(awsOptions), outputs, stacks); err != nil {
+				return err
+			}
+			return printOutputsPath(ctx, outputs)
+		},
+	}
+	destroyCmd.Flags().BoolVarP(&destroyYes, "yes", "y", false, "destroy without asking for confirmation")
+
+	var failOnDiff bool
+
+	diffCmd := &cobra.Command{
+		Use:   "diff [stack|alias]...",
+		Short: "Compare the CDK application, or the given stacks, with what is deployed",
+		Long:  ops.AliasesHelp(),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var stacks []string
+			if len(args) > 0 {
+				graph, err := ops.Synth(cmd.Context(), cdk(awsOptions))
+				if err != nil {
+					return err
+				}
+				if stacks, err = graph.Resolve(args); err != nil {
+					return err
+				}
+			}
+			return ops.Diff(cmd.Context(), cdk(awsOptions), stacks, failOnDiff)
 		},
 	}
+	diffCmd.Flags().BoolVar(&failOnDiff, "fail", false, "exit with a non-zero code when a stack differs")
 
 	metaflowConfigCmd := &cobra.Command{
 		Use:   "metaflow-config",
 		Short: "Show the Metaflow configuration",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			fmt.Print(figlet)
-			cfnCommand := exec.Command("aws", "cloudformation", "describe-stacks", "--stack-name", "ResultStack", "--query", "Stacks[0].Outputs[][Description, OutputValue]", "--region", "us-east-2")
-			cfnCommand.Stderr = os.Stderr
-			result, err := cfnCommand.Output()
+			_, outputs, err := session(cmd.Context(), awsOptions)
+			if err != nil {
+				return err
+			}
+			config, unknown, err := ops.MetaflowConfig(cmd.Context(), outputs)
+			if err != nil {
+				return err
+			}
+			for _, description := range unknown {
+				warn("ResultStack output %s is not a Metaflow setting, it is left out of the configuration", description)
+			}
 
+			bytes, err := json.MarshalIndent(config, "", "  ")
 			if err != nil {
-				fmt.Println("Error executing command:", err)
-				return
+				return fmt.Errorf("encoding configuration: %w", err)
 			}
 
-			var resulsList [][]string
+			fmt.Println(string(bytes))
+			fmt.Println("Configuration for ~/.metaflowconfig/config.json")
+			return nil
+		},
+	}
+
+	var refresh bool
 
-			err = json.Unmarshal(result, &resulsList)
+	outputsCmd := &cobra.Command{
+		Use:   "outputs",
+		Short: "Print the cached stack outputs of the deployment as JSON",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, cache, err := session(cmd.Context(), awsOptions)
 			if err != nil {
-				fmt.Println("Error parsing JSON:", err)
-				return
+				return err
+			}
+			load := cache.Load
+			if refresh {
+				load = cache.Capture
+			}
+			outputs, err := load(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			bytes, err := json.MarshalIndent(outputs, "", "  ")
+			if err != nil {
+				return fmt.Errorf("encoding outputs: %w", err)
+			}
+			fmt.Println(string(bytes))
+			return nil
+		},
+	}
+	outputsCmd.Flags().BoolVar(&refresh, "refresh", false, "read the outputs from CloudFormation again")
+
+	var requested ops.ImageVersions
+	var dryRun, allowDowngrade bool
+
+	upgradeCmd := &cobra.Command{
+		Use:   "upgrade",
+		Short: "Upgrade the Metaflow metadata service and UI images",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fmt.Print(figlet)
+			_, outputs, err := session(cmd.Context(), awsOptions)
+			if err != nil {
+				return err
+			}
+			current, err := ops.DeployedImageVersions(cmd.Context(), outputs)
+			if err != nil {
+				return err
+			}
+			target, err := ops.UpgradeTarget(current, requested, allowDowngrade)
+
+			fmt.Printf("%-10s %-12s %-12s\n", "IMAGE", "CURRENT", "TARGET")
+			fmt.Printf("%-10s %-12s %-12s\n", "metadata", current.Metadata, target.Metadata)
+			fmt.Printf("%-10s %-12s %-12s\n", "ui", current.UI, target.UI)
+			if err != nil {
+				return err
 			}
 
-			config := &MetaflowConfig{
-				METAFLOW_DEFAULT_METADATA:  pointer.ToString("service"),
-				METAFLOW_DEFAULT_DATASTORE: pointer.ToString("s3"),
+			if current == target {
+				fmt.Println("Already up to date")
+				return nil
+			}
+			if dryRun {
+				return nil
 			}
-			typeVar := reflect.ValueOf(config).Elem()
+			return ops.Upgrade(cmd.Context(), cdk(awsOptions), outputs, target)
+		},
+	}
+	upgradeCmd.Flags().StringVar(&requested.Metadata, "metadata-version", "", "target metadata service image tag, the deployed one when empty")
+	upgradeCmd.Flags().StringVar(&requested.UI, "ui-version", "", "target UI image tag, the deployed one when empty")
+	upgradeCmd.Flags().BoolVar(&allowDowngrade, "allow-downgrade", false, "allow a target older than the deployed version")
+	upgradeCmd.Flags().BoolVar(&dryRun, "dry-run", false, "only show the current and target versions")
+
+	var statusOutput string
 
-			for _, item := range resulsList {
-				name := item[0]
-				value := item[1]
-				if field := typeVar.FieldByName(name); field.IsValid() {
-					field.Set(reflect.ValueOf(&value))
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Check the health of every Metaflow component",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if statusOutput != "table" && statusOutput != "json" {
+				return fmt.Errorf("--output must be table or json, got %q", statusOutput)
+			}
+			client, err := awsapi.New(cmd.Context(), awsOptions)
+			if err != nil {
+				return err
+			}
+
+			report := ops.Status(cmd.Context(), client, func(region string) ops.CloudFormationAPI {
+				return client.InRegion(region)
+			}, &http.Client{Timeout: 10 * time.Second})
+			if statusOutput == "json" {
+				bytes, err := json.MarshalIndent(report, "", "  ")
+				if err != nil {
+					return fmt.Errorf("encoding status: %w", err)
 				}
+				fmt.Println(string(bytes))
+			} else {
+				fmt.Print(figlet)
+				printStatus(os.Stdout, report)
 			}
 
-			bytes, err := json.MarshalIndent(config, "", "  ")
+			if !report.Healthy {
+				return fmt.Errorf("the deployment is unhealthy")
+			}
+			return nil
+		},
+	}
+	statusCmd.Flags().StringVarP(&statusOutput, "output", "o", "table", "output format, table or json")
+
+	var preflightOutput string
 
+	preflightCmd := &cobra.Command{
+		Use:   "preflight",
+		Short: "Check the account, quotas and local files before deploying",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if preflightOutput != "table" && preflightOutput != "json" {
+				return fmt.Errorf("--output must be table or json, got %q", preflightOutput)
+			}
+			client, err := awsapi.New(cmd.Context(), awsOptions)
 			if err != nil {
-				fmt.Println("Error parsing JSON:", err)
-				return
+				return err
 			}
 
-			fmt.Println(string(bytes))
-			fmt.Println("Configuration for ~/.metaflowconfig/config.json")
+			report := ops.Preflight(cmd.Context(), client)
+			if preflightOutput == "json" {
+				bytes, err := json.MarshalIndent(report, "", "  ")
+				if err != nil {
+					return fmt.Errorf("encoding preflight report: %w", err)
+				}
+				fmt.Println(string(bytes))
+			} else {
+				fmt.Print(figlet)
+				printPreflight(os.Stdout, report)
+			}
+
+			if !report.Passed {
+				return fmt.Errorf("preflight checks failed")
+			}
+			return nil
 		},
 	}
+	preflightCmd.Flags().StringVarP(&preflightOutput, "output", "o", "table", "output format, table or json")
+
+	jobsCmd := &cobra.Command{
+		Use:   "jobs",
+		Short: "Inspect the Metaflow Batch jobs",
+	}
 
-	rootCmd.AddCommand(deployCmd, destroyCmd, metaflowConfigCmd)
+	var listQueue, listRunID, listOutput string
+	var since time.Duration
+
+	jobsListCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the jobs of the queue with the Metaflow task they run",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if listOutput != "table" && listOutput != "json" {
+				return fmt.Errorf("--output must be table or json, got %q", listOutput)
+			}
+			ctx := cmd.Context()
+			client, outputs, err := session(ctx, awsOptions)
+			if err != nil {
+				return err
+			}
+			queue, err := ops.JobQueue(ctx, outputs, listQueue)
+			if err != nil {
+				return err
+			}
+			jobs, err := ops.ListJobs(ctx, client, queue, time.Now().Add(-since), listRunID)
+			if err != nil {
+				return err
+			}
+
+			if listOutput == "json" {
+				bytes, err := json.MarshalIndent(jobs, "", "  ")
+				if err != nil {
+					return fmt.Errorf("encoding jobs: %w", err)
+				}
+				fmt.Println(string(bytes))
+				return nil
+			}
+			printJobs(os.Stdout, jobs)
+			return nil
+		},
+	}
+	jobsListCmd.Flags().StringVar(&listQueue, "queue", "", "job queue name or ARN, defaults to the Metaflow job queue")
+	jobsListCmd.Flags().DurationVar(&since, "since", 24*time.Hour, "list the jobs created in this window")
+	jobsListCmd.Flags().StringVar(&listRunID, "run-id", "", "only list the jobs of this Metaflow run")
+	jobsListCmd.Flags().StringVarP(&listOutput, "output", "o", "table", "output format, table or json")
+
+	var logGroup string
+	var follow bool
+	var cancelQueue, cancelRunID string
+	var cancelSince time.Duration
+	var cancelYes bool
+
+	jobsLogsCmd := &cobra.Command{
+		Use:   "logs <job-id>",
+		Short: "Print the CloudWatch logs of a job",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := awsapi.New(cmd.Context(), awsOptions)
+			if err != nil {
+				return err
+			}
+			return ops.StreamJobLogs(cmd.Context(), client, os.Stdout, args[0], logGroup, follow, 5*time.Second)
+		},
+	}
+	jobsLogsCmd.Flags().StringVar(&logGroup, "log-group", "", "log group of the job, defaults to its awslogs configuration or "+ops.DefaultLogGroup)
+	jobsLogsCmd.Flags().BoolVarP(&follow, "follow", "f", false, "keep streaming the logs until the job finishes")
+
+	jobsCancelCmd := &cobra.Command{
+		Use:   "cancel [job-id]...",
+		Short: "Terminate the given jobs, or every unfinished job of a Metaflow run",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (len(args) == 0) == (cancelRunID == "") {
+				return fmt.Errorf("give either job ids or --run-id")
+			}
+			ctx := cmd.Context()
+			client, outputs, err := session(ctx, awsOptions)
+			if err != nil {
+				return err
+			}
+
+			var jobs []ops.BatchJob
+			if cancelRunID != "" {
+				queue, err := ops.JobQueue(ctx, outputs, cancelQueue)
+				if err != nil {
+					return err
+				}
+				if jobs, err = ops.ListJobs(ctx, client, queue, time.Now().Add(-cancelSince), cancelRunID); err != nil {
+					return err
+				}
+			} else if jobs, err = client.DescribeJobs(ctx, args); err != nil {
+				return err
+			}
+			jobs = slices.DeleteFunc(jobs, func(job ops.BatchJob) bool {
+				return !slices.Contains(ops.ActiveJobStatuses, job.Status)
+			})
+			if len(jobs) == 0 {
+				fmt.Println("No unfinished jobs to cancel")
+				return nil
+			}
+
+			printJobs(os.Stdout, jobs)
+			if !cancelYes {
+				ok, err := confirm(cmd, fmt.Sprintf("Terminate %d job(s)?", len(jobs)))
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return fmt.Errorf("cancel not confirmed")
+				}
+			}
+
+			cancelled, err := ops.CancelJobs(ctx, client, jobs, "Cancelled with app jobs cancel")
+			for _, job := range cancelled {
+				fmt.Println("Terminated", job.JobId, ops.JobPathspec(job))
+			}
+			return err
+		},
+	}
+	jobsCancelCmd.Flags().StringVar(&cancelQueue, "queue", "", "job queue name or ARN, defaults to the Metaflow job queue")
+	jobsCancelCmd.Flags().DurationVar(&cancelSince, "since", 7*24*time.Hour, "look for the jobs of the run created in this window")
+	jobsCancelCmd.Flags().StringVar(&cancelRunID, "run-id", "", "terminate the unfinished jobs of this Metaflow run")
+	jobsCancelCmd.Flags().BoolVarP(&cancelYes, "yes", "y", false, "terminate without asking for confirmation")
+	jobsCmd.AddCommand(jobsListCmd, jobsLogsCmd, jobsCancelCmd)
+
+	notebookCmd := &cobra.Command{
+		Use:   "notebook",
+		Short: "Control the SageMaker notebook instance",
+	}
+
+	var notebook string
+	var timeout time.Duration
+
+	notebookStartCmd := &cobra.Command{
+		Use:   "start",
+		Short: "Start the notebook instance and wait until it is in service",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+			defer cancel()
+			client, outputs, err := session(ctx, awsOptions)
+			if err != nil {
+				return err
+			}
+			name, err := ops.NotebookName(ctx, outputs, notebook)
+			if err != nil {
+				return err
+			}
+
+			instance, err := ops.StartNotebook(ctx, client, name, os.Stdout, 15*time.Second)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s is InService at https://%s\n", name, instance.Url)
+			return nil
+		},
+	}
+	notebookStartCmd.Flags().DurationVar(&timeout, "timeout", 20*time.Minute, "how long to wait for the instance")
+
+	notebookStopCmd := &cobra.Command{
+		Use:   "stop",
+		Short: "Stop the notebook instance",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			client, outputs, err := session(ctx, awsOptions)
+			if err != nil {
+				return err
+			}
+			name, err := ops.NotebookName(ctx, outputs, notebook)
+			if err != nil {
+				return err
+			}
+
+			stopping, err := ops.StopNotebook(ctx, client, name)
+			if err != nil {
+				return err
+			}
+			if !stopping {
+				fmt.Printf("%s is already stopped\n", name)
+				return nil
+			}
+			fmt.Printf("Stopping %s\n", name)
+			return nil
+		},
+	}
+
+	var printURL bool
+
+	notebookOpenCmd := &cobra.Command{
+		Use:   "open",
+		Short: "Open Jupyter in the browser through a presigned URL",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			client, outputs, err := session(ctx, awsOptions)
+			if err != nil {
+				return err
+			}
+			name, err := ops.NotebookName(ctx, outputs, notebook)
+			if err != nil {
+				return err
+			}
+
+			url, err := ops.NotebookURL(ctx, client, name)
+			if err != nil {
+				return err
+			}
+			if printURL {
+				fmt.Println(url)
+				return nil
+			}
+			return openBrowser(url)
+		},
+	}
+	notebookOpenCmd.Flags().BoolVar(&printURL, "print-url", false, "print the presigned URL instead of opening the browser")
+
+	notebookStatusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the status of the notebook instance",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			client, outputs, err := session(ctx, awsOptions)
+			if err != nil {
+				return err
+			}
+			name, err := ops.NotebookName(ctx, outputs, notebook)
+			if err != nil {
+				return err
+			}
+
+			instance, err := client.DescribeNotebookInstance(ctx, name)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%-10s %s\n", "NAME", name)
+			fmt.Printf("%-10s %s\n", "STATUS", instance.NotebookInstanceStatus)
+			if instance.Url != "" {
+				fmt.Printf("%-10s https://%s\n", "URL", instance.Url)
+			}
+			if instance.FailureReason != "" {
+				fmt.Printf("%-10s %s\n", "FAILURE", instance.FailureReason)
+			}
+			return nil
+		},
+	}
+
+	notebookCmd.PersistentFlags().StringVar(&notebook, "name", "", "notebook instance name, defaults to the notebook instance of the deployment")
+	notebookCmd.AddCommand(notebookStartCmd, notebookStopCmd, notebookOpenCmd, notebookStatusCmd)
+
+	drCmd := &cobra.Command{
+		Use:   "dr",
+		Short: "Disaster recovery commands",
+	}
+
+	drStatusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the replication lag to the DR region",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fmt.Print(figlet)
+			client, outputs, err := session(cmd.Context(), awsOptions)
+			if err != nil {
+				return err
+			}
+			report, enabled, err := ops.DRStatus(cmd.Context(), outputs, client, func(region string) ops.BackupAPI {
+				return client.InRegion(region)
+			})
+			if err != nil {
+				return err
+			}
+			if !enabled {
+				fmt.Println("Disaster recovery is not enabled, set disasterRecovery.enabled and deploy")
+				return nil
+			}
+
+			lastCopy := "None"
+			if report.LatestSnapshotCopyTime != nil {
+				lastCopy = fmt.Sprintf("%s ago", time.Since(*report.LatestSnapshotCopyTime).Round(time.Minute))
+			}
+			fmt.Printf("Secondary region: %s (max over the last hour)\n", report.Region)
+			fmt.Printf("%-32s %s\n", "REPLICA", "LAG")
+			fmt.Printf("%-32s %s s, %s operations pending\n", "datastore (S3)", metric(report.DatastoreLagSeconds), metric(report.DatastorePendingOps))
+			fmt.Printf("%-32s %s ms\n", commons.StateTableName+" (DynamoDB)", metric(report.StateTableLagMillis))
+			fmt.Printf("%-32s last copy %s\n", "metadata DB snapshots (Backup)", lastCopy)
+			return nil
+		},
+	}
+	drCmd.AddCommand(drStatusCmd)
+
+	rootCmd.AddCommand(preflightCmd, deployCmd, destroyCmd, diffCmd, metaflowConfigCmd, outputsCmd, upgradeCmd, statusCmd, jobsCmd, notebookCmd, drCmd)
+	return rootCmd
+}
+
+// cdk runs the CDK CLI against the selected profile and region.
+func cdk(options awsapi.Options) ops.CDK {
+	return ops.CDKCLI{Profile: options.Profile, Region: options.Region, Stdout: os.Stdout, Stderr: os.Stderr}
+}
 
-	if err := rootCmd.Execute(); err != nil {
-		panic(err)
+// session opens the AWS client and the outputs cache of the selected stage.
+func session(ctx context.Context, options awsapi.Options) (*awsapi.Client, *ops.OutputsCache, error) {
+	client, err := awsapi.New(ctx, options)
+	if err != nil {
+		return nil, nil, err
 	}
+	outputs := ops.NewOutputsCache(client)
+	outputs.Warnings = os.Stderr
+	return client, outputs, nil
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeCDK puts a cdk on the PATH that records its arguments and exits with code.
+func fakeCDK(t *testing.T, code string) string {
+	t.Helper()
+	dir := t.TempDir()
+	args := filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$@\" > \"$CDK_ARGS\"\nexit " + code + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "cdk"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("CDK_ARGS", args)
+	return args
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		cdk  string
+		code int
+	}{
+		{"cdk succeeds", []string{"diff"}, "0", 0},
+		{"cdk fails", []string{"diff"}, "3", 3},
+		{"differences with --fail", []string{"diff", "--fail"}, "1", 1},
+		{"command fails", []string{"no-such-command"}, "0", 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fakeCDK(t, test.cdk)
+			cmd := rootCommand()
+			cmd.SetArgs(test.args)
+			cmd.SetOut(&strings.Builder{})
+			cmd.SetErr(&strings.Builder{})
+
+			code := 0
+			if err := cmd.ExecuteContext(context.Background()); err != nil {
+				code = exitCode(err)
+			}
+			if code != test.code {
+				t.Errorf("%v exits with %d, want %d", test.args, code, test.code)
+			}
+		})
+	}
+}
+
+func TestRegionReachesCDK(t *testing.T) {
+	args := fakeCDK(t, "0")
+	cmd := rootCommand()
+	cmd.SetArgs([]string{"diff", "--region", "eu-west-1"})
+	if err := cmd.ExecuteContext(context.Background()); err != nil {
+		t.Fatalf("diff = %v", err)
+	}
+
+	recorded, err := os.ReadFile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(recorded), "-c metaflowRegion=eu-west-1") {
+		t.Errorf("cdk ran with %q, want the region as context", recorded)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/pkg/ops"
+	"github.com/spf13/cobra"
+)
+
+func printStatus(w io.Writer, report ops.StatusReport) {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "COMPONENT\tSTATUS\tHEALTHY\tDETAIL")
+	for _, component := range report.Components {
+		fmt.Fprintf(table, "%s\t%s\t%t\t%s\n", component.Component, component.Status, component.Healthy, component.Detail)
+	}
+	table.Flush()
+}
+
+func printPreflight(w io.Writer, report ops.PreflightReport) {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "CHECK\tRESULT\tDETAIL")
+	for _, check := range report.Checks {
+		fmt.Fprintf(table, "%s\t%s\t%s\n", check.Check, check.Result, check.Detail)
+	}
+	table.Flush()
+}
+
+func printJobs(w io.Writer, jobs []ops.BatchJob) {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "JOB ID\tSTATUS\tPATHSPEC\tCREATED\tREASON")
+	for _, job := range jobs {
+		reason := job.StatusReason
+		if job.Container.Reason != "" {
+			reason = job.Container.Reason
+		}
+		created := time.UnixMilli(job.CreatedAt).Local().Format(time.DateTime)
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", job.JobId, job.Status, ops.JobPathspec(job), created, reason)
+	}
+	table.Flush()
+}
+
+// printOutputsPath tells where a deploy or a destroy wrote the outputs of the stage.
+func printOutputsPath(ctx context.Context, outputs *ops.OutputsCache) error {
+	stage, err := outputs.Stage(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Stack outputs written to", ops.OutputsPath(stage))
+	return nil
+}
+
+// metric formats a metric maximum, "None" without data.
+func metric(value *float64) string {
+	if value == nil {
+		return "None"
+	}
+	return fmt.Sprint(*value)
+}
+
+func warn(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "Warning: "+format+"\n", args...)
+}
+
+func confirm(cmd *cobra.Command, prompt string) (bool, error) {
+	fmt.Fprintf(cmd.OutOrStdout(), "%s [y/N] ", prompt)
+	answer, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+// openBrowser opens url with the desktop's default browser.
+func openBrowser(url string) error {
+	var command *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		command = exec.Command("open", url)
+	case "windows":
+		command = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		command = exec.Command("xdg-open", url)
+	}
+	if err := command.Start(); err != nil {
+		return fmt.Errorf("opening the browser: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package awsapi_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/awsapi"
+	"github.com/aws/smithy-go/encoding/cbor"
+)
+
+// client returns a client sending every call to handler, with static credentials and no shared config.
+func client(t *testing.T, handler http.HandlerFunc) *awsapi.Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	t.Setenv("AWS_CONFIG_FILE", missing)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", missing)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_PROFILE", "")
+
+	c, err := awsapi.New(context.Background(), awsapi.Options{Region: "us-east-2", EndpointURL: server.URL})
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+	return c
+}
+
+const describeStacksResponse = `<DescribeStacksResponse xmlns="http://cloudformation.amazonaws.com/doc/2010-05-15/">
+  <DescribeStacksResult>
+    <Stacks>
+      <member>
+        <StackName>ResultStack</StackName>
+        <StackStatus>UPDATE_COMPLETE</StackStatus>
+        <CreationTime>2026-01-02T03:04:05Z</CreationTime>
+        <Outputs>
+          <member>
+            <OutputKey>METAFLOWBATCHJOBQUEUE</OutputKey>
+            <OutputValue>arn:aws:batch:us-east-2:123456789012:job-queue/metaflow</OutputValue>
+            <Description>METAFLOW_BATCH_JOB_QUEUE</Description>
+          </member>
+        </Outputs>
+      </member>
+    </Stacks>
+  </DescribeStacksResult>
+</DescribeStacksResponse>`
+
+const stackNotFoundResponse = `<ErrorResponse xmlns="http://cloudformation.amazonaws.com/doc/2010-05-15/">
+  <Error>
+    <Type>Sender</Type>
+    <Code>ValidationError</Code>
+    <Message>Stack with id ResultStack does not exist</Message>
+  </Error>
+  <RequestId>1</RequestId>
+</ErrorResponse>`
+
+const accessDeniedResponse = `<ErrorResponse xmlns="http://cloudformation.amazonaws.com/doc/2010-05-15/">
+  <Error>
+    <Type>Sender</Type>
+    <Code>AccessDenied</Code>
+    <Message>not authorized to perform cloudformation:DescribeStacks</Message>
+  </Error>
+  <RequestId>1</RequestId>
+</ErrorResponse>`
+
+func TestDescribeStack(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		err      error
+	}{
+		{"deployed", http.StatusOK, describeStacksResponse, nil},
+		{"not deployed", http.StatusBadRequest, stackNotFoundResponse, awsapi.ErrStackNotFound},
+		{"denied", http.StatusForbidden, accessDeniedResponse, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var params url.Values
+			c := client(t, func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				params, _ = url.ParseQuery(string(body))
+				w.Header().Set("Content-Type", "text/xml")
+				w.WriteHeader(test.status)
+				io.WriteString(w, test.response)
+			})
+
+			stack, err := c.DescribeStack(context.Background(), "ResultStack")
+			if params.Get("Action") != "DescribeStacks" || params.Get("StackName") != "ResultStack" {
+				t.Errorf("request = %v, want DescribeStacks of ResultStack", params)
+			}
+
+			switch {
+			case test.status == http.StatusForbidden:
+				if err == nil || errors.Is(err, awsapi.ErrStackNotFound) {
+					t.Fatalf("DescribeStack() = %v, want the access error", err)
+				}
+			case test.err != nil:
+				if !errors.Is(err, test.err) {
+					t.Fatalf("DescribeStack() = %v, want %v", err, test.err)
+				}
+			case err != nil:
+				t.Fatalf("DescribeStack() = %v", err)
+			default:
+				if stack.StackStatus != "UPDATE_COMPLETE" || !stack.CreationTime.Equal(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)) {
+					t.Errorf("DescribeStack() = %+v", stack)
+				}
+				if value, ok := stack.Output("METAFLOW_BATCH_JOB_QUEUE"); !ok || value != "arn:aws:batch:us-east-2:123456789012:job-queue/metaflow" {
+					t.Errorf("Output(METAFLOW_BATCH_JOB_QUEUE) = %q, %v", value, ok)
+				}
+			}
+		})
+	}
+}
+
+func TestMetricMaximum(t *testing.T) {
+	tests := []struct {
+		name       string
+		datapoints cbor.List
+		maximum    float64
+		found      bool
+	}{
+		{"no data", cbor.List{}, 0, false},
+		{"datapoints", cbor.List{
+			cbor.Map{"Maximum": cbor.Float64(12)},
+			cbor.Map{"Maximum": cbor.Float64(30.5)},
+			cbor.Map{"Maximum": cbor.Float64(4)},
+		}, 30.5, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var request cbor.Map
+			c := client(t, func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if decoded, err := cbor.Decode(body); err == nil {
+					request, _ = decoded.(cbor.Map)
+				}
+				w.Header().Set("Content-Type", "application/cbor")
+				w.Header().Set("smithy-protocol", "rpc-v2-cbor")
+				w.Write(cbor.Encode(cbor.Map{"Datapoints": test.datapoints}))
+			})
+
+			end := time.Now()
+			maximum, found, err := c.MetricMaximum(context.Background(), "AWS/S3", "ReplicationLatency", end.Add(-time.Hour), end, map[string]string{"SourceBucket": "metaflow"})
+			if err != nil {
+				t.Fatalf("MetricMaximum() = %v", err)
+			}
+			if maximum != test.maximum || found != test.found {
+				t.Errorf("MetricMaximum() = %v, %v, want %v, %v", maximum, found, test.maximum, test.found)
+			}
+
+			if request["MetricName"] != cbor.String("ReplicationLatency") || request["Namespace"] != cbor.String("AWS/S3") {
+				t.Errorf("request = %v, want ReplicationLatency in AWS/S3", request)
+			}
+			dimensions, _ := request["Dimensions"].(cbor.List)
+			if len(dimensions) != 1 {
+				t.Fatalf("request dimensions = %v, want SourceBucket", request["Dimensions"])
+			}
+			if dimension, _ := dimensions[0].(cbor.Map); dimension["Name"] != cbor.String("SourceBucket") || dimension["Value"] != cbor.String("metaflow") {
+				t.Errorf("request dimension = %v, want SourceBucket=metaflow", dimension)
+			}
+		})
+	}
+}
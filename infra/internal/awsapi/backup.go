@@ -0,0 +1,30 @@
+package awsapi
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/backup"
+)
+
+// LatestRecoveryPoint returns the creation time of the newest recovery point in the vault, false when it is empty.
+func (c *Client) LatestRecoveryPoint(ctx context.Context, vault string) (time.Time, bool, error) {
+	var latest time.Time
+	pages := backup.NewListRecoveryPointsByBackupVaultPaginator(backup.NewFromConfig(c.Config), &backup.ListRecoveryPointsByBackupVaultInput{
+		BackupVaultName: aws.String(vault),
+	})
+	for pages.HasMorePages() {
+		out, err := pages.NextPage(ctx)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		for _, point := range out.RecoveryPoints {
+			if created := aws.ToTime(point.CreationDate); created.After(latest) {
+				latest = created
+			}
+		}
+	}
+
+	return latest, !latest.IsZero(), nil
+}
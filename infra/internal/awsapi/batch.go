@@ -0,0 +1,158 @@
+package awsapi
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/batch"
+	"github.com/aws/aws-sdk-go-v2/service/batch/types"
+)
+
+// BatchResource is the state of a compute environment or a job queue.
+type BatchResource struct {
+	Name         string
+	State        string
+	Status       string
+	StatusReason string
+}
+
+func (c *Client) DescribeComputeEnvironment(ctx context.Context, name string) (BatchResource, error) {
+	out, err := batch.NewFromConfig(c.Config).DescribeComputeEnvironments(ctx, &batch.DescribeComputeEnvironmentsInput{
+		ComputeEnvironments: []string{name},
+	})
+	if err != nil {
+		return BatchResource{}, err
+	}
+	if len(out.ComputeEnvironments) == 0 {
+		return BatchResource{}, fmt.Errorf("compute environment %s not found", name)
+	}
+
+	environment := out.ComputeEnvironments[0]
+	return BatchResource{
+		Name:         aws.ToString(environment.ComputeEnvironmentName),
+		State:        string(environment.State),
+		Status:       string(environment.Status),
+		StatusReason: aws.ToString(environment.StatusReason),
+	}, nil
+}
+
+func (c *Client) DescribeJobQueue(ctx context.Context, name string) (BatchResource, error) {
+	out, err := batch.NewFromConfig(c.Config).DescribeJobQueues(ctx, &batch.DescribeJobQueuesInput{
+		JobQueues: []string{name},
+	})
+	if err != nil {
+		return BatchResource{}, err
+	}
+	if len(out.JobQueues) == 0 {
+		return BatchResource{}, fmt.Errorf("job queue %s not found", name)
+	}
+
+	queue := out.JobQueues[0]
+	return BatchResource{
+		Name:         aws.ToString(queue.JobQueueName),
+		State:        string(queue.State),
+		Status:       string(queue.Status),
+		StatusReason: aws.ToString(queue.StatusReason),
+	}, nil
+}
+
+type BatchJob struct {
+	JobId        string
+	JobName      string
+	JobQueue     string
+	Status       string
+	StatusReason string
+	CreatedAt    int64
+	StartedAt    int64
+	StoppedAt    int64
+	Tags         map[string]string
+	Container    BatchContainer
+}
+
+type BatchContainer struct {
+	ExitCode         *int
+	Reason           string
+	LogStreamName    string
+	LogConfiguration LogConfiguration
+}
+
+type LogConfiguration struct {
+	LogDriver string
+	Options   map[string]string
+}
+
+// ListJobs returns the ids of the jobs of the queue created after since, whatever their status.
+func (c *Client) ListJobs(ctx context.Context, queue string, since time.Time) ([]string, error) {
+	var ids []string
+	pages := batch.NewListJobsPaginator(batch.NewFromConfig(c.Config), &batch.ListJobsInput{
+		JobQueue: aws.String(queue),
+		Filters: []types.KeyValuesPair{
+			{Name: aws.String("AFTER_CREATED_AT"), Values: []string{strconv.FormatInt(since.UnixMilli(), 10)}},
+		},
+	})
+	for pages.HasMorePages() {
+		out, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, job := range out.JobSummaryList {
+			ids = append(ids, aws.ToString(job.JobId))
+		}
+	}
+	return ids, nil
+}
+
+// DescribeJobs returns the jobs with their tags and log streams, jobs that no longer exist are left out.
+func (c *Client) DescribeJobs(ctx context.Context, ids []string) ([]BatchJob, error) {
+	client := batch.NewFromConfig(c.Config)
+	var jobs []BatchJob
+	for chunk := range slices.Chunk(ids, 100) {
+		out, err := client.DescribeJobs(ctx, &batch.DescribeJobsInput{Jobs: chunk})
+		if err != nil {
+			return nil, err
+		}
+		for _, job := range out.Jobs {
+			jobs = append(jobs, batchJob(job))
+		}
+	}
+	return jobs, nil
+}
+
+func batchJob(job types.JobDetail) BatchJob {
+	result := BatchJob{
+		JobId:        aws.ToString(job.JobId),
+		JobName:      aws.ToString(job.JobName),
+		JobQueue:     aws.ToString(job.JobQueue),
+		Status:       string(job.Status),
+		StatusReason: aws.ToString(job.StatusReason),
+		CreatedAt:    aws.ToInt64(job.CreatedAt),
+		StartedAt:    aws.ToInt64(job.StartedAt),
+		StoppedAt:    aws.ToInt64(job.StoppedAt),
+		Tags:         job.Tags,
+	}
+	if container := job.Container; container != nil {
+		result.Container.Reason = aws.ToString(container.Reason)
+		result.Container.LogStreamName = aws.ToString(container.LogStreamName)
+		if container.ExitCode != nil {
+			exitCode := int(*container.ExitCode)
+			result.Container.ExitCode = &exitCode
+		}
+		if logs := container.LogConfiguration; logs != nil {
+			result.Container.LogConfiguration = LogConfiguration{LogDriver: string(logs.LogDriver), Options: logs.Options}
+		}
+	}
+	return result
+}
+
+// TerminateJob stops a running job or cancels it when it has not started yet.
+func (c *Client) TerminateJob(ctx context.Context, id, reason string) error {
+	_, err := batch.NewFromConfig(c.Config).TerminateJob(ctx, &batch.TerminateJobInput{
+		JobId:  aws.String(id),
+		Reason: aws.String(reason),
+	})
+	return err
+}
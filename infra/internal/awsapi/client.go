@@ -0,0 +1,68 @@
+// Package awsapi calls the few AWS APIs the CLI needs through the AWS SDK for Go v2 service clients, returning
+// the small types pkg/ops works with.
+package awsapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/smithy-go"
+)
+
+// Options select the credentials and the endpoint. EndpointURL sends every call to one endpoint, such as a
+// LocalStack or moto server.
+type Options struct {
+	Profile     string
+	Region      string
+	EndpointURL string
+}
+
+type Client struct {
+	Config aws.Config
+}
+
+func New(ctx context.Context, options Options) (*Client, error) {
+	var loadOptions []func(*config.LoadOptions) error
+	if options.Profile != "" {
+		loadOptions = append(loadOptions, config.WithSharedConfigProfile(options.Profile))
+	}
+	if options.Region != "" {
+		loadOptions = append(loadOptions, config.WithRegion(options.Region))
+	}
+	if options.EndpointURL != "" {
+		loadOptions = append(loadOptions, config.WithBaseEndpoint(options.EndpointURL))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, loadOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS configuration: %w", err)
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("no AWS region, set --region or configure one for the profile")
+	}
+
+	return &Client{Config: cfg}, nil
+}
+
+func (c *Client) Region() string {
+	return c.Config.Region
+}
+
+// InRegion returns a client with the same credentials calling region.
+func (c *Client) InRegion(region string) *Client {
+	regional := c.Config.Copy()
+	regional.Region = region
+	return &Client{Config: regional}
+}
+
+// errorCode returns the code of the AWS error in err, empty when err is not one.
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+package awsapi
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+var ErrStackNotFound = errors.New("stack not found")
+
+type Stack struct {
+	StackName         string
+	StackStatus       string
+	StackStatusReason string
+	CreationTime      time.Time
+	LastUpdatedTime   time.Time
+	Outputs           []Output
+}
+
+type Output struct {
+	OutputKey   string
+	OutputValue string
+	Description string
+}
+
+// Output looks an output up by its description, the stacks describe their outputs with the Metaflow
+// setting they fill.
+func (s Stack) Output(description string) (string, bool) {
+	for _, output := range s.Outputs {
+		if output.Description == description {
+			return output.OutputValue, true
+		}
+	}
+	return "", false
+}
+
+// DescribeStack returns ErrStackNotFound when the stack is not deployed.
+func (c *Client) DescribeStack(ctx context.Context, name string) (Stack, error) {
+	out, err := cloudformation.NewFromConfig(c.Config).DescribeStacks(ctx, &cloudformation.DescribeStacksInput{
+		StackName: aws.String(name),
+	})
+	if err != nil && errorCode(err) == "ValidationError" && strings.Contains(err.Error(), "does not exist") {
+		return Stack{}, ErrStackNotFound
+	}
+	if err != nil {
+		return Stack{}, err
+	}
+	if len(out.Stacks) == 0 {
+		return Stack{}, ErrStackNotFound
+	}
+
+	return stack(out.Stacks[0]), nil
+}
+
+// DescribeStacks returns every stack of the account in the region.
+func (c *Client) DescribeStacks(ctx context.Context) ([]Stack, error) {
+	var stacks []Stack
+	pages := cloudformation.NewDescribeStacksPaginator(cloudformation.NewFromConfig(c.Config), &cloudformation.DescribeStacksInput{})
+	for pages.HasMorePages() {
+		out, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, described := range out.Stacks {
+			stacks = append(stacks, stack(described))
+		}
+	}
+	return stacks, nil
+}
+
+func stack(described types.Stack) Stack {
+	result := Stack{
+		StackName:         aws.ToString(described.StackName),
+		StackStatus:       string(described.StackStatus),
+		StackStatusReason: aws.ToString(described.StackStatusReason),
+		CreationTime:      aws.ToTime(described.CreationTime),
+		LastUpdatedTime:   aws.ToTime(described.LastUpdatedTime),
+	}
+	for _, output := range described.Outputs {
+		result.Outputs = append(result.Outputs, Output{
+			OutputKey:   aws.ToString(output.OutputKey),
+			OutputValue: aws.ToString(output.OutputValue),
+			Description: aws.ToString(output.Description),
+		})
+	}
+	return result
+}
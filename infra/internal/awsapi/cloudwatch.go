@@ -0,0 +1,39 @@
+package awsapi
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// MetricMaximum returns the highest datapoint of the metric between start and end, false without data.
+func (c *Client) MetricMaximum(ctx context.Context, namespace, metric string, start, end time.Time, dimensions map[string]string) (float64, bool, error) {
+	in := &cloudwatch.GetMetricStatisticsInput{
+		Namespace:  aws.String(namespace),
+		MetricName: aws.String(metric),
+		StartTime:  aws.Time(start),
+		EndTime:    aws.Time(end),
+		Period:     aws.Int32(300),
+		Statistics: []types.Statistic{types.StatisticMaximum},
+	}
+	for name, value := range dimensions {
+		in.Dimensions = append(in.Dimensions, types.Dimension{Name: aws.String(name), Value: aws.String(value)})
+	}
+
+	out, err := cloudwatch.NewFromConfig(c.Config).GetMetricStatistics(ctx, in)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if len(out.Datapoints) == 0 {
+		return 0, false, nil
+	}
+	maximum := aws.ToFloat64(out.Datapoints[0].Maximum)
+	for _, datapoint := range out.Datapoints[1:] {
+		maximum = max(maximum, aws.ToFloat64(datapoint.Maximum))
+	}
+	return maximum, true, nil
+}
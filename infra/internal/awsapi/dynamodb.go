@@ -0,0 +1,23 @@
+package awsapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// TableExists tells whether the region has a table with this name.
+func (c *Client) TableExists(ctx context.Context, name string) (bool, error) {
+	_, err := dynamodb.NewFromConfig(c.Config).DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(name),
+	})
+
+	var notFound *types.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,47 @@
+package awsapi
+
+import (
+	"context"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// AvailabilityZones returns the names of the available zones of the region, sorted like the CDK context lookup.
+func (c *Client) AvailabilityZones(ctx context.Context) ([]string, error) {
+	out, err := ec2.NewFromConfig(c.Config).DescribeAvailabilityZones(ctx, &ec2.DescribeAvailabilityZonesInput{
+		Filters: []types.Filter{{Name: aws.String("state"), Values: []string{"available"}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var zones []string
+	for _, zone := range out.AvailabilityZones {
+		zones = append(zones, aws.ToString(zone.ZoneName))
+	}
+	slices.Sort(zones)
+	return zones, nil
+}
+
+// InstanceTypeZones returns the availability zones offering the instance type.
+func (c *Client) InstanceTypeZones(ctx context.Context, instanceType string) ([]string, error) {
+	var zones []string
+	pages := ec2.NewDescribeInstanceTypeOfferingsPaginator(ec2.NewFromConfig(c.Config), &ec2.DescribeInstanceTypeOfferingsInput{
+		LocationType: types.LocationTypeAvailabilityZone,
+		Filters:      []types.Filter{{Name: aws.String("instance-type"), Values: []string{instanceType}}},
+	})
+	for pages.HasMorePages() {
+		out, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, offering := range out.InstanceTypeOfferings {
+			zones = append(zones, aws.ToString(offering.Location))
+		}
+	}
+	slices.Sort(zones)
+	return zones, nil
+}
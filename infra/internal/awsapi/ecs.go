@@ -0,0 +1,42 @@
+package awsapi
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+)
+
+type Service struct {
+	ServiceName  string
+	Status       string
+	RunningCount int
+	DesiredCount int
+	PendingCount int
+}
+
+func (c *Client) DescribeService(ctx context.Context, cluster, service string) (Service, error) {
+	out, err := ecs.NewFromConfig(c.Config).DescribeServices(ctx, &ecs.DescribeServicesInput{
+		Cluster:  aws.String(cluster),
+		Services: []string{service},
+	})
+	if err != nil {
+		return Service{}, err
+	}
+	if len(out.Services) == 0 {
+		if len(out.Failures) > 0 {
+			return Service{}, fmt.Errorf("service %s: %s", service, aws.ToString(out.Failures[0].Reason))
+		}
+		return Service{}, fmt.Errorf("service %s not found", service)
+	}
+
+	described := out.Services[0]
+	return Service{
+		ServiceName:  aws.ToString(described.ServiceName),
+		Status:       aws.ToString(described.Status),
+		RunningCount: int(described.RunningCount),
+		DesiredCount: int(described.DesiredCount),
+		PendingCount: int(described.PendingCount),
+	}, nil
+}
@@ -0,0 +1,21 @@
+package awsapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+// RoleExists tells whether the account has a role with this name.
+func (c *Client) RoleExists(ctx context.Context, name string) (bool, error) {
+	_, err := iam.NewFromConfig(c.Config).GetRole(ctx, &iam.GetRoleInput{RoleName: aws.String(name)})
+
+	var notFound *types.NoSuchEntityException
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
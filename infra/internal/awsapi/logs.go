@@ -0,0 +1,41 @@
+package awsapi
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+)
+
+type LogEvent struct {
+	Timestamp int64
+	Message   string
+}
+
+func (e LogEvent) Time() time.Time {
+	return time.UnixMilli(e.Timestamp)
+}
+
+// GetLogEvents reads a log stream from the start, passing the returned token back reads the events written since.
+func (c *Client) GetLogEvents(ctx context.Context, group, stream, token string) ([]LogEvent, string, error) {
+	in := &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String(group),
+		LogStreamName: aws.String(stream),
+		StartFromHead: aws.Bool(true),
+	}
+	if token != "" {
+		in.NextToken = aws.String(token)
+	}
+
+	out, err := cloudwatchlogs.NewFromConfig(c.Config).GetLogEvents(ctx, in)
+	if err != nil {
+		return nil, "", err
+	}
+
+	events := make([]LogEvent, len(out.Events))
+	for i, event := range out.Events {
+		events[i] = LogEvent{Timestamp: aws.ToInt64(event.Timestamp), Message: aws.ToString(event.Message)}
+	}
+	return events, aws.ToString(out.NextForwardToken), nil
+}
@@ -0,0 +1,33 @@
+package awsapi
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+)
+
+// DatabaseStatus returns the status of the DB instance, or of the DB cluster when cluster is set.
+func (c *Client) DatabaseStatus(ctx context.Context, identifier string, cluster bool) (string, error) {
+	client := rds.NewFromConfig(c.Config)
+	if cluster {
+		out, err := client.DescribeDBClusters(ctx, &rds.DescribeDBClustersInput{DBClusterIdentifier: aws.String(identifier)})
+		if err != nil {
+			return "", err
+		}
+		if len(out.DBClusters) == 0 {
+			return "", fmt.Errorf("DB cluster %s not found", identifier)
+		}
+		return aws.ToString(out.DBClusters[0].Status), nil
+	}
+
+	out, err := client.DescribeDBInstances(ctx, &rds.DescribeDBInstancesInput{DBInstanceIdentifier: aws.String(identifier)})
+	if err != nil {
+		return "", err
+	}
+	if len(out.DBInstances) == 0 {
+		return "", fmt.Errorf("DB instance %s not found", identifier)
+	}
+	return aws.ToString(out.DBInstances[0].DBInstanceStatus), nil
+}
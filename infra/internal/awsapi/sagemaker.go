@@ -0,0 +1,55 @@
+package awsapi
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sagemaker"
+)
+
+type NotebookInstance struct {
+	NotebookInstanceName   string
+	NotebookInstanceStatus string
+	FailureReason          string
+	Url                    string
+}
+
+func (c *Client) DescribeNotebookInstance(ctx context.Context, name string) (NotebookInstance, error) {
+	out, err := sagemaker.NewFromConfig(c.Config).DescribeNotebookInstance(ctx, &sagemaker.DescribeNotebookInstanceInput{
+		NotebookInstanceName: aws.String(name),
+	})
+	if err != nil {
+		return NotebookInstance{}, err
+	}
+	return NotebookInstance{
+		NotebookInstanceName:   aws.ToString(out.NotebookInstanceName),
+		NotebookInstanceStatus: string(out.NotebookInstanceStatus),
+		FailureReason:          aws.ToString(out.FailureReason),
+		Url:                    aws.ToString(out.Url),
+	}, nil
+}
+
+func (c *Client) StartNotebookInstance(ctx context.Context, name string) error {
+	_, err := sagemaker.NewFromConfig(c.Config).StartNotebookInstance(ctx, &sagemaker.StartNotebookInstanceInput{
+		NotebookInstanceName: aws.String(name),
+	})
+	return err
+}
+
+func (c *Client) StopNotebookInstance(ctx context.Context, name string) error {
+	_, err := sagemaker.NewFromConfig(c.Config).StopNotebookInstance(ctx, &sagemaker.StopNotebookInstanceInput{
+		NotebookInstanceName: aws.String(name),
+	})
+	return err
+}
+
+// PresignedNotebookURL returns a URL that signs the caller into the Jupyter server of the instance.
+func (c *Client) PresignedNotebookURL(ctx context.Context, name string) (string, error) {
+	out, err := sagemaker.NewFromConfig(c.Config).CreatePresignedNotebookInstanceUrl(ctx, &sagemaker.CreatePresignedNotebookInstanceUrlInput{
+		NotebookInstanceName: aws.String(name),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.AuthorizedUrl), nil
+}
@@ -0,0 +1,35 @@
+package awsapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/servicequotas"
+	"github.com/aws/aws-sdk-go-v2/service/servicequotas/types"
+)
+
+// ServiceQuota returns the applied value of the quota, or its AWS default when it was never changed.
+func (c *Client) ServiceQuota(ctx context.Context, service, code string) (float64, error) {
+	client := servicequotas.NewFromConfig(c.Config)
+	applied, err := client.GetServiceQuota(ctx, &servicequotas.GetServiceQuotaInput{
+		ServiceCode: aws.String(service),
+		QuotaCode:   aws.String(code),
+	})
+
+	var notFound *types.NoSuchResourceException
+	if errors.As(err, &notFound) {
+		defaults, err := client.GetAWSDefaultServiceQuota(ctx, &servicequotas.GetAWSDefaultServiceQuotaInput{
+			ServiceCode: aws.String(service),
+			QuotaCode:   aws.String(code),
+		})
+		if err != nil {
+			return 0, err
+		}
+		return aws.ToFloat64(defaults.Quota.Value), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return aws.ToFloat64(applied.Quota.Value), nil
+}
@@ -0,0 +1,24 @@
+package awsapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// GetParameter returns the value of the parameter, false when it does not exist.
+func (c *Client) GetParameter(ctx context.Context, name string) (string, bool, error) {
+	out, err := ssm.NewFromConfig(c.Config).GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String(name)})
+
+	var notFound *types.ParameterNotFound
+	if errors.As(err, &notFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return aws.ToString(out.Parameter.Value), true, nil
+}
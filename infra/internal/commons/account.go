@@ -1,6 +1,8 @@
 package commons
 
 import (
+	"fmt"
+
 	"github.com/aws/aws-cdk-go/awscdk/v2"
 )
 
@@ -17,6 +19,10 @@ func (a *Account) Env() *awscdk.Environment {
 	}
 }
 
+func (a *Account) Registry() string {
+	return fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com", a.AccountId, a.Region)
+}
+
 type IStack interface {
 	GetStack() awscdk.Stack
 	GetName() string
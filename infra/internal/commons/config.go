@@ -0,0 +1,436 @@
+package commons
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+)
+
+// Config holds the per-deployment settings read from the "metaflow" CDK context key.
+type Config struct {
+	MetadataService  ServiceConfig          `json:"metadataService"`
+	UIService        ServiceConfig          `json:"uiService"`
+	Images           ImagesConfig           `json:"images"`
+	Persistence      PersistenceConfig      `json:"persistence"`
+	Datastore        DatastoreConfig        `json:"datastore"`
+	DisasterRecovery DisasterRecoveryConfig `json:"disasterRecovery"`
+	Scheduling       SchedulingConfig       `json:"scheduling"`
+	Kubernetes       KubernetesConfig       `json:"kubernetes"`
+	Observability    ObservabilityConfig    `json:"observability"`
+	Compliance       ComplianceConfig       `json:"compliance"`
+}
+
+// ServiceConfig sizes a Fargate service and bounds its autoscaling.
+type ServiceConfig struct {
+	Cpu                   int     `json:"cpu"`
+	MemoryMiB             int     `json:"memoryMiB"`
+	MinTasks              int     `json:"minTasks"`
+	MaxTasks              int     `json:"maxTasks"`
+	TargetCPUUtilization  float64 `json:"targetCpuUtilization"`
+	TargetActiveFlows     float64 `json:"targetActiveFlows"`
+	TargetRequestsPerTask float64 `json:"targetRequestsPerTask"`
+}
+
+// ImagesConfig pins the Metaflow image versions. With PullThroughCache the images are pulled
+// through ECR cache rules; Docker Hub is only mirrored when DockerHubCredentialArn is set.
+type ImagesConfig struct {
+	MetadataVersion        string `json:"metadataVersion"`
+	UIVersion              string `json:"uiVersion"`
+	PullThroughCache       bool   `json:"pullThroughCache"`
+	DockerHubCredentialArn string `json:"dockerHubCredentialArn"`
+}
+
+func (i ImagesConfig) MetadataImage(account Account) string {
+	if i.PullThroughCache && i.DockerHubCredentialArn != "" {
+		return fmt.Sprintf("%s/%s/%s:%s", account.Registry(), DockerHubCachePrefix, MetaflowMetadataRepository, i.MetadataVersion)
+	}
+	return fmt.Sprintf("%s:%s", MetaflowMetadataRepository, i.MetadataVersion)
+}
+
+func (i ImagesConfig) StaticUIImage(account Account) string {
+	if i.PullThroughCache {
+		return fmt.Sprintf("%s/%s/%s:%s", account.Registry(), ECRPublicCachePrefix, MetaflowStaticUIRepository, i.UIVersion)
+	}
+	return fmt.Sprintf("%s/%s:%s", MetaflowStaticUIRegistry, MetaflowStaticUIRepository, i.UIVersion)
+}
+
+// AWSCLIImage is the image of the sidecars that fetch IAM auth tokens for the RDS Proxy, from ECR Public like the static UI.
+func (i ImagesConfig) AWSCLIImage(account Account) string {
+	if i.PullThroughCache {
+		return fmt.Sprintf("%s/%s/%s:%s", account.Registry(), ECRPublicCachePrefix, AWSCLIRepository, AWSCLIVersion)
+	}
+	return fmt.Sprintf("%s/%s:%s", MetaflowStaticUIRegistry, AWSCLIRepository, AWSCLIVersion)
+}
+
+// PersistenceConfig shapes the metadata database, either a Postgres instance or an Aurora Serverless v2
+// cluster scaling between MinCapacityACU and MaxCapacityACU. Changing StorageEncrypted or Engine on an
+// existing database makes CloudFormation replace it with an empty one, the old database is retained. The
+// Postgres instance is restored from SnapshotIdentifier when it is set, which is how encryption is turned on.
+type PersistenceConfig struct {
+	Profile                string  `json:"profile"`
+	Engine                 string  `json:"engine"`
+	MinCapacityACU         float64 `json:"minCapacityAcu"`
+	MaxCapacityACU         float64 `json:"maxCapacityAcu"`
+	InstanceClass          string  `json:"instanceClass"`
+	MultiAZ                bool    `json:"multiAz"`
+	StorageType            string  `json:"storageType"`
+	AllocatedStorageGiB    int     `json:"allocatedStorageGiB"`
+	MaxAllocatedStorageGiB int     `json:"maxAllocatedStorageGiB"`
+	StorageEncrypted       bool    `json:"storageEncrypted"`
+	BackupRetentionDays    int     `json:"backupRetentionDays"`
+	DeletionProtection     bool    `json:"deletionProtection"`
+	SnapshotOnDelete       bool    `json:"snapshotOnDelete"`
+	PerformanceInsights    bool    `json:"performanceInsights"`
+	PubliclyAccessible     bool    `json:"publiclyAccessible"`
+	RotationDays           int     `json:"rotationDays"`
+	Proxy                  bool    `json:"proxy"`
+	SnapshotIdentifier     string  `json:"snapshotIdentifier"`
+}
+
+const (
+	DevelopmentPersistenceProfile = "development"
+	ProductionPersistenceProfile  = "production"
+
+	PostgresEngine         = "postgres"
+	AuroraServerlessEngine = "aurora-postgresql-serverless"
+)
+
+// DatastoreConfig sets the retention of the Metaflow S3 datastore. Objects under Prefix move to
+// StorageClass after TransitionAfterDays and expire after ExpirationDays, 0 disables either step.
+// The bucket is versioned and it survives a destroy with its objects unless Versioned or Retain are set to false.
+type DatastoreConfig struct {
+	Retain                             *bool  `json:"retain"`
+	Versioned                          *bool  `json:"versioned"`
+	NoncurrentVersionExpirationDays    int    `json:"noncurrentVersionExpirationDays"`
+	Prefix                             string `json:"prefix"`
+	StorageClass                       string `json:"storageClass"`
+	TransitionAfterDays                int    `json:"transitionAfterDays"`
+	ExpirationDays                     int    `json:"expirationDays"`
+	AbortIncompleteMultipartUploadDays int    `json:"abortIncompleteMultipartUploadDays"`
+}
+
+// Retained tells whether the bucket is kept on destroy, which is the default.
+func (d DatastoreConfig) Retained() bool {
+	return d.Retain == nil || *d.Retain
+}
+
+// IsVersioned tells whether the bucket keeps noncurrent versions, which is the default.
+func (d DatastoreConfig) IsVersioned() bool {
+	return d.Versioned == nil || *d.Versioned
+}
+
+// DisasterRecoveryConfig replicates the datastore and the DynamoDB state to SecondaryRegion and copies
+// daily RDS snapshots there, keeping the copies for SnapshotRetentionDays.
+type DisasterRecoveryConfig struct {
+	Enabled               bool   `json:"enabled"`
+	SecondaryRegion       string `json:"secondaryRegion"`
+	SnapshotRetentionDays int    `json:"snapshotRetentionDays"`
+}
+
+// SchedulingConfig names the state machines created by `step-functions create`. Failed or timed out
+// executions of machines starting with StateMachinePrefix are published to NotificationEmail when set.
+type SchedulingConfig struct {
+	StateMachinePrefix string          `json:"stateMachinePrefix"`
+	NotificationEmail  string          `json:"notificationEmail"`
+	Triggers           []TriggerConfig `json:"triggers"`
+}
+
+// TriggerConfig starts StateMachine whenever an object is created under the datatools root (data/) with a key
+// matching Prefix, a `*` in it makes the pattern a wildcard. Prefix is relative to data/ and uses the S3 safe
+// characters only. The key is passed as the Parameter flow parameter, which must be a Python identifier.
+type TriggerConfig struct {
+	Name         string `json:"name"`
+	Prefix       string `json:"prefix"`
+	StateMachine string `json:"stateMachine"`
+	Parameter    string `json:"parameter"`
+}
+
+// KubernetesConfig adds an EKS cluster for @kubernetes steps and Argo Workflows. The CPU node group scales
+// between one and CPUMaxNodes, the GPU one from zero to GPUMaxNodes. KubectlLayerArn is a Lambda layer
+// with kubectl and helm that CloudFormation uses to install the charts and manifests.
+type KubernetesConfig struct {
+	Enabled          bool     `json:"enabled"`
+	Version          string   `json:"version"`
+	KubectlLayerArn  string   `json:"kubectlLayerArn"`
+	CPUInstanceTypes []string `json:"cpuInstanceTypes"`
+	CPUMaxNodes      int      `json:"cpuMaxNodes"`
+	GPUInstanceTypes []string `json:"gpuInstanceTypes"`
+	GPUMaxNodes      int      `json:"gpuMaxNodes"`
+}
+
+// ObservabilityConfig sends the platform alarms to AlarmEmail when set and, with both Slack ids set, to a
+// Slack channel through AWS Chatbot. The workspace has to be authorized in the Chatbot console beforehand.
+type ObservabilityConfig struct {
+	AlarmEmail       string `json:"alarmEmail"`
+	SlackWorkspaceId string `json:"slackWorkspaceId"`
+	SlackChannelId   string `json:"slackChannelId"`
+}
+
+func (o ObservabilityConfig) SlackEnabled() bool {
+	return o.SlackWorkspaceId != "" && o.SlackChannelId != ""
+}
+
+// ComplianceConfig turns the findings of the policy rule pack into errors that fail synthesis with Strict,
+// they are warnings otherwise.
+type ComplianceConfig struct {
+	Strict bool `json:"strict"`
+}
+
+var (
+	stateMachinePrefixPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,40}$`)
+	triggerNamePattern        = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+	triggerParameterPattern   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	triggerPrefixPattern      = regexp.MustCompile(`^[A-Za-z0-9!_.*'()-][A-Za-z0-9!_.*'()/-]*$`)
+)
+
+var DatastoreStorageClasses = []string{"INTELLIGENT_TIERING", "STANDARD_IA", "GLACIER_IR", "GLACIER", "DEEP_ARCHIVE"}
+
+// datastoreMinTransitionDays are the storage classes S3 only transitions to after a minimum number of days.
+var datastoreMinTransitionDays = map[string]int{"STANDARD_IA": 30, "GLACIER_IR": 30}
+
+// fargateMemoryMiB are the memory sizes Fargate accepts for each task CPU size.
+var fargateMemoryMiB = map[int][]int{
+	256:   {512, 1024, 2048},
+	512:   memorySizes(1024, 4096, 1024),
+	1024:  memorySizes(2048, 8192, 1024),
+	2048:  memorySizes(4096, 16384, 1024),
+	4096:  memorySizes(8192, 30720, 1024),
+	8192:  memorySizes(16384, 61440, 4096),
+	16384: memorySizes(32768, 122880, 8192),
+}
+
+func memorySizes(min, max, step int) []int {
+	var sizes []int
+	for size := min; size <= max; size += step {
+		sizes = append(sizes, size)
+	}
+	return sizes
+}
+
+var PersistenceProfiles = map[string]PersistenceConfig{
+	DevelopmentPersistenceProfile: {
+		Profile:             DevelopmentPersistenceProfile,
+		Engine:              PostgresEngine,
+		MinCapacityACU:      0.5,
+		MaxCapacityACU:      8,
+		InstanceClass:       "db.t3.small",
+		StorageType:         "gp2",
+		AllocatedStorageGiB: 20,
+		BackupRetentionDays: 1,
+		PubliclyAccessible:  true,
+		RotationDays:        30,
+	},
+	ProductionPersistenceProfile: {
+		Profile:                ProductionPersistenceProfile,
+		Engine:                 PostgresEngine,
+		MinCapacityACU:         0.5,
+		MaxCapacityACU:         32,
+		InstanceClass:          "db.t4g.medium",
+		MultiAZ:                true,
+		StorageType:            "gp3",
+		AllocatedStorageGiB:    20,
+		MaxAllocatedStorageGiB: 200,
+		StorageEncrypted:       true,
+		BackupRetentionDays:    14,
+		DeletionProtection:     true,
+		SnapshotOnDelete:       true,
+		PerformanceInsights:    true,
+		RotationDays:           30,
+	},
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MetadataService: ServiceConfig{
+			Cpu:                  512,
+			MemoryMiB:            1024,
+			MinTasks:             2,
+			MaxTasks:             8,
+			TargetCPUUtilization: 60,
+			TargetActiveFlows:    200,
+		},
+		UIService: ServiceConfig{
+			Cpu:                   512,
+			MemoryMiB:             1024,
+			MinTasks:              2,
+			MaxTasks:              4,
+			TargetCPUUtilization:  60,
+			TargetRequestsPerTask: 500,
+		},
+		Images: ImagesConfig{
+			MetadataVersion: MetaflowMetadataVersion,
+			UIVersion:       MetaflowStaticUIVersion,
+		},
+		Persistence: PersistenceProfiles[DevelopmentPersistenceProfile],
+		Datastore: DatastoreConfig{
+			NoncurrentVersionExpirationDays:    30,
+			Prefix:                             "metaflow/",
+			StorageClass:                       "INTELLIGENT_TIERING",
+			AbortIncompleteMultipartUploadDays: 7,
+		},
+		DisasterRecovery: DisasterRecoveryConfig{
+			SecondaryRegion:       "us-west-2",
+			SnapshotRetentionDays: 7,
+		},
+		Scheduling: SchedulingConfig{
+			StateMachinePrefix: "metaflow",
+		},
+		Kubernetes: KubernetesConfig{
+			Version:          "1.33",
+			CPUInstanceTypes: []string{"m6i.xlarge"},
+			CPUMaxNodes:      4,
+			GPUInstanceTypes: []string{"g5.xlarge"},
+			GPUMaxNodes:      2,
+		},
+	}
+}
+
+func (c Config) Validate() error {
+	if err := c.MetadataService.Validate(); err != nil {
+		return fmt.Errorf("metadataService: %w", err)
+	}
+	if c.MetadataService.TargetActiveFlows <= 0 {
+		return fmt.Errorf("metadataService: targetActiveFlows must be positive, got %v", c.MetadataService.TargetActiveFlows)
+	}
+	if err := c.UIService.Validate(); err != nil {
+		return fmt.Errorf("uiService: %w", err)
+	}
+	if c.UIService.TargetRequestsPerTask <= 0 {
+		return fmt.Errorf("uiService: targetRequestsPerTask must be positive, got %v", c.UIService.TargetRequestsPerTask)
+	}
+	if c.Images.MetadataVersion == "" || c.Images.UIVersion == "" {
+		return fmt.Errorf("images: metadataVersion and uiVersion must not be empty")
+	}
+	if err := c.Persistence.Validate(); err != nil {
+		return fmt.Errorf("persistence: %w", err)
+	}
+	if err := c.Datastore.Validate(); err != nil {
+		return fmt.Errorf("datastore: %w", err)
+	}
+	if c.DisasterRecovery.Enabled && c.DisasterRecovery.SnapshotRetentionDays < 1 {
+		return fmt.Errorf("disasterRecovery: snapshotRetentionDays must be positive, got %d", c.DisasterRecovery.SnapshotRetentionDays)
+	}
+	if !stateMachinePrefixPattern.MatchString(c.Scheduling.StateMachinePrefix) {
+		return fmt.Errorf("scheduling: stateMachinePrefix must match %s, got %q", stateMachinePrefixPattern, c.Scheduling.StateMachinePrefix)
+	}
+	names := map[string]bool{}
+	for i, trigger := range c.Scheduling.Triggers {
+		if err := trigger.Validate(); err != nil {
+			return fmt.Errorf("scheduling.triggers[%d]: %w", i, err)
+		}
+		if names[trigger.Name] {
+			return fmt.Errorf("scheduling.triggers[%d]: duplicate name %q", i, trigger.Name)
+		}
+		names[trigger.Name] = true
+	}
+	if c.Kubernetes.Enabled {
+		if err := c.Kubernetes.Validate(); err != nil {
+			return fmt.Errorf("kubernetes: %w", err)
+		}
+	}
+	if (c.Observability.SlackWorkspaceId == "") != (c.Observability.SlackChannelId == "") {
+		return fmt.Errorf("observability: slackWorkspaceId and slackChannelId must be set together")
+	}
+	return nil
+}
+
+func (p PersistenceConfig) Validate() error {
+	switch p.Engine {
+	case PostgresEngine:
+		if p.AllocatedStorageGiB < 20 {
+			return fmt.Errorf("allocatedStorageGiB must be at least 20, got %d", p.AllocatedStorageGiB)
+		}
+		if p.MaxAllocatedStorageGiB != 0 && p.MaxAllocatedStorageGiB <= p.AllocatedStorageGiB {
+			return fmt.Errorf("maxAllocatedStorageGiB must exceed allocatedStorageGiB, got %d", p.MaxAllocatedStorageGiB)
+		}
+	case AuroraServerlessEngine:
+		// 0 ACU would let the cluster auto-pause, which never happens with the connections the services keep open.
+		if p.MinCapacityACU < 0.5 || p.MaxCapacityACU < p.MinCapacityACU || p.MaxCapacityACU > 256 {
+			return fmt.Errorf("capacity must satisfy 0.5 <= minCapacityAcu <= maxCapacityAcu <= 256, got %v/%v", p.MinCapacityACU, p.MaxCapacityACU)
+		}
+		if math.Mod(p.MinCapacityACU, 0.5) != 0 || math.Mod(p.MaxCapacityACU, 0.5) != 0 {
+			return fmt.Errorf("capacity must be a multiple of 0.5 ACU, got %v/%v", p.MinCapacityACU, p.MaxCapacityACU)
+		}
+	default:
+		return fmt.Errorf("engine must be %q or %q, got %q", PostgresEngine, AuroraServerlessEngine, p.Engine)
+	}
+	if p.RotationDays < 0 || p.RotationDays > 1000 {
+		return fmt.Errorf("rotationDays must be in [0, 1000], got %d", p.RotationDays)
+	}
+	if p.BackupRetentionDays < 0 || p.BackupRetentionDays > 35 {
+		return fmt.Errorf("backupRetentionDays must be in [0, 35], got %d", p.BackupRetentionDays)
+	}
+	if p.SnapshotIdentifier != "" && p.Engine != PostgresEngine {
+		return fmt.Errorf("snapshotIdentifier only applies to the %q engine", PostgresEngine)
+	}
+	return nil
+}
+
+func (d DatastoreConfig) Validate() error {
+	if d.NoncurrentVersionExpirationDays < 0 || d.TransitionAfterDays < 0 || d.ExpirationDays < 0 || d.AbortIncompleteMultipartUploadDays < 0 {
+		return fmt.Errorf("day counts must not be negative")
+	}
+	if d.TransitionAfterDays > 0 && !slices.Contains(DatastoreStorageClasses, d.StorageClass) {
+		return fmt.Errorf("storageClass must be one of %v, got %q", DatastoreStorageClasses, d.StorageClass)
+	}
+	if minDays := datastoreMinTransitionDays[d.StorageClass]; d.TransitionAfterDays > 0 && d.TransitionAfterDays < minDays {
+		return fmt.Errorf("transitionAfterDays must be at least %d for %s, got %d", minDays, d.StorageClass, d.TransitionAfterDays)
+	}
+	if d.ExpirationDays > 0 && d.ExpirationDays <= d.TransitionAfterDays {
+		return fmt.Errorf("expirationDays must exceed transitionAfterDays, got %d", d.ExpirationDays)
+	}
+	return nil
+}
+
+func (t TriggerConfig) Validate() error {
+	if !triggerNamePattern.MatchString(t.Name) {
+		return fmt.Errorf("name must match %s, got %q", triggerNamePattern, t.Name)
+	}
+	if t.StateMachine == "" {
+		return fmt.Errorf("stateMachine is required")
+	}
+	if !triggerParameterPattern.MatchString(t.Parameter) {
+		return fmt.Errorf("parameter must be an identifier matching %s, got %q", triggerParameterPattern, t.Parameter)
+	}
+	if !triggerPrefixPattern.MatchString(t.Prefix) {
+		return fmt.Errorf("prefix must be a key prefix relative to data/ matching %s, got %q", triggerPrefixPattern, t.Prefix)
+	}
+	return nil
+}
+
+func (k KubernetesConfig) Validate() error {
+	if k.KubectlLayerArn == "" {
+		return fmt.Errorf("kubectlLayerArn is required")
+	}
+	if len(k.CPUInstanceTypes) == 0 || len(k.GPUInstanceTypes) == 0 {
+		return fmt.Errorf("cpuInstanceTypes and gpuInstanceTypes must not be empty")
+	}
+	if k.CPUMaxNodes < 1 || k.GPUMaxNodes < 0 {
+		return fmt.Errorf("node counts must satisfy cpuMaxNodes >= 1 and gpuMaxNodes >= 0, got %d/%d", k.CPUMaxNodes, k.GPUMaxNodes)
+	}
+	return nil
+}
+
+func (s ServiceConfig) Validate() error {
+	if s.Cpu <= 0 || s.MemoryMiB <= 0 {
+		return fmt.Errorf("cpu and memoryMiB must be positive, got %d/%d", s.Cpu, s.MemoryMiB)
+	}
+	memory, ok := fargateMemoryMiB[s.Cpu]
+	if !ok {
+		return fmt.Errorf("cpu must be a Fargate task size (256, 512, 1024, 2048, 4096, 8192 or 16384), got %d", s.Cpu)
+	}
+	if !slices.Contains(memory, s.MemoryMiB) {
+		return fmt.Errorf("memoryMiB must be a Fargate memory size for %d cpu, from %d to %d, got %d", s.Cpu, memory[0], memory[len(memory)-1], s.MemoryMiB)
+	}
+	if s.MinTasks < 1 || s.MaxTasks < s.MinTasks {
+		return fmt.Errorf("task counts must satisfy 1 <= minTasks <= maxTasks, got %d/%d", s.MinTasks, s.MaxTasks)
+	}
+	if s.TargetCPUUtilization <= 0 || s.TargetCPUUtilization > 100 {
+		return fmt.Errorf("targetCpuUtilization must be in (0, 100], got %v", s.TargetCPUUtilization)
+	}
+	if s.TargetActiveFlows < 0 || s.TargetRequestsPerTask < 0 {
+		return fmt.Errorf("targetActiveFlows and targetRequestsPerTask must not be negative, got %v/%v", s.TargetActiveFlows, s.TargetRequestsPerTask)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package commons_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(config *commons.Config)
+		err    string
+	}{
+		{"default", func(config *commons.Config) {}, ""},
+		{"zero cpu", func(config *commons.Config) { config.MetadataService.Cpu = 0 }, "metadataService: cpu and memoryMiB must be positive"},
+		{"negative memory", func(config *commons.Config) { config.UIService.MemoryMiB = -1 }, "uiService: cpu and memoryMiB must be positive"},
+		{"fargate size", size(4096, 30720), ""},
+		{"memory too large for cpu", size(512, 8192), "metadataService: memoryMiB must be a Fargate memory size for 512 cpu, from 1024 to 4096, got 8192"},
+		{"memory between sizes", size(256, 1536), "metadataService: memoryMiB must be a Fargate memory size for 256 cpu"},
+		{"memory off step", size(8192, 18432), "metadataService: memoryMiB must be a Fargate memory size for 8192 cpu"},
+		{"cpu not a fargate size", size(768, 2048), "metadataService: cpu must be a Fargate task size"},
+		{"zero min tasks", func(config *commons.Config) { config.UIService.MinTasks = 0 }, "uiService: task counts"},
+		{"max below min", func(config *commons.Config) { config.MetadataService.MaxTasks = 1 }, "metadataService: task counts"},
+		{"zero cpu target", func(config *commons.Config) { config.UIService.TargetCPUUtilization = 0 }, "uiService: targetCpuUtilization"},
+		{"zero active flows", func(config *commons.Config) { config.MetadataService.TargetActiveFlows = 0 }, "metadataService: targetActiveFlows must be positive"},
+		{"zero requests per task", func(config *commons.Config) { config.UIService.TargetRequestsPerTask = 0 }, "uiService: targetRequestsPerTask must be positive"},
+		{"zero ACU", aurora(0, 8), "persistence: capacity must satisfy 0.5 <= minCapacityAcu"},
+		{"max below min ACU", aurora(4, 2), "persistence: capacity must satisfy"},
+		{"fractional ACU", aurora(0.5, 8.2), "persistence: capacity must be a multiple of 0.5 ACU"},
+		{"aurora ignores storage", func(config *commons.Config) {
+			aurora(0.5, 8)(config)
+			config.Persistence.AllocatedStorageGiB = 0
+			config.Persistence.MaxAllocatedStorageGiB = 0
+		}, ""},
+		{"postgres storage", func(config *commons.Config) { config.Persistence.AllocatedStorageGiB = 10 }, "persistence: allocatedStorageGiB must be at least 20"},
+		{"early STANDARD_IA transition", datastore("STANDARD_IA", 7), "datastore: transitionAfterDays must be at least 30 for STANDARD_IA"},
+		{"early GLACIER_IR transition", datastore("GLACIER_IR", 29), "datastore: transitionAfterDays must be at least 30 for GLACIER_IR"},
+		{"STANDARD_IA transition", datastore("STANDARD_IA", 30), ""},
+		{"early GLACIER transition", datastore("GLACIER", 1), ""},
+		{"trigger", trigger("Retrain", "training/", "data_key"), ""},
+		{"wildcard trigger", trigger("Retrain", "training/*.parquet", "data_key"), ""},
+		{"trigger name", trigger("re-train", "training/", "data_key"), "scheduling.triggers[0]: name must match"},
+		{"trigger parameter with quote", trigger("Retrain", "training/", `key", "other`), "scheduling.triggers[0]: parameter must be an identifier"},
+		{"trigger parameter with digit", trigger("Retrain", "training/", "1key"), "scheduling.triggers[0]: parameter must be an identifier"},
+		{"empty trigger prefix", trigger("Retrain", "", "data_key"), "scheduling.triggers[0]: prefix must be a key prefix"},
+		{"absolute trigger prefix", trigger("Retrain", "/training/", "data_key"), "scheduling.triggers[0]: prefix must be a key prefix"},
+		{"trigger prefix with quote", trigger("Retrain", `training"/`, "data_key"), "scheduling.triggers[0]: prefix must be a key prefix"},
+		{"negative unused target", func(config *commons.Config) { config.UIService.TargetActiveFlows = -5 }, "uiService: targetActiveFlows and targetRequestsPerTask must not be negative"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := commons.DefaultConfig()
+			test.change(&config)
+			err := config.Validate()
+
+			switch {
+			case test.err == "" && err != nil:
+				t.Fatalf("Validate() = %v, want no error", err)
+			case test.err != "" && err == nil:
+				t.Fatalf("Validate() = nil, want %q", test.err)
+			case test.err != "" && !strings.Contains(err.Error(), test.err):
+				t.Fatalf("Validate() = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func aurora(minACU, maxACU float64) func(config *commons.Config) {
+	return func(config *commons.Config) {
+		config.Persistence.Engine = commons.AuroraServerlessEngine
+		config.Persistence.MinCapacityACU = minACU
+		config.Persistence.MaxCapacityACU = maxACU
+	}
+}
+
+func datastore(storageClass string, days int) func(config *commons.Config) {
+	return func(config *commons.Config) {
+		config.Datastore.StorageClass = storageClass
+		config.Datastore.TransitionAfterDays = days
+	}
+}
+
+func trigger(name, prefix, parameter string) func(config *commons.Config) {
+	return func(config *commons.Config) {
+		config.Scheduling.Triggers = []commons.TriggerConfig{
+			{Name: name, Prefix: prefix, StateMachine: "metaflow_TrainFlow", Parameter: parameter},
+		}
+	}
+}
+
+func size(cpu, memoryMiB int) func(config *commons.Config) {
+	return func(config *commons.Config) {
+		config.MetadataService.Cpu = cpu
+		config.MetadataService.MemoryMiB = memoryMiB
+	}
+}
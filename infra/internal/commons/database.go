@@ -0,0 +1,97 @@
+package commons
+
+import (
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsrds"
+)
+
+// IDatabase is the metadata database as seen by the services, either a plain instance or an Aurora cluster.
+type IDatabase interface {
+	GetEndpointAddress() *string
+	GetEndpointPort() *string
+	GetRef() *string
+	GetArn() *string
+	GetTargetType() string
+	// AsInstance returns the database as a DB instance, for the consumers that only read its endpoint.
+	AsInstance() awsrds.CfnDBInstance
+}
+
+const (
+	DBInstanceTargetType = "AWS::RDS::DBInstance"
+	DBClusterTargetType  = "AWS::RDS::DBCluster"
+)
+
+type DBInstance struct {
+	Instance awsrds.CfnDBInstance
+}
+
+func (d DBInstance) GetEndpointAddress() *string {
+	return d.Instance.AttrEndpointAddress()
+}
+
+func (d DBInstance) GetEndpointPort() *string {
+	return d.Instance.AttrEndpointPort()
+}
+
+func (d DBInstance) GetRef() *string {
+	return d.Instance.Ref()
+}
+
+func (d DBInstance) GetArn() *string {
+	return d.Instance.AttrDbInstanceArn()
+}
+
+func (d DBInstance) GetTargetType() string {
+	return DBInstanceTargetType
+}
+
+func (d DBInstance) AsInstance() awsrds.CfnDBInstance {
+	return d.Instance
+}
+
+type DBCluster struct {
+	Cluster awsrds.CfnDBCluster
+	Writer  awsrds.CfnDBInstance
+}
+
+func (d DBCluster) GetEndpointAddress() *string {
+	return d.Cluster.AttrEndpointAddress()
+}
+
+func (d DBCluster) GetEndpointPort() *string {
+	return d.Cluster.AttrEndpointPort()
+}
+
+func (d DBCluster) GetRef() *string {
+	return d.Cluster.Ref()
+}
+
+func (d DBCluster) GetArn() *string {
+	return d.Cluster.AttrDbClusterArn()
+}
+
+func (d DBCluster) GetTargetType() string {
+	return DBClusterTargetType
+}
+
+func (d DBCluster) AsInstance() awsrds.CfnDBInstance {
+	return ClusterWriter{CfnDBInstance: d.Writer, Cluster: d.Cluster}
+}
+
+// ClusterWriter is the writer instance of an Aurora cluster with the endpoint and identifier of the cluster, which
+// follow the writer after a failover.
+type ClusterWriter struct {
+	awsrds.CfnDBInstance
+	Cluster awsrds.CfnDBCluster
+}
+
+func (w ClusterWriter) Ref() *string {
+	return w.Cluster.Ref()
+}
+
+func (w ClusterWriter) AttrEndpointAddress() *string {
+	return w.Cluster.AttrEndpointAddress()
+}
+
+func (w ClusterWriter) AttrEndpointPort() *string {
+	return w.Cluster.AttrEndpointPort()
+}
@@ -5,6 +5,68 @@ const (
 	MetaflowUIServiceName = "metadata-ui-service-v2"
 	MetaflowDBName        = "metaflow"
 	MetaflowDBUsername    = "master"
-	MetaflowMetadataImage = "netflixoss/metaflow_metadata_service:v2.5.0"
-	MetaflowStaticUIImage = "public.ecr.aws/outerbounds/metaflow_ui:v1.3.14"
+	MetadataDBUser        = "metaflow_metadata"
+	UIDBUser              = "metaflow_ui"
+	StateTableName        = "MetaflowStepFunctionsState"
+	DRBackupVaultName     = "metaflow-dr"
+	DRReplicationRuleId   = "DatastoreReplication"
+
+	UICertificateFile = "my-certificate.pem"
+	UIPrivateKeyFile  = "my-private-key.pem"
+
+	BatchGPUInstanceType   = "g6e.2xlarge"
+	BatchExecutionRoleName = "BatchExecutionRole"
+	BatchMaxvCpus          = 32
+	BatchMetricsNamespace  = "Metaflow/Batch"
+	GPUMetricsNamespace    = "Metaflow/GPU"
+	GPUJobsLogGroup        = "/metaflow/gpu-jobs"
+	AlarmsTopicName        = "metaflow-alarms"
+
+	EKSClusterName           = "metaflow"
+	KubernetesNamespace      = "metaflow"
+	KubernetesServiceAccount = "metaflow"
+	ArgoEventsServiceAccount = "argo-events-sensor"
+	ArgoEventsEventBus       = "default"
+	ArgoEventsEventSource    = "metaflow-webhook"
+	ArgoEventsEvent          = "metaflow-event"
+	ArgoEventsWebhookPort    = 12000
+
+	ClusterAutoscalerChartVersion  = "9.43.2"
+	NvidiaDevicePluginChartVersion = "0.17.0"
+	ArgoWorkflowsChartVersion      = "0.42.5"
+	ArgoEventsChartVersion         = "2.4.9"
+	// ArgoEventsJetStreamVersion is one of the JetStream versions the Argo Events controller of the chart ships.
+	ArgoEventsJetStreamVersion = "2.10.10"
+
+	MetaflowMetadataRepository = "netflixoss/metaflow_metadata_service"
+	MetaflowMetadataVersion    = "v2.5.0"
+	MetaflowStaticUIRegistry   = "public.ecr.aws"
+	MetaflowStaticUIRepository = "outerbounds/metaflow_ui"
+	MetaflowStaticUIVersion    = "v1.3.14"
+	AWSCLIRepository           = "aws-cli/aws-cli"
+	AWSCLIVersion              = "2.27.50"
+
+	DockerHubCachePrefix = "docker-hub"
+	ECRPublicCachePrefix = "ecr-public"
+)
+
+// The construct IDs of the stacks, which are also their CloudFormation stack names.
+const (
+	NetworkingStackName    = "MetaflowNetworkingStack"
+	KMSStackName           = "KMSStack"
+	IAMStackName           = "IAMStack"
+	PersistenceStackName   = "PersistenceStack"
+	DRStackName            = "MetaflowDRStack"
+	ClusterStackName       = "ClusterStack"
+	MetadataStackName      = "MetaflowMetadataStack"
+	CoreStackName          = "MetaflowCoreStack"
+	UIStackName            = "UIStack"
+	ApiStackName           = "ApiStack"
+	BatchStackName         = "BatchStack"
+	RolesStackName         = "RolesStack"
+	NotebookStackName      = "NotebookStack"
+	SchedulingStackName    = "SchedulingStack"
+	EKSStackName           = "MetaflowEKSStack"
+	ObservabilityStackName = "ObservabilityStack"
+	ResultStackName        = "ResultStack"
 )
@@ -1,6 +1,8 @@
 package commons
 
 import (
+	"fmt"
+
 	"github.com/AlekSi/pointer"
 	"github.com/aws/aws-cdk-go/awscdk/v2"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
@@ -37,5 +39,23 @@ func CreateECSExecutionRole(construct constructs.Construct, name string) awsiam.
 		),
 	)
 
+	stack := awscdk.Stack_Of(construct)
+	executionRole.AddToPolicy(
+		awsiam.NewPolicyStatement(
+			&awsiam.PolicyStatementProps{
+				Sid:    pointer.ToString("PullThroughCache"),
+				Effect: awsiam.Effect_ALLOW,
+				Actions: &[]*string{
+					pointer.ToString("ecr:CreateRepository"),
+					pointer.ToString("ecr:BatchImportUpstreamImage"),
+				},
+				Resources: &[]*string{
+					pointer.ToString(fmt.Sprintf("arn:aws:ecr:%[1]s:%[2]s:repository/%[3]s/*", *stack.Region(), *stack.Account(), DockerHubCachePrefix)),
+					pointer.ToString(fmt.Sprintf("arn:aws:ecr:%[1]s:%[2]s:repository/%[3]s/*", *stack.Region(), *stack.Account(), ECRPublicCachePrefix)),
+				},
+			},
+		),
+	)
+
 	return executionRole
 }
@@ -1,16 +1,30 @@
 package bootstrap
 
 import (
+	"github.com/AlekSi/pointer"
 	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
 
 	"github.com/aws/aws-cdk-go/awscdk/v2"
 )
 
+// The account the app deploys to and the region it deploys to by default.
+const (
+	MainAccountId = "450119683363"
+	MainRegion    = "us-east-2"
+)
+
+// RegionContextKey selects the region of the app, the CLI sets it from --region.
+const RegionContextKey = "metaflowRegion"
+
 func MainAccount() commons.Account {
 	app := awscdk.NewApp(nil)
+	region := MainRegion
+	if value, ok := app.Node().TryGetContext(pointer.ToString(RegionContextKey)).(string); ok && value != "" {
+		region = value
+	}
 	return commons.Account{
 		App:       app,
-		AccountId: "450119683363",
-		Region:    "us-east-2",
+		AccountId: MainAccountId,
+		Region:    region,
 	}
 }
@@ -0,0 +1,107 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/AlekSi/pointer"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+)
+
+const (
+	configContextKey = "metaflow"
+	strictContextKey = "metaflowComplianceStrict"
+)
+
+// The context keys pinning the image versions, the upgrade command writes them to cdk.context.json.
+const (
+	MetadataVersionContextKey = "metaflowMetadataVersion"
+	UIVersionContextKey       = "metaflowUIVersion"
+)
+
+// MainConfig overlays the "metaflow" context (cdk.json or -c) on top of the defaults.
+// The image versions can also be pinned alone, which is what the upgrade command does, a pin
+// disagreeing with an image version set in the "metaflow" context is an error. -c
+// metaflowComplianceStrict=true makes the policy findings fail synthesis.
+func MainConfig(account commons.Account) (commons.Config, error) {
+	config := commons.DefaultConfig()
+	var configured map[string]string
+
+	raw := account.App.Node().TryGetContext(pointer.ToString(configContextKey))
+	if raw != nil {
+		var bytes []byte
+		var err error
+		if s, ok := raw.(string); ok {
+			bytes = []byte(s)
+		} else if bytes, err = json.Marshal(raw); err != nil {
+			return config, fmt.Errorf("encoding %q context: %w", configContextKey, err)
+		}
+
+		// The persistence profile picks the preset, explicit persistence fields then override it.
+		var selector struct {
+			Persistence struct {
+				Profile string `json:"profile"`
+			} `json:"persistence"`
+			Images struct {
+				MetadataVersion string `json:"metadataVersion"`
+				UIVersion       string `json:"uiVersion"`
+			} `json:"images"`
+		}
+		if err := json.Unmarshal(bytes, &selector); err != nil {
+			return config, fmt.Errorf("decoding %q context: %w", configContextKey, err)
+		}
+		if profile := selector.Persistence.Profile; profile != "" {
+			preset, ok := commons.PersistenceProfiles[profile]
+			if !ok {
+				return config, fmt.Errorf("unknown persistence profile %q", profile)
+			}
+			config.Persistence = preset
+		}
+
+		if err := json.Unmarshal(bytes, &config); err != nil {
+			return config, fmt.Errorf("decoding %q context: %w", configContextKey, err)
+		}
+		configured = map[string]string{
+			MetadataVersionContextKey: selector.Images.MetadataVersion,
+			UIVersionContextKey:       selector.Images.UIVersion,
+		}
+	}
+
+	// A pin that silently overrode the configured version would leave later edits of the version without effect.
+	pins := []struct {
+		key, field string
+		version    *string
+	}{
+		{MetadataVersionContextKey, "images.metadataVersion", &config.Images.MetadataVersion},
+		{UIVersionContextKey, "images.uiVersion", &config.Images.UIVersion},
+	}
+	for _, pin := range pins {
+		version, ok := account.App.Node().TryGetContext(pointer.ToString(pin.key)).(string)
+		if !ok {
+			continue
+		}
+		if configured[pin.key] != "" && configured[pin.key] != version {
+			return config, fmt.Errorf(
+				"%s is %s in the %q context but %s pins %s, keep the version in one of them",
+				pin.field, configured[pin.key], configContextKey, pin.key, version,
+			)
+		}
+		*pin.version = version
+	}
+	// -c passes the value as a string, cdk.json and cdk.context.json as a JSON bool.
+	switch strict := account.App.Node().TryGetContext(pointer.ToString(strictContextKey)).(type) {
+	case string:
+		config.Compliance.Strict = strict == "true"
+	case bool:
+		config.Compliance.Strict = strict
+	}
+
+	if err := config.Validate(); err != nil {
+		return config, fmt.Errorf("invalid %q context: %w", configContextKey, err)
+	}
+	if config.DisasterRecovery.Enabled && config.DisasterRecovery.SecondaryRegion == account.Region {
+		return config, fmt.Errorf("invalid %q context: disasterRecovery.secondaryRegion must differ from %s", configContextKey, account.Region)
+	}
+
+	return config, nil
+}
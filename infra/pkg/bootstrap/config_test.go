@@ -0,0 +1,138 @@
+package bootstrap_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/pkg/bootstrap"
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/jsii-runtime-go"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	jsii.Close()
+	os.Exit(code)
+}
+
+func TestStrictContext(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  any
+		strict bool
+	}{
+		{"absent", nil, false},
+		{"-c true", "true", true},
+		{"-c false", "false", false},
+		{"JSON true", true, true},
+		{"JSON false", false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			context := map[string]any{}
+			if test.value != nil {
+				context["metaflowComplianceStrict"] = test.value
+			}
+			account := commons.Account{
+				App:    awscdk.NewApp(&awscdk.AppProps{Context: &context}),
+				Region: bootstrap.MainRegion,
+			}
+
+			config, err := bootstrap.MainConfig(account)
+			if err != nil {
+				t.Fatalf("MainConfig() = %v", err)
+			}
+			if config.Compliance.Strict != test.strict {
+				t.Errorf("Compliance.Strict = %v, want %v", config.Compliance.Strict, test.strict)
+			}
+		})
+	}
+}
+
+func TestDatastoreRetention(t *testing.T) {
+	tests := []struct {
+		name      string
+		datastore map[string]any
+		retained  bool
+		versioned bool
+	}{
+		{"defaults", nil, true, true},
+		{"retain opt-out", map[string]any{"retain": false}, false, true},
+		{"versioning opt-out", map[string]any{"versioned": false}, true, false},
+		{"explicit", map[string]any{"retain": true, "versioned": true}, true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			context := map[string]any{}
+			if test.datastore != nil {
+				context["metaflow"] = map[string]any{"datastore": test.datastore}
+			}
+			account := commons.Account{
+				App:    awscdk.NewApp(&awscdk.AppProps{Context: &context}),
+				Region: bootstrap.MainRegion,
+			}
+
+			config, err := bootstrap.MainConfig(account)
+			if err != nil {
+				t.Fatalf("MainConfig() = %v", err)
+			}
+			if config.Datastore.Retained() != test.retained {
+				t.Errorf("Datastore.Retained() = %v, want %v", config.Datastore.Retained(), test.retained)
+			}
+			if config.Datastore.IsVersioned() != test.versioned {
+				t.Errorf("Datastore.IsVersioned() = %v, want %v", config.Datastore.IsVersioned(), test.versioned)
+			}
+		})
+	}
+}
+
+func TestImageVersionPins(t *testing.T) {
+	tests := []struct {
+		name     string
+		images   map[string]any
+		pin      string
+		metadata string
+		err      string
+	}{
+		{"default", nil, "", commons.MetaflowMetadataVersion, ""},
+		{"configured", map[string]any{"metadataVersion": "v2.6.0"}, "", "v2.6.0", ""},
+		{"pinned", nil, "v2.6.0", "v2.6.0", ""},
+		{"pin of another image setting", map[string]any{"pullThroughCache": true}, "v2.6.0", "v2.6.0", ""},
+		{"pin matching the configured version", map[string]any{"metadataVersion": "v2.6.0"}, "v2.6.0", "v2.6.0", ""},
+		{"pin disagreeing with the configured version", map[string]any{"metadataVersion": "v2.7.0"}, "v2.6.0", "",
+			`images.metadataVersion is v2.7.0 in the "metaflow" context but metaflowMetadataVersion pins v2.6.0, keep the version in one of them`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			context := map[string]any{}
+			if test.images != nil {
+				context["metaflow"] = map[string]any{"images": test.images}
+			}
+			if test.pin != "" {
+				context[bootstrap.MetadataVersionContextKey] = test.pin
+			}
+			account := commons.Account{
+				App:    awscdk.NewApp(&awscdk.AppProps{Context: &context}),
+				Region: bootstrap.MainRegion,
+			}
+
+			config, err := bootstrap.MainConfig(account)
+			switch {
+			case test.err == "" && err != nil:
+				t.Fatalf("MainConfig() = %v, want no error", err)
+			case test.err != "":
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("MainConfig() = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if config.Images.MetadataVersion != test.metadata {
+				t.Errorf("Images.MetadataVersion = %s, want %s", config.Images.MetadataVersion, test.metadata)
+			}
+		})
+	}
+}
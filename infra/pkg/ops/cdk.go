@@ -0,0 +1,242 @@
+package ops
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/pkg/bootstrap"
+)
+
+// AssemblyDir is where the app is synthesized, deploys of selected stacks use that same assembly.
+const AssemblyDir = "cdk.out"
+
+// CDK runs the CDK CLI.
+type CDK interface {
+	Run(ctx context.Context, args ...string) error
+}
+
+// CDKCLI runs the cdk binary against the selected profile and region, the region also selects where the app
+// deploys through bootstrap.RegionContextKey.
+type CDKCLI struct {
+	Profile string
+	Region  string
+	Stdout  io.Writer
+	Stderr  io.Writer
+}
+
+func (c CDKCLI) Run(ctx context.Context, args ...string) error {
+	if c.Profile != "" {
+		args = append(args, "--profile", c.Profile)
+	}
+	if c.Region != "" {
+		args = append(args, "-c", bootstrap.RegionContextKey+"="+c.Region)
+	}
+	command := exec.CommandContext(ctx, "cdk", args...)
+	command.Stdout = c.Stdout
+	command.Stderr = c.Stderr
+	// Without a region cdk keeps the one of the environment or the profile.
+	if c.Region != "" {
+		command.Env = append(os.Environ(), "AWS_REGION="+c.Region)
+	}
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("cdk %s: %w", args[0], err)
+	}
+	return nil
+}
+
+// StackAliases name the subsystems, an alias selects all of its stacks by the IDs the constructors give them.
+var StackAliases = map[string][]string{
+	"networking":    {commons.NetworkingStackName},
+	"kms":           {commons.KMSStackName},
+	"iam":           {commons.IAMStackName},
+	"persistence":   {commons.PersistenceStackName},
+	"db":            {commons.PersistenceStackName},
+	"dr":            {commons.DRStackName},
+	"cluster":       {commons.ClusterStackName},
+	"metadata":      {commons.MetadataStackName, commons.CoreStackName},
+	"ui":            {commons.UIStackName},
+	"api":           {commons.ApiStackName},
+	"batch":         {commons.BatchStackName},
+	"roles":         {commons.RolesStackName},
+	"notebook":      {commons.NotebookStackName},
+	"scheduling":    {commons.SchedulingStackName},
+	"eks":           {commons.EKSStackName},
+	"kubernetes":    {commons.EKSStackName},
+	"observability": {commons.ObservabilityStackName},
+	"result":        {commons.ResultStackName},
+}
+
+// StackNames are the stacks the app can have, whether or not the configuration enables them.
+func StackNames() []string {
+	names := map[string]bool{}
+	for _, stacks := range StackAliases {
+		for _, name := range stacks {
+			names[name] = true
+		}
+	}
+	return slices.Sorted(maps.Keys(names))
+}
+
+func AliasesHelp() string {
+	var lines []string
+	for _, alias := range slices.Sorted(maps.Keys(StackAliases)) {
+		lines = append(lines, fmt.Sprintf("  %-14s %s", alias, strings.Join(StackAliases[alias], ", ")))
+	}
+	return "Stacks are selected by name or by subsystem alias:\n" + strings.Join(lines, "\n")
+}
+
+// StackGraph holds the dependencies between the stacks of the synthesized app, they are the references the fx
+// providers wire from one stack into another.
+type StackGraph map[string][]string
+
+// Synth synthesizes the app into AssemblyDir, the following cdk commands deploy that same assembly.
+func Synth(ctx context.Context, cdk CDK) (StackGraph, error) {
+	if err := cdk.Run(ctx, "synth", "--quiet", "--output", AssemblyDir); err != nil {
+		return nil, err
+	}
+
+	bytes, err := os.ReadFile(filepath.Join(AssemblyDir, "manifest.json"))
+	if err != nil {
+		return nil, fmt.Errorf("reading cloud assembly: %w", err)
+	}
+	var manifest struct {
+		Artifacts map[string]struct {
+			Type         string   `json:"type"`
+			Dependencies []string `json:"dependencies"`
+		} `json:"artifacts"`
+	}
+	if err := json.Unmarshal(bytes, &manifest); err != nil {
+		return nil, fmt.Errorf("decoding cloud assembly: %w", err)
+	}
+
+	graph := StackGraph{}
+	for name, artifact := range manifest.Artifacts {
+		if artifact.Type == "aws:cloudformation:stack" {
+			graph[name] = nil
+		}
+	}
+	for name := range graph {
+		for _, dependency := range manifest.Artifacts[name].Dependencies {
+			if _, ok := graph[dependency]; ok {
+				graph[name] = append(graph[name], dependency)
+			}
+		}
+	}
+
+	return graph, nil
+}
+
+// Resolve maps stack names, matched case insensitively, and aliases to the stacks of the app.
+func (g StackGraph) Resolve(args []string) ([]string, error) {
+	selected := map[string]bool{}
+	for _, arg := range args {
+		names, ok := StackAliases[strings.ToLower(arg)]
+		if !ok {
+			for name := range g {
+				if strings.EqualFold(name, arg) {
+					names = []string{name}
+				}
+			}
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("unknown stack %q\n%s", arg, AliasesHelp())
+		}
+		for _, name := range names {
+			if _, ok := g[name]; !ok {
+				return nil, fmt.Errorf("%s is not part of the app, enable it in the metaflow context", name)
+			}
+			selected[name] = true
+		}
+	}
+
+	return slices.Sorted(maps.Keys(selected)), nil
+}
+
+// WithDependencies adds the stacks the selection depends on, transitively.
+func (g StackGraph) WithDependencies(stacks []string) []string {
+	return g.closure(stacks, func(name string) []string { return g[name] })
+}
+
+// WithDependents adds the stacks depending on the selection, transitively.
+func (g StackGraph) WithDependents(stacks []string) []string {
+	return g.closure(stacks, func(name string) []string {
+		var dependents []string
+		for stack, dependencies := range g {
+			if slices.Contains(dependencies, name) {
+				dependents = append(dependents, stack)
+			}
+		}
+		return dependents
+	})
+}
+
+func (g StackGraph) closure(stacks []string, next func(string) []string) []string {
+	seen := map[string]bool{}
+	queue := slices.Clone(stacks)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		queue = append(queue, next(name)...)
+	}
+	return slices.Sorted(maps.Keys(seen))
+}
+
+// Deploy deploys the stacks of the assembly Synth wrote, or the whole app when stacks is empty, and captures
+// the outputs of the deployment.
+func Deploy(ctx context.Context, cdk CDK, outputs *OutputsCache, stacks []string) error {
+	args := []string{"deploy", "--require-approval", "never", "--concurrency", "100"}
+	if len(stacks) == 0 {
+		args = append(args, "--all")
+	} else {
+		args = append(append(args, "--app", AssemblyDir, "--exclusively"), stacks...)
+	}
+	if err := cdk.Run(ctx, args...); err != nil {
+		return err
+	}
+	_, err := outputs.Capture(ctx)
+	return err
+}
+
+// Destroy destroys the stacks of the assembly Synth wrote, or the whole app when stacks is empty, and captures
+// the outputs of what is left.
+func Destroy(ctx context.Context, cdk CDK, outputs *OutputsCache, stacks []string) error {
+	args := []string{"destroy", "--force", "--concurrency", "100"}
+	if len(stacks) == 0 {
+		args = append(args, "--all")
+	} else {
+		args = append(append(args, "--app", AssemblyDir, "--exclusively"), stacks...)
+	}
+	if err := cdk.Run(ctx, args...); err != nil {
+		return err
+	}
+	_, err := outputs.Capture(ctx)
+	return err
+}
+
+// Diff compares the stacks of the assembly Synth wrote, or the whole app when stacks is empty, with what is
+// deployed. With fail a difference is an error.
+func Diff(ctx context.Context, cdk CDK, stacks []string, fail bool) error {
+	args := []string{"diff"}
+	if fail {
+		args = append(args, "--fail")
+	}
+	if len(stacks) == 0 {
+		args = append(args, "--all")
+	} else {
+		args = append(append(args, "--app", AssemblyDir, "--exclusively"), stacks...)
+	}
+	return cdk.Run(ctx, args...)
+}
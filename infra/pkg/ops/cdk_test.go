@@ -0,0 +1,187 @@
+package ops_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/pkg/bootstrap"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/pkg/ops"
+)
+
+// fakeCDK records the cdk commands instead of running them.
+type fakeCDK struct {
+	runs [][]string
+	err  error
+}
+
+func (c *fakeCDK) Run(ctx context.Context, args ...string) error {
+	c.runs = append(c.runs, args)
+	return c.err
+}
+
+// graph is a small app: the core and UI stacks use the database, which lives in the VPC.
+var graph = ops.StackGraph{
+	commons.NetworkingStackName:  nil,
+	commons.PersistenceStackName: {commons.NetworkingStackName},
+	commons.MetadataStackName:    {commons.NetworkingStackName},
+	commons.CoreStackName:        {commons.PersistenceStackName, commons.MetadataStackName},
+	commons.UIStackName:          {commons.PersistenceStackName},
+	commons.ResultStackName:      {commons.CoreStackName},
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		stacks []string
+		err    bool
+	}{
+		{"alias", []string{"db"}, []string{commons.PersistenceStackName}, false},
+		{"alias of several stacks", []string{"metadata"}, []string{commons.CoreStackName, commons.MetadataStackName}, false},
+		{"stack name in any case", []string{"uistack"}, []string{commons.UIStackName}, false},
+		{"duplicates", []string{"persistence", "db", commons.PersistenceStackName}, []string{commons.PersistenceStackName}, false},
+		{"unknown stack", []string{"nope"}, nil, true},
+		{"stack not in the app", []string{"dr"}, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stacks, err := graph.Resolve(test.args)
+			if (err != nil) != test.err {
+				t.Fatalf("Resolve(%v) error = %v, want error %v", test.args, err, test.err)
+			}
+			if !slices.Equal(stacks, test.stacks) {
+				t.Errorf("Resolve(%v) = %v, want %v", test.args, stacks, test.stacks)
+			}
+		})
+	}
+}
+
+func TestWithDependencies(t *testing.T) {
+	got := graph.WithDependencies([]string{commons.ResultStackName})
+	want := []string{
+		commons.CoreStackName, commons.MetadataStackName, commons.NetworkingStackName,
+		commons.PersistenceStackName, commons.ResultStackName,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("WithDependencies(ResultStack) = %v, want %v", got, want)
+	}
+}
+
+func TestWithDependents(t *testing.T) {
+	got := graph.WithDependents([]string{commons.PersistenceStackName})
+	want := []string{commons.CoreStackName, commons.PersistenceStackName, commons.ResultStackName, commons.UIStackName}
+	if !slices.Equal(got, want) {
+		t.Errorf("WithDependents(PersistenceStack) = %v, want %v", got, want)
+	}
+}
+
+func TestCDKArguments(t *testing.T) {
+	selected := []string{commons.CoreStackName, commons.UIStackName}
+	tests := []struct {
+		name    string
+		run     func(ctx context.Context, cdk ops.CDK, outputs *ops.OutputsCache) error
+		args    []string
+		capture bool
+	}{
+		{"deploy all", func(ctx context.Context, cdk ops.CDK, outputs *ops.OutputsCache) error {
+			return ops.Deploy(ctx, cdk, outputs, nil)
+		}, []string{"deploy", "--require-approval", "never", "--concurrency", "100", "--all"}, true},
+		{"deploy stacks", func(ctx context.Context, cdk ops.CDK, outputs *ops.OutputsCache) error {
+			return ops.Deploy(ctx, cdk, outputs, selected)
+		}, []string{
+			"deploy", "--require-approval", "never", "--concurrency", "100",
+			"--app", ops.AssemblyDir, "--exclusively", commons.CoreStackName, commons.UIStackName,
+		}, true},
+		{"destroy all", func(ctx context.Context, cdk ops.CDK, outputs *ops.OutputsCache) error {
+			return ops.Destroy(ctx, cdk, outputs, nil)
+		}, []string{"destroy", "--force", "--concurrency", "100", "--all"}, true},
+		{"destroy stacks", func(ctx context.Context, cdk ops.CDK, outputs *ops.OutputsCache) error {
+			return ops.Destroy(ctx, cdk, outputs, selected)
+		}, []string{
+			"destroy", "--force", "--concurrency", "100",
+			"--app", ops.AssemblyDir, "--exclusively", commons.CoreStackName, commons.UIStackName,
+		}, true},
+		{"diff all", func(ctx context.Context, cdk ops.CDK, outputs *ops.OutputsCache) error {
+			return ops.Diff(ctx, cdk, nil, false)
+		}, []string{"diff", "--all"}, false},
+		{"diff stacks with fail", func(ctx context.Context, cdk ops.CDK, outputs *ops.OutputsCache) error {
+			return ops.Diff(ctx, cdk, selected, true)
+		}, []string{"diff", "--fail", "--app", ops.AssemblyDir, "--exclusively", commons.CoreStackName, commons.UIStackName}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			api := newOutputsAPI()
+			cdk := &fakeCDK{}
+
+			if err := test.run(context.Background(), cdk, ops.NewOutputsCache(api)); err != nil {
+				t.Fatalf("run = %v", err)
+			}
+			if len(cdk.runs) != 1 || !slices.Equal(cdk.runs[0], test.args) {
+				t.Errorf("cdk ran %v, want %v", cdk.runs, test.args)
+			}
+			if captured := api.describeStacks > 0; captured != test.capture {
+				t.Errorf("captured the outputs = %v, want %v", captured, test.capture)
+			}
+		})
+	}
+}
+
+func TestDeployFails(t *testing.T) {
+	t.Chdir(t.TempDir())
+	api := newOutputsAPI()
+	failed := errors.New("cdk deploy: exit status 1")
+
+	err := ops.Deploy(context.Background(), &fakeCDK{err: failed}, ops.NewOutputsCache(api), nil)
+	if !errors.Is(err, failed) {
+		t.Fatalf("Deploy() = %v, want %v", err, failed)
+	}
+	if api.describeStacks > 0 {
+		t.Error("a failed deploy captured the outputs")
+	}
+	if _, err := os.Stat(ops.OutputsDir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("a failed deploy wrote %s", ops.OutputsDir)
+	}
+}
+
+// The region is only passed on when one is selected, cdk keeps the one of the environment otherwise.
+func TestCDKCLIRegion(t *testing.T) {
+	tests := []struct {
+		name   string
+		region string
+		want   string
+	}{
+		{"region", "us-west-2", "diff -c " + bootstrap.RegionContextKey + "=us-west-2 us-west-2\n"},
+		{"no region", "", "diff eu-west-1\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			script := "#!/bin/sh\necho \"$@\" \"$AWS_REGION\" > \"" + filepath.Join(dir, "args") + "\"\n"
+			if err := os.WriteFile(filepath.Join(dir, "cdk"), []byte(script), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("PATH", dir)
+			t.Setenv("AWS_REGION", "eu-west-1")
+
+			if err := (ops.CDKCLI{Region: test.region}).Run(context.Background(), "diff"); err != nil {
+				t.Fatalf("Run() = %v", err)
+			}
+			args, err := os.ReadFile(filepath.Join(dir, "args"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(args) != test.want {
+				t.Errorf("cdk ran with %q, want %q", args, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package ops
+
+import (
+	"context"
+	"maps"
+	"reflect"
+	"slices"
+
+	"github.com/AlekSi/pointer"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+)
+
+// OperationalOutputs are the ResultStack outputs for the operations and the operators that are not Metaflow
+// settings.
+var OperationalOutputs = []string{
+	"METADATA_ECS_CLUSTER",
+	"METADATA_ECS_SERVICE",
+	"METADATA_DB_IDENTIFIER",
+	"METADATA_DB_TYPE",
+	"BATCH_COMPUTE_ENVIRONMENT",
+	"NOTEBOOK_INSTANCE_NAME",
+	"NOTEBOOKS_URL",
+	"EVENT_BUS_NAME",
+}
+
+// Config is the Metaflow client configuration, the content of ~/.metaflowconfig/config.json.
+type Config struct {
+	METAFLOW_BATCH_JOB_QUEUE                  *string `json:"METAFLOW_BATCH_JOB_QUEUE"`
+	METAFLOW_BATCH_EMIT_TAGS                  *string `json:"METAFLOW_BATCH_EMIT_TAGS"`
+	METAFLOW_DATASTORE_SYSROOT_S3             *string `json:"METAFLOW_DATASTORE_SYSROOT_S3"`
+	METAFLOW_DATATOOLS_S3ROOT                 *string `json:"METAFLOW_DATATOOLS_S3ROOT"`
+	METAFLOW_DEFAULT_DATASTORE                *string `json:"METAFLOW_DEFAULT_DATASTORE"`
+	METAFLOW_DEFAULT_METADATA                 *string `json:"METAFLOW_DEFAULT_METADATA"`
+	METAFLOW_ECS_S3_ACCESS_IAM_ROLE           *string `json:"METAFLOW_ECS_S3_ACCESS_IAM_ROLE"`
+	METAFLOW_EVENTS_SFN_ACCESS_IAM_ROLE       *string `json:"METAFLOW_EVENTS_SFN_ACCESS_IAM_ROLE"`
+	METAFLOW_SERVICE_INTERNAL_URL             *string `json:"METAFLOW_SERVICE_INTERNAL_URL"`
+	METAFLOW_SERVICE_URL                      *string `json:"METAFLOW_SERVICE_URL"`
+	METAFLOW_SFN_DYNAMO_DB_TABLE              *string `json:"METAFLOW_SFN_DYNAMO_DB_TABLE"`
+	METAFLOW_SFN_IAM_ROLE                     *string `json:"METAFLOW_SFN_IAM_ROLE"`
+	METAFLOW_ECS_FARGATE_EXECUTION_ROLE       *string `json:"METAFLOW_ECS_FARGATE_EXECUTION_ROLE"`
+	METAFLOW_SFN_STATE_MACHINE_PREFIX         *string `json:"METAFLOW_SFN_STATE_MACHINE_PREFIX"`
+	METAFLOW_SFN_EXECUTION_LOG_GROUP_ARN      *string `json:"METAFLOW_SFN_EXECUTION_LOG_GROUP_ARN"`
+	METAFLOW_KUBERNETES_NAMESPACE             *string `json:"METAFLOW_KUBERNETES_NAMESPACE,omitempty"`
+	METAFLOW_KUBERNETES_SERVICE_ACCOUNT       *string `json:"METAFLOW_KUBERNETES_SERVICE_ACCOUNT,omitempty"`
+	METAFLOW_ARGO_EVENTS_EVENT_BUS            *string `json:"METAFLOW_ARGO_EVENTS_EVENT_BUS,omitempty"`
+	METAFLOW_ARGO_EVENTS_EVENT_SOURCE         *string `json:"METAFLOW_ARGO_EVENTS_EVENT_SOURCE,omitempty"`
+	METAFLOW_ARGO_EVENTS_EVENT                *string `json:"METAFLOW_ARGO_EVENTS_EVENT,omitempty"`
+	METAFLOW_ARGO_EVENTS_SERVICE_ACCOUNT      *string `json:"METAFLOW_ARGO_EVENTS_SERVICE_ACCOUNT,omitempty"`
+	METAFLOW_ARGO_EVENTS_INTERNAL_WEBHOOK_URL *string `json:"METAFLOW_ARGO_EVENTS_INTERNAL_WEBHOOK_URL,omitempty"`
+}
+
+// MetaflowConfig fills the configuration from the ResultStack outputs. It also returns the outputs that are
+// neither Metaflow settings nor OperationalOutputs, they are left out of the configuration.
+func MetaflowConfig(ctx context.Context, outputs *OutputsCache) (*Config, []string, error) {
+	values, err := outputs.Stack(ctx, commons.ResultStackName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config := &Config{
+		METAFLOW_DEFAULT_METADATA:  pointer.ToString("service"),
+		METAFLOW_DEFAULT_DATASTORE: pointer.ToString("s3"),
+		// The jobs operations map Batch jobs back to pathspecs through these tags.
+		METAFLOW_BATCH_EMIT_TAGS: pointer.ToString("true"),
+	}
+	typeVar := reflect.ValueOf(config).Elem()
+
+	var unknown []string
+	for _, description := range slices.Sorted(maps.Keys(values)) {
+		value := values[description]
+		if field := typeVar.FieldByName(description); field.IsValid() {
+			field.Set(reflect.ValueOf(&value))
+		} else if !slices.Contains(OperationalOutputs, description) {
+			unknown = append(unknown, description)
+		}
+	}
+	return config, unknown, nil
+}
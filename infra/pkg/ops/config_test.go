@@ -0,0 +1,39 @@
+package ops_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/AlekSi/pointer"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/pkg/ops"
+)
+
+func TestMetaflowConfig(t *testing.T) {
+	t.Chdir(t.TempDir())
+	api := newOutputsAPI()
+	api.regionStacks[commons.ResultStackName] = stack(commons.ResultStackName, map[string]string{
+		"METAFLOW_SERVICE_URL":     "https://metadata",
+		"METAFLOW_BATCH_JOB_QUEUE": "arn:aws:batch:us-east-2:123456789012:job-queue/metaflow",
+		"METADATA_ECS_CLUSTER":     "metaflow",
+		"METAFLOW_RENAMED_SETTING": "value",
+		"SOME_OTHER_OUTPUT":        "value",
+	})
+
+	config, unknown, err := ops.MetaflowConfig(context.Background(), ops.NewOutputsCache(api))
+	if err != nil {
+		t.Fatalf("MetaflowConfig() = %v", err)
+	}
+
+	if want := []string{"METAFLOW_RENAMED_SETTING", "SOME_OTHER_OUTPUT"}; !slices.Equal(unknown, want) {
+		t.Errorf("unknown outputs = %v, want %v", unknown, want)
+	}
+	if pointer.GetString(config.METAFLOW_SERVICE_URL) != "https://metadata" ||
+		pointer.GetString(config.METAFLOW_BATCH_JOB_QUEUE) != "arn:aws:batch:us-east-2:123456789012:job-queue/metaflow" {
+		t.Errorf("config = %+v, want the ResultStack outputs", config)
+	}
+	if pointer.GetString(config.METAFLOW_DEFAULT_METADATA) != "service" || pointer.GetString(config.METAFLOW_BATCH_EMIT_TAGS) != "true" {
+		t.Errorf("config = %+v, want the defaults", config)
+	}
+}
@@ -0,0 +1,79 @@
+package ops
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+)
+
+type MetricsAPI interface {
+	MetricMaximum(ctx context.Context, namespace, metric string, start, end time.Time, dimensions map[string]string) (float64, bool, error)
+}
+
+type BackupAPI interface {
+	LatestRecoveryPoint(ctx context.Context, vault string) (time.Time, bool, error)
+}
+
+// DRReport is the replication lag to the DR region over the last hour, nil values had no datapoints.
+type DRReport struct {
+	Region                 string     `json:"region"`
+	DatastoreLagSeconds    *float64   `json:"datastoreLagSeconds"`
+	DatastorePendingOps    *float64   `json:"datastorePendingOperations"`
+	StateTableLagMillis    *float64   `json:"stateTableLagMilliseconds"`
+	LatestSnapshotCopyTime *time.Time `json:"latestSnapshotCopyTime"`
+}
+
+// DRStatus returns false when disaster recovery is not enabled. backups returns the Backup API of a region,
+// the copies live in the DR region.
+func DRStatus(ctx context.Context, outputs *OutputsCache, metrics MetricsAPI, backups func(region string) BackupAPI) (DRReport, bool, error) {
+	values, err := outputs.Stack(ctx, commons.PersistenceStackName)
+	if err != nil {
+		return DRReport{}, false, err
+	}
+	for _, name := range []string{"METAFLOW_DR_REGION", "METAFLOW_DR_SOURCE_BUCKET", "METAFLOW_DR_REPLICA_BUCKET", "METAFLOW_DR_BACKUP_VAULT"} {
+		if _, ok := values[name]; !ok {
+			return DRReport{}, false, nil
+		}
+	}
+	report := DRReport{Region: values["METAFLOW_DR_REGION"]}
+
+	end := time.Now().UTC()
+	start := end.Add(-time.Hour)
+	maximum := func(namespace, metric string, dimensions map[string]string) (*float64, error) {
+		value, ok, err := metrics.MetricMaximum(ctx, namespace, metric, start, end, dimensions)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return &value, nil
+	}
+
+	s3Dimensions := map[string]string{
+		"SourceBucket":      values["METAFLOW_DR_SOURCE_BUCKET"],
+		"DestinationBucket": values["METAFLOW_DR_REPLICA_BUCKET"],
+		"RuleId":            commons.DRReplicationRuleId,
+	}
+	if report.DatastoreLagSeconds, err = maximum("AWS/S3", "ReplicationLatency", s3Dimensions); err != nil {
+		return report, true, fmt.Errorf("reading S3 replication metrics: %w", err)
+	}
+	if report.DatastorePendingOps, err = maximum("AWS/S3", "OperationsPendingReplication", s3Dimensions); err != nil {
+		return report, true, fmt.Errorf("reading S3 replication metrics: %w", err)
+	}
+	report.StateTableLagMillis, err = maximum("AWS/DynamoDB", "ReplicationLatency", map[string]string{
+		"TableName":       commons.StateTableName,
+		"ReceivingRegion": report.Region,
+	})
+	if err != nil {
+		return report, true, fmt.Errorf("reading DynamoDB replication metrics: %w", err)
+	}
+
+	created, ok, err := backups(report.Region).LatestRecoveryPoint(ctx, values["METAFLOW_DR_BACKUP_VAULT"])
+	if err != nil {
+		return report, true, fmt.Errorf("reading DR backup vault: %w", err)
+	}
+	if ok {
+		report.LatestSnapshotCopyTime = &created
+	}
+	return report, true, nil
+}
@@ -0,0 +1,166 @@
+package ops
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+)
+
+// DefaultLogGroup is where Batch sends the logs of jobs without their own log configuration.
+const DefaultLogGroup = "/aws/batch/job"
+
+// ActiveJobStatuses are the statuses of jobs that have not finished yet.
+var ActiveJobStatuses = []string{"SUBMITTED", "PENDING", "RUNNABLE", "STARTING", "RUNNING"}
+
+type JobsAPI interface {
+	ListJobs(ctx context.Context, queue string, since time.Time) ([]string, error)
+	DescribeJobs(ctx context.Context, ids []string) ([]BatchJob, error)
+	TerminateJob(ctx context.Context, id, reason string) error
+	GetLogEvents(ctx context.Context, group, stream, token string) ([]LogEvent, string, error)
+}
+
+// JobPathspec is the Metaflow task the job runs, read from the tags Metaflow adds with METAFLOW_BATCH_EMIT_TAGS.
+func JobPathspec(job BatchJob) string {
+	var parts []string
+	for _, tag := range []string{"metaflow.flow_name", "metaflow.run_id", "metaflow.step_name", "metaflow.task_id"} {
+		value, ok := job.Tags[tag]
+		if !ok {
+			break
+		}
+		parts = append(parts, value)
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, "/")
+}
+
+// JobQueue is the given queue, or the Metaflow job queue of the deployment when it is empty.
+func JobQueue(ctx context.Context, outputs *OutputsCache, queue string) (string, error) {
+	if queue != "" {
+		return queue, nil
+	}
+	return outputs.Output(ctx, commons.ResultStackName, "METAFLOW_BATCH_JOB_QUEUE")
+}
+
+// ListJobs returns the jobs of the queue created after since, oldest first. A non empty runID keeps the jobs
+// of that Metaflow run only.
+func ListJobs(ctx context.Context, api JobsAPI, queue string, since time.Time, runID string) ([]BatchJob, error) {
+	ids, err := api.ListJobs(ctx, queue, since)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := api.DescribeJobs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	if runID != "" {
+		jobs = slices.DeleteFunc(jobs, func(job BatchJob) bool {
+			return job.Tags["metaflow.run_id"] != runID
+		})
+	}
+	slices.SortFunc(jobs, func(a, b BatchJob) int {
+		return cmp.Compare(a.CreatedAt, b.CreatedAt)
+	})
+	return jobs, nil
+}
+
+// CancelJobs terminates the jobs that have not finished yet and returns them, it goes on past failures.
+func CancelJobs(ctx context.Context, api JobsAPI, jobs []BatchJob, reason string) ([]BatchJob, error) {
+	var cancelled []BatchJob
+	var failed []error
+	for _, job := range jobs {
+		if !slices.Contains(ActiveJobStatuses, job.Status) {
+			continue
+		}
+		if err := api.TerminateJob(ctx, job.JobId, reason); err != nil {
+			failed = append(failed, err)
+			continue
+		}
+		cancelled = append(cancelled, job)
+	}
+	return cancelled, errors.Join(failed...)
+}
+
+// StreamJobLogs copies the log stream of the job to w. With follow it keeps polling until the job finishes and
+// its last events are read. An empty logGroup reads the group of the job's awslogs configuration.
+func StreamJobLogs(ctx context.Context, api JobsAPI, w io.Writer, jobID, logGroup string, follow bool, interval time.Duration) error {
+	job, err := describeJob(ctx, api, jobID)
+	if err != nil {
+		return err
+	}
+	for job.Container.LogStreamName == "" {
+		if !follow || !slices.Contains(ActiveJobStatuses, job.Status) {
+			return fmt.Errorf("job %s has no log stream, it is %s", jobID, job.Status)
+		}
+		if err := sleep(ctx, interval); err != nil {
+			return err
+		}
+		if job, err = describeJob(ctx, api, jobID); err != nil {
+			return err
+		}
+	}
+
+	if logGroup == "" {
+		logGroup = job.Container.LogConfiguration.Options["awslogs-group"]
+	}
+	if logGroup == "" {
+		logGroup = DefaultLogGroup
+	}
+
+	token := ""
+	for {
+		events, next, err := api.GetLogEvents(ctx, logGroup, job.Container.LogStreamName, token)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			fmt.Fprintf(w, "%s %s\n", event.Time().Local().Format(time.DateTime), event.Message)
+		}
+
+		// The same token comes back once the end of the stream is reached.
+		caughtUp := next == token
+		token = next
+		if !caughtUp {
+			continue
+		}
+		if !follow || !slices.Contains(ActiveJobStatuses, job.Status) {
+			return nil
+		}
+
+		if err := sleep(ctx, interval); err != nil {
+			return err
+		}
+		if job, err = describeJob(ctx, api, jobID); err != nil {
+			return err
+		}
+	}
+}
+
+func describeJob(ctx context.Context, api JobsAPI, jobID string) (BatchJob, error) {
+	jobs, err := api.DescribeJobs(ctx, []string{jobID})
+	if err != nil {
+		return BatchJob{}, err
+	}
+	if len(jobs) == 0 {
+		return BatchJob{}, fmt.Errorf("job %s not found", jobID)
+	}
+	return jobs[0], nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
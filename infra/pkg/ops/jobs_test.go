@@ -0,0 +1,222 @@
+package ops_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/pkg/ops"
+)
+
+// jobsAPI is a Batch queue with its jobs and the log stream of the first one.
+type jobsAPI struct {
+	jobs []ops.BatchJob
+	// statuses are the statuses of the first job at each DescribeJobs call, the last one sticks.
+	statuses []string
+	// logs[i] is written to the log stream by the time of the i+1th DescribeJobs call.
+	logs      [][]ops.LogEvent
+	describes int
+	// terminateErr fails the termination of the job with this id.
+	terminateErr string
+	terminated   []string
+	group        string
+}
+
+func (j *jobsAPI) ListJobs(ctx context.Context, queue string, since time.Time) ([]string, error) {
+	var ids []string
+	for _, job := range j.jobs {
+		ids = append(ids, job.JobId)
+	}
+	return ids, nil
+}
+
+func (j *jobsAPI) DescribeJobs(ctx context.Context, ids []string) ([]ops.BatchJob, error) {
+	j.describes++
+	if len(j.statuses) > 0 && len(j.jobs) > 0 {
+		j.jobs[0].Status = j.statuses[min(j.describes, len(j.statuses))-1]
+	}
+	var jobs []ops.BatchJob
+	for _, job := range j.jobs {
+		if slices.Contains(ids, job.JobId) {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func (j *jobsAPI) TerminateJob(ctx context.Context, id, reason string) error {
+	if id == j.terminateErr {
+		return errors.New("terminate " + id)
+	}
+	j.terminated = append(j.terminated, id)
+	return nil
+}
+
+// GetLogEvents uses the number of events read so far as the token.
+func (j *jobsAPI) GetLogEvents(ctx context.Context, group, stream, token string) ([]ops.LogEvent, string, error) {
+	j.group = group
+	written := slices.Concat(j.logs[:min(j.describes, len(j.logs))]...)
+	read, _ := strconv.Atoi(token)
+	return written[read:], strconv.Itoa(len(written)), nil
+}
+
+func job(id, status, runID string, createdAt int64) ops.BatchJob {
+	return ops.BatchJob{
+		JobId:     id,
+		Status:    status,
+		CreatedAt: createdAt,
+		Tags:      map[string]string{"metaflow.flow_name": "TrainFlow", "metaflow.run_id": runID},
+	}
+}
+
+func events(messages ...string) []ops.LogEvent {
+	var events []ops.LogEvent
+	for _, message := range messages {
+		events = append(events, ops.LogEvent{Timestamp: time.Now().UnixMilli(), Message: message})
+	}
+	return events
+}
+
+func ids(jobs []ops.BatchJob) []string {
+	var ids []string
+	for _, job := range jobs {
+		ids = append(ids, job.JobId)
+	}
+	return ids
+}
+
+func TestJobPathspec(t *testing.T) {
+	tests := []struct {
+		name string
+		tags map[string]string
+		want string
+	}{
+		{"task", map[string]string{"metaflow.flow_name": "TrainFlow", "metaflow.run_id": "sfn-1", "metaflow.step_name": "start", "metaflow.task_id": "2"}, "TrainFlow/sfn-1/start/2"},
+		{"run", map[string]string{"metaflow.flow_name": "TrainFlow", "metaflow.run_id": "sfn-1"}, "TrainFlow/sfn-1"},
+		{"missing run", map[string]string{"metaflow.flow_name": "TrainFlow", "metaflow.step_name": "start"}, "TrainFlow"},
+		{"not a Metaflow job", nil, "-"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if pathspec := ops.JobPathspec(ops.BatchJob{Tags: test.tags}); pathspec != test.want {
+				t.Errorf("JobPathspec() = %q, want %q", pathspec, test.want)
+			}
+		})
+	}
+}
+
+func TestListJobs(t *testing.T) {
+	queue := []ops.BatchJob{job("c", "RUNNING", "sfn-2", 3), job("a", "SUCCEEDED", "sfn-1", 1), job("b", "FAILED", "sfn-2", 2)}
+
+	tests := []struct {
+		name  string
+		runID string
+		want  []string
+	}{
+		{"oldest first", "", []string{"a", "b", "c"}},
+		{"one run", "sfn-2", []string{"b", "c"}},
+		{"unknown run", "sfn-3", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := &jobsAPI{jobs: slices.Clone(queue)}
+			jobs, err := ops.ListJobs(context.Background(), api, "metaflow", time.Time{}, test.runID)
+			if err != nil {
+				t.Fatalf("ListJobs() = %v", err)
+			}
+			if got := ids(jobs); !slices.Equal(got, test.want) {
+				t.Errorf("ListJobs() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCancelJobs(t *testing.T) {
+	queue := []ops.BatchJob{job("a", "SUCCEEDED", "sfn-1", 1), job("b", "RUNNABLE", "sfn-1", 2), job("c", "RUNNING", "sfn-1", 3)}
+
+	tests := []struct {
+		name         string
+		terminateErr string
+		cancelled    []string
+		err          bool
+	}{
+		{"active jobs only", "", []string{"b", "c"}, false},
+		{"goes on past failures", "b", []string{"c"}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := &jobsAPI{terminateErr: test.terminateErr}
+			cancelled, err := ops.CancelJobs(context.Background(), api, queue, "test")
+			if (err != nil) != test.err {
+				t.Fatalf("CancelJobs() error = %v, want error %v", err, test.err)
+			}
+			if got := ids(cancelled); !slices.Equal(got, test.cancelled) {
+				t.Errorf("CancelJobs() = %v, want %v", got, test.cancelled)
+			}
+			if !slices.Equal(api.terminated, test.cancelled) {
+				t.Errorf("terminated %v, want %v", api.terminated, test.cancelled)
+			}
+		})
+	}
+}
+
+func TestStreamJobLogs(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []string
+		logs     [][]ops.LogEvent
+		stream   string
+		options  map[string]string
+		logGroup string
+		follow   bool
+		messages []string
+		group    string
+		err      string
+	}{
+		{"finished job", []string{"SUCCEEDED"}, [][]ops.LogEvent{events("start", "end")}, "stream", nil, "", false, []string{"start", "end"}, ops.DefaultLogGroup, ""},
+		{"awslogs group", []string{"SUCCEEDED"}, [][]ops.LogEvent{events("start")}, "stream", map[string]string{"awslogs-group": "/metaflow"}, "", false, []string{"start"}, "/metaflow", ""},
+		{"given group", []string{"SUCCEEDED"}, [][]ops.LogEvent{events("start")}, "stream", map[string]string{"awslogs-group": "/metaflow"}, "/other", false, []string{"start"}, "/other", ""},
+		{"running job without follow", []string{"RUNNING"}, [][]ops.LogEvent{events("start"), events("end")}, "stream", nil, "", false, []string{"start"}, ops.DefaultLogGroup, ""},
+		{"follow until the job finishes", []string{"RUNNING", "RUNNING", "SUCCEEDED"}, [][]ops.LogEvent{events("start"), events("step"), events("end")}, "stream", nil, "", true, []string{"start", "step", "end"}, ops.DefaultLogGroup, ""},
+		{"no log stream", []string{"FAILED"}, nil, "", nil, "", false, nil, "", "job a has no log stream, it is FAILED"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			running := job("a", "", "sfn-1", 1)
+			running.Container.LogStreamName = test.stream
+			running.Container.LogConfiguration.Options = test.options
+			api := &jobsAPI{jobs: []ops.BatchJob{running}, statuses: test.statuses, logs: test.logs}
+
+			var out strings.Builder
+			err := ops.StreamJobLogs(context.Background(), api, &out, "a", test.logGroup, test.follow, time.Millisecond)
+			switch {
+			case test.err == "" && err != nil:
+				t.Fatalf("StreamJobLogs() = %v, want no error", err)
+			case test.err != "" && (err == nil || err.Error() != test.err):
+				t.Fatalf("StreamJobLogs() = %v, want %q", err, test.err)
+			}
+
+			var messages []string
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				if line != "" {
+					// The line starts with the local date and time of the event.
+					messages = append(messages, strings.SplitN(line, " ", 3)[2])
+				}
+			}
+			if !slices.Equal(messages, test.messages) {
+				t.Errorf("StreamJobLogs() wrote %q, want %q", messages, test.messages)
+			}
+			if api.group != test.group {
+				t.Errorf("StreamJobLogs() read %q, want %q", api.group, test.group)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package ops
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+)
+
+type NotebookAPI interface {
+	DescribeNotebookInstance(ctx context.Context, name string) (NotebookInstance, error)
+	StartNotebookInstance(ctx context.Context, name string) error
+	StopNotebookInstance(ctx context.Context, name string) error
+	PresignedNotebookURL(ctx context.Context, name string) (string, error)
+}
+
+// NotebookName is the given instance, or the notebook instance of the deployment when it is empty.
+func NotebookName(ctx context.Context, outputs *OutputsCache, name string) (string, error) {
+	if name != "" {
+		return name, nil
+	}
+	return outputs.Output(ctx, commons.ResultStackName, "NOTEBOOK_INSTANCE_NAME")
+}
+
+// StartNotebook starts the instance unless it is already starting or in service and waits until it is in
+// service. The statuses it waits on are written to progress.
+func StartNotebook(ctx context.Context, api NotebookAPI, name string, progress io.Writer, interval time.Duration) (NotebookInstance, error) {
+	instance, err := api.DescribeNotebookInstance(ctx, name)
+	if err != nil {
+		return NotebookInstance{}, err
+	}
+	switch instance.NotebookInstanceStatus {
+	case "Stopping":
+		// A stopping instance cannot be started, it has to stop first.
+		if _, err := waitForNotebook(ctx, api, name, "Stopped", progress, interval); err != nil {
+			return NotebookInstance{}, err
+		}
+		fallthrough
+	case "Stopped", "Failed":
+		if err := api.StartNotebookInstance(ctx, name); err != nil {
+			return NotebookInstance{}, err
+		}
+	}
+
+	return waitForNotebook(ctx, api, name, "InService", progress, interval)
+}
+
+// StopNotebook stops the instance, it returns false when it was already stopped or stopping.
+func StopNotebook(ctx context.Context, api NotebookAPI, name string) (bool, error) {
+	instance, err := api.DescribeNotebookInstance(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	if instance.NotebookInstanceStatus == "Stopped" || instance.NotebookInstanceStatus == "Stopping" {
+		return false, nil
+	}
+	return true, api.StopNotebookInstance(ctx, name)
+}
+
+// NotebookURL returns a presigned URL opening Jupyter on the instance, which has to be in service.
+func NotebookURL(ctx context.Context, api NotebookAPI, name string) (string, error) {
+	instance, err := api.DescribeNotebookInstance(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if instance.NotebookInstanceStatus != "InService" {
+		return "", fmt.Errorf("%s is %s, start it first", name, instance.NotebookInstanceStatus)
+	}
+	return api.PresignedNotebookURL(ctx, name)
+}
+
+// waitForNotebook polls the instance until it reaches status, it fails as soon as the instance fails.
+func waitForNotebook(ctx context.Context, api NotebookAPI, name, status string, progress io.Writer, interval time.Duration) (NotebookInstance, error) {
+	for {
+		instance, err := api.DescribeNotebookInstance(ctx, name)
+		if err != nil {
+			return NotebookInstance{}, err
+		}
+		switch instance.NotebookInstanceStatus {
+		case status:
+			return instance, nil
+		case "Failed":
+			return instance, fmt.Errorf("notebook instance %s failed: %s", name, instance.FailureReason)
+		}
+
+		fmt.Fprintf(progress, "%s is %s, waiting for %s\n", name, instance.NotebookInstanceStatus, status)
+		if err := sleep(ctx, interval); err != nil {
+			return instance, fmt.Errorf("waiting for notebook instance %s: %w", name, err)
+		}
+	}
+}
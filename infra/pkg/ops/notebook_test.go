@@ -0,0 +1,132 @@
+package ops_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/pkg/ops"
+)
+
+// notebookAPI is a notebook instance going through statuses, one per DescribeNotebookInstance call, the last
+// one sticks.
+type notebookAPI struct {
+	statuses  []string
+	describes int
+	started   bool
+	stopped   bool
+}
+
+func (n *notebookAPI) DescribeNotebookInstance(ctx context.Context, name string) (ops.NotebookInstance, error) {
+	n.describes++
+	status := n.statuses[min(n.describes, len(n.statuses))-1]
+	return ops.NotebookInstance{NotebookInstanceName: name, NotebookInstanceStatus: status, FailureReason: "capacity"}, nil
+}
+
+func (n *notebookAPI) StartNotebookInstance(ctx context.Context, name string) error {
+	n.started = true
+	return nil
+}
+
+func (n *notebookAPI) StopNotebookInstance(ctx context.Context, name string) error {
+	n.stopped = true
+	return nil
+}
+
+func (n *notebookAPI) PresignedNotebookURL(ctx context.Context, name string) (string, error) {
+	return "https://" + name + ".notebook.us-east-2.sagemaker.aws", nil
+}
+
+func TestStartNotebook(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []string
+		started  bool
+		progress string
+		err      string
+	}{
+		{"stopped", []string{"Stopped", "Pending", "InService"}, true, "MetaflowNotebook is Pending, waiting for InService\n", ""},
+		{"failed", []string{"Failed", "Pending", "InService"}, true, "MetaflowNotebook is Pending, waiting for InService\n", ""},
+		{"in service", []string{"InService"}, false, "", ""},
+		{"already starting", []string{"Pending", "Pending", "InService"}, false, "MetaflowNotebook is Pending, waiting for InService\n", ""},
+		{"stopping", []string{"Stopping", "Stopping", "Stopped", "Pending", "InService"},
+			true, "MetaflowNotebook is Stopping, waiting for Stopped\nMetaflowNotebook is Pending, waiting for InService\n", ""},
+		{"fails to start", []string{"Stopped", "Pending", "Failed"}, true, "MetaflowNotebook is Pending, waiting for InService\n",
+			"notebook instance MetaflowNotebook failed: capacity"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := &notebookAPI{statuses: test.statuses}
+			var progress strings.Builder
+			instance, err := ops.StartNotebook(context.Background(), api, "MetaflowNotebook", &progress, time.Millisecond)
+			switch {
+			case test.err == "" && err != nil:
+				t.Fatalf("StartNotebook() = %v, want no error", err)
+			case test.err != "" && (err == nil || err.Error() != test.err):
+				t.Fatalf("StartNotebook() = %v, want %q", err, test.err)
+			case test.err == "" && instance.NotebookInstanceStatus != "InService":
+				t.Errorf("StartNotebook() = %s, want InService", instance.NotebookInstanceStatus)
+			}
+			if api.started != test.started {
+				t.Errorf("started = %v, want %v", api.started, test.started)
+			}
+			if progress.String() != test.progress {
+				t.Errorf("progress = %q, want %q", progress.String(), test.progress)
+			}
+		})
+	}
+}
+
+func TestStopNotebook(t *testing.T) {
+	tests := []struct {
+		status  string
+		stopped bool
+	}{
+		{"InService", true},
+		{"Pending", true},
+		{"Stopping", false},
+		{"Stopped", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.status, func(t *testing.T) {
+			api := &notebookAPI{statuses: []string{test.status}}
+			stopped, err := ops.StopNotebook(context.Background(), api, "MetaflowNotebook")
+			if err != nil {
+				t.Fatalf("StopNotebook() = %v", err)
+			}
+			if stopped != test.stopped || api.stopped != test.stopped {
+				t.Errorf("StopNotebook() = %v and stopped the instance %v, want %v", stopped, api.stopped, test.stopped)
+			}
+		})
+	}
+}
+
+func TestNotebookURL(t *testing.T) {
+	tests := []struct {
+		status string
+		url    string
+		err    string
+	}{
+		{"InService", "https://MetaflowNotebook.notebook.us-east-2.sagemaker.aws", ""},
+		{"Stopped", "", "MetaflowNotebook is Stopped, start it first"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.status, func(t *testing.T) {
+			api := &notebookAPI{statuses: []string{test.status}}
+			url, err := ops.NotebookURL(context.Background(), api, "MetaflowNotebook")
+			switch {
+			case test.err == "" && err != nil:
+				t.Fatalf("NotebookURL() = %v, want no error", err)
+			case test.err != "" && (err == nil || err.Error() != test.err):
+				t.Fatalf("NotebookURL() = %v, want %q", err, test.err)
+			}
+			if url != test.url {
+				t.Errorf("NotebookURL() = %q, want %q", url, test.url)
+			}
+		})
+	}
+}
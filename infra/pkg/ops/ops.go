@@ -0,0 +1,20 @@
+// Package ops runs the deployment operations of the Metaflow platform: deploying and destroying the stacks,
+// reading their outputs and checking the health of what is deployed. The AWS APIs are reached through small
+// interfaces, *awsapi.Client implements all of them and tests can stub them.
+package ops
+
+import "github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/awsapi"
+
+// The types of the AWS API interfaces, aliased so callers outside the module can use them.
+type (
+	Stack            = awsapi.Stack
+	StackOutput      = awsapi.Output
+	Service          = awsapi.Service
+	BatchResource    = awsapi.BatchResource
+	BatchJob         = awsapi.BatchJob
+	LogEvent         = awsapi.LogEvent
+	NotebookInstance = awsapi.NotebookInstance
+)
+
+// ErrStackNotFound is returned by CloudFormationAPI.DescribeStack when the stack is not deployed.
+var ErrStackNotFound = awsapi.ErrStackNotFound
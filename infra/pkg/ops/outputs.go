@@ -0,0 +1,217 @@
+package ops
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	// OutputsDir holds one outputs file per stage.
+	OutputsDir = ".metaflow-outputs"
+	// OutputsVersion is bumped when the layout of the outputs file changes, older files are captured again.
+	OutputsVersion = 1
+	// OutputsMaxAge is how long an outputs file is used, a deploy outside the CLI does not refresh it.
+	OutputsMaxAge = 24 * time.Hour
+)
+
+// CloudFormationAPI reads the deployed stacks.
+type CloudFormationAPI interface {
+	DescribeStack(ctx context.Context, name string) (Stack, error)
+	DescribeStacks(ctx context.Context) ([]Stack, error)
+}
+
+// IdentityAPI tells which account and region the credentials operate on.
+type IdentityAPI interface {
+	CallerIdentity(ctx context.Context) (account string, arn string, err error)
+	Region() string
+}
+
+type OutputsAPI interface {
+	CloudFormationAPI
+	IdentityAPI
+}
+
+// StageOutputs are the outputs of the deployed stacks of one stage, the account and region the app is
+// deployed to. Outputs are keyed by their description, the Metaflow setting they fill.
+type StageOutputs struct {
+	Version    int                          `json:"version"`
+	Stage      string                       `json:"stage"`
+	Account    string                       `json:"account"`
+	Region     string                       `json:"region"`
+	CapturedAt time.Time                    `json:"capturedAt"`
+	Stacks     map[string]map[string]string `json:"stacks"`
+}
+
+// OutputsCache reads and writes the outputs file of the stage of the API.
+type OutputsCache struct {
+	api OutputsAPI
+	// Warnings receives the reasons a stale outputs file is captured again, nil discards them.
+	Warnings io.Writer
+
+	account string
+	// captured is set once the outputs were read from CloudFormation, a stale file is only refreshed once.
+	captured bool
+}
+
+func NewOutputsCache(api OutputsAPI) *OutputsCache {
+	return &OutputsCache{api: api}
+}
+
+// Outputs returns the outputs of the deployment, from the outputs file when there is a usable one.
+func Outputs(ctx context.Context, api OutputsAPI) (StageOutputs, error) {
+	return NewOutputsCache(api).Load(ctx)
+}
+
+func OutputsPath(stage string) string {
+	return filepath.Join(OutputsDir, stage+".json")
+}
+
+// Stage names the outputs file after the account of the credentials and the region.
+func (c *OutputsCache) Stage(ctx context.Context) (string, error) {
+	if c.account == "" {
+		account, _, err := c.api.CallerIdentity(ctx)
+		if err != nil {
+			return "", err
+		}
+		c.account = account
+	}
+	return c.account + "-" + c.api.Region(), nil
+}
+
+// Load returns the cached outputs, they are captured when there is no usable outputs file or it is older than
+// OutputsMaxAge.
+func (c *OutputsCache) Load(ctx context.Context) (StageOutputs, error) {
+	stage, err := c.Stage(ctx)
+	if err != nil {
+		return StageOutputs{}, err
+	}
+	path := OutputsPath(stage)
+
+	bytes, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c.Capture(ctx)
+	}
+	if err != nil {
+		return StageOutputs{}, fmt.Errorf("reading outputs file: %w", err)
+	}
+
+	var outputs StageOutputs
+	if err := json.Unmarshal(bytes, &outputs); err != nil {
+		c.warn("%s is not a valid outputs file, capturing the outputs again: %s", path, err)
+		return c.Capture(ctx)
+	}
+	if outputs.Version != OutputsVersion {
+		c.warn("%s has version %d, capturing the outputs again with version %d", path, outputs.Version, OutputsVersion)
+		return c.Capture(ctx)
+	}
+	if age := time.Since(outputs.CapturedAt); age > OutputsMaxAge {
+		c.warn("%s was captured %s ago, capturing the outputs again", path, age.Round(time.Minute))
+		return c.Capture(ctx)
+	}
+	return outputs, nil
+}
+
+// Capture reads the outputs of the deployed stacks of the app from CloudFormation and writes the outputs file.
+func (c *OutputsCache) Capture(ctx context.Context) (StageOutputs, error) {
+	stage, err := c.Stage(ctx)
+	if err != nil {
+		return StageOutputs{}, err
+	}
+	stacks, err := c.api.DescribeStacks(ctx)
+	if err != nil {
+		return StageOutputs{}, fmt.Errorf("capturing stack outputs: %w", err)
+	}
+
+	known := StackNames()
+	outputs := StageOutputs{
+		Version:    OutputsVersion,
+		Stage:      stage,
+		Account:    c.account,
+		Region:     c.api.Region(),
+		CapturedAt: time.Now().UTC(),
+		Stacks:     map[string]map[string]string{},
+	}
+	for _, stack := range stacks {
+		if !slices.Contains(known, stack.StackName) {
+			continue
+		}
+		values := map[string]string{}
+		for _, output := range stack.Outputs {
+			key := output.Description
+			if key == "" {
+				key = output.OutputKey
+			}
+			values[key] = output.OutputValue
+		}
+		outputs.Stacks[stack.StackName] = values
+	}
+
+	bytes, err := json.MarshalIndent(outputs, "", "  ")
+	if err != nil {
+		return StageOutputs{}, fmt.Errorf("encoding outputs file: %w", err)
+	}
+	if err := os.MkdirAll(OutputsDir, 0o755); err != nil {
+		return StageOutputs{}, fmt.Errorf("writing outputs file: %w", err)
+	}
+	if err := os.WriteFile(OutputsPath(stage), append(bytes, '\n'), 0o644); err != nil {
+		return StageOutputs{}, fmt.Errorf("writing outputs file: %w", err)
+	}
+	c.captured = true
+	return outputs, nil
+}
+
+// Stack returns the outputs of the stack. A file without the stack may predate its deploy, the outputs are
+// captured again once before giving up.
+func (c *OutputsCache) Stack(ctx context.Context, name string) (map[string]string, error) {
+	outputs, err := c.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := outputs.Stacks[name]; !ok && !c.captured {
+		if outputs, err = c.Capture(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	values, ok := outputs.Stacks[name]
+	if !ok {
+		return nil, fmt.Errorf("%s is not deployed", name)
+	}
+	return maps.Clone(values), nil
+}
+
+// Output returns one output of the stack, captured again once when the outputs file does not have it.
+func (c *OutputsCache) Output(ctx context.Context, stack, description string) (string, error) {
+	values, err := c.Stack(ctx, stack)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := values[description]; !ok && !c.captured {
+		if _, err := c.Capture(ctx); err != nil {
+			return "", err
+		}
+		if values, err = c.Stack(ctx, stack); err != nil {
+			return "", err
+		}
+	}
+
+	value, ok := values[description]
+	if !ok {
+		return "", fmt.Errorf("%s has no %s output", stack, description)
+	}
+	return value, nil
+}
+
+func (c *OutputsCache) warn(format string, args ...any) {
+	if c.Warnings != nil {
+		fmt.Fprintf(c.Warnings, "Warning: "+format+"\n", args...)
+	}
+}
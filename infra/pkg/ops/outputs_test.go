@@ -0,0 +1,169 @@
+package ops_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/pkg/ops"
+)
+
+const stage = "123456789012-us-east-2"
+
+// outputsAPI is a deployment in us-east-2 that counts how often the outputs are read from CloudFormation.
+type outputsAPI struct {
+	regionStacks
+	describeStacks int
+}
+
+func newOutputsAPI() *outputsAPI {
+	return &outputsAPI{regionStacks: regionStacks{
+		commons.ResultStackName: stack(commons.ResultStackName, map[string]string{"METAFLOW_SERVICE_URL": "https://metadata"}),
+		// Stacks of other apps are left out of the outputs file.
+		"OtherStack": stack("OtherStack", map[string]string{"URL": "https://other"}),
+	}}
+}
+
+func (a *outputsAPI) DescribeStacks(ctx context.Context) ([]ops.Stack, error) {
+	a.describeStacks++
+	return a.regionStacks.DescribeStacks(ctx)
+}
+
+func (a *outputsAPI) CallerIdentity(ctx context.Context) (string, string, error) {
+	return "123456789012", "arn:aws:iam::123456789012:user/test", nil
+}
+
+func (a *outputsAPI) Region() string {
+	return "us-east-2"
+}
+
+// writeOutputs writes the outputs file of the stage.
+func writeOutputs(t *testing.T, outputs any) {
+	t.Helper()
+	bytes, err := json.Marshal(outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(ops.OutputsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ops.OutputsPath(stage), bytes, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func cached(version int, capturedAt time.Time) ops.StageOutputs {
+	return ops.StageOutputs{
+		Version:    version,
+		Stage:      stage,
+		Account:    "123456789012",
+		Region:     "us-east-2",
+		CapturedAt: capturedAt,
+		Stacks: map[string]map[string]string{
+			commons.ResultStackName: {"METAFLOW_SERVICE_URL": "https://cached"},
+		},
+	}
+}
+
+func TestOutputsCacheLoad(t *testing.T) {
+	tests := []struct {
+		name string
+		// file is the content of the outputs file, nil when there is none.
+		file    any
+		capture bool
+		warning string
+	}{
+		{"no file", nil, true, ""},
+		{"fresh file", cached(ops.OutputsVersion, time.Now().Add(-time.Hour)), false, ""},
+		{"invalid file", "not outputs", true, "is not a valid outputs file"},
+		{"version mismatch", cached(ops.OutputsVersion-1, time.Now()), true, "has version"},
+		{"expired file", cached(ops.OutputsVersion, time.Now().Add(-ops.OutputsMaxAge-time.Hour)), true, "was captured 25h0m0s ago"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			if test.file != nil {
+				writeOutputs(t, test.file)
+			}
+			api := newOutputsAPI()
+			warnings := &strings.Builder{}
+			cache := ops.NewOutputsCache(api)
+			cache.Warnings = warnings
+
+			outputs, err := cache.Load(context.Background())
+			if err != nil {
+				t.Fatalf("Load() = %v", err)
+			}
+
+			want := "https://cached"
+			if test.capture {
+				want = "https://metadata"
+			}
+			if url := outputs.Stacks[commons.ResultStackName]["METAFLOW_SERVICE_URL"]; url != want {
+				t.Errorf("METAFLOW_SERVICE_URL = %q, want %q", url, want)
+			}
+			if captured := api.describeStacks > 0; captured != test.capture {
+				t.Errorf("captured = %v, want %v", captured, test.capture)
+			}
+			if !strings.Contains(warnings.String(), test.warning) || (test.warning == "") != (warnings.Len() == 0) {
+				t.Errorf("warnings = %q, want %q", warnings, test.warning)
+			}
+		})
+	}
+}
+
+func TestOutputsCacheCapture(t *testing.T) {
+	t.Chdir(t.TempDir())
+	before := time.Now().UTC()
+
+	if _, err := ops.NewOutputsCache(newOutputsAPI()).Capture(context.Background()); err != nil {
+		t.Fatalf("Capture() = %v", err)
+	}
+
+	bytes, err := os.ReadFile(ops.OutputsPath(stage))
+	if err != nil {
+		t.Fatalf("reading the outputs file: %v", err)
+	}
+	var outputs ops.StageOutputs
+	if err := json.Unmarshal(bytes, &outputs); err != nil {
+		t.Fatalf("decoding the outputs file: %v", err)
+	}
+	if outputs.Version != ops.OutputsVersion || outputs.Stage != stage || outputs.Account != "123456789012" || outputs.Region != "us-east-2" {
+		t.Errorf("outputs file = %+v", outputs)
+	}
+	if outputs.CapturedAt.Before(before) {
+		t.Errorf("CapturedAt = %v, want after %v", outputs.CapturedAt, before)
+	}
+	if _, ok := outputs.Stacks["OtherStack"]; ok || len(outputs.Stacks) != 1 {
+		t.Errorf("Stacks = %v, want only %s", outputs.Stacks, commons.ResultStackName)
+	}
+}
+
+func TestOutputsCacheStack(t *testing.T) {
+	t.Chdir(t.TempDir())
+	file := cached(ops.OutputsVersion, time.Now())
+	delete(file.Stacks, commons.ResultStackName)
+	writeOutputs(t, file)
+	api := newOutputsAPI()
+	cache := ops.NewOutputsCache(api)
+
+	values, err := cache.Stack(context.Background(), commons.ResultStackName)
+	if err != nil {
+		t.Fatalf("Stack() = %v", err)
+	}
+	if values["METAFLOW_SERVICE_URL"] != "https://metadata" || api.describeStacks != 1 {
+		t.Errorf("Stack() = %v after %d captures, want the deployed outputs after 1", values, api.describeStacks)
+	}
+
+	if _, err := cache.Stack(context.Background(), commons.UIStackName); err == nil {
+		t.Error("Stack(UIStack) found a stack that is not deployed")
+	}
+	if api.describeStacks != 1 {
+		t.Errorf("captured %d times, want a stale file refreshed once", api.describeStacks)
+	}
+}
@@ -0,0 +1,170 @@
+package ops
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/pkg/bootstrap"
+)
+
+const (
+	// bootstrapVersionParameter is written by cdk bootstrap with the default qualifier.
+	bootstrapVersionParameter = "/cdk-bootstrap/hnb659fds/version"
+	// minBootstrapVersion is the version the templates of the default synthesizer check for.
+	minBootstrapVersion = 6
+	// gpuQuotaCode is the "Running On-Demand G and VT instances" quota, counted in vCPUs.
+	gpuQuotaCode = "L-DB2E81BA"
+	// batchZoneIndex is the zone of subnet C, the only subnet of the Batch compute environment.
+	batchZoneIndex = 2
+)
+
+const (
+	CheckPass = "PASS"
+	CheckWarn = "WARN"
+	CheckFail = "FAIL"
+)
+
+type PreflightAPI interface {
+	CloudFormationAPI
+	IdentityAPI
+	GetParameter(ctx context.Context, name string) (string, bool, error)
+	ServiceQuota(ctx context.Context, service, code string) (float64, error)
+	AvailabilityZones(ctx context.Context) ([]string, error)
+	InstanceTypeZones(ctx context.Context, instanceType string) ([]string, error)
+	RoleExists(ctx context.Context, name string) (bool, error)
+	TableExists(ctx context.Context, name string) (bool, error)
+}
+
+type PreflightCheck struct {
+	Check  string `json:"check"`
+	Result string `json:"result"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type PreflightReport struct {
+	Passed bool             `json:"passed"`
+	Checks []PreflightCheck `json:"checks"`
+}
+
+// Preflight checks what makes a deploy fail halfway. Checks that cannot run, e.g. for lack of permissions,
+// are warnings.
+func Preflight(ctx context.Context, api PreflightAPI) PreflightReport {
+	report := PreflightReport{Passed: true}
+	add := func(check, result, detail string) {
+		report.Checks = append(report.Checks, PreflightCheck{check, result, detail})
+		report.Passed = report.Passed && result != CheckFail
+	}
+	unchecked := func(check string, err error) {
+		add(check, CheckWarn, fmt.Sprintf("could not check: %s", err))
+	}
+
+	if path, err := exec.LookPath("cdk"); err != nil {
+		add("cdk CLI", CheckFail, "cdk is not on the PATH, install it with npm install -g aws-cdk")
+	} else {
+		add("cdk CLI", CheckPass, path)
+	}
+
+	certificate, err := tls.LoadX509KeyPair(commons.UICertificateFile, commons.UIPrivateKeyFile)
+	if err != nil {
+		add("UI certificate", CheckFail, err.Error())
+	} else if leaf, err := x509.ParseCertificate(certificate.Certificate[0]); err != nil {
+		add("UI certificate", CheckFail, err.Error())
+	} else if time.Now().After(leaf.NotAfter) {
+		add("UI certificate", CheckFail, fmt.Sprintf("%s expired on %s", commons.UICertificateFile, leaf.NotAfter.Format(time.DateOnly)))
+	} else if time.Until(leaf.NotAfter) < 30*24*time.Hour {
+		add("UI certificate", CheckWarn, fmt.Sprintf("%s expires on %s", commons.UICertificateFile, leaf.NotAfter.Format(time.DateOnly)))
+	} else {
+		add("UI certificate", CheckPass, fmt.Sprintf("%s, valid until %s", leaf.Subject.CommonName, leaf.NotAfter.Format(time.DateOnly)))
+	}
+
+	if account, arn, err := api.CallerIdentity(ctx); err != nil {
+		add("AWS account", CheckFail, err.Error())
+		// Without credentials none of the remaining checks can run.
+		return report
+	} else if account != bootstrap.MainAccountId {
+		add("AWS account", CheckFail, fmt.Sprintf("the credentials are for %s, the app deploys to %s", account, bootstrap.MainAccountId))
+	} else {
+		add("AWS account", CheckPass, arn)
+	}
+
+	add("AWS region", CheckPass, api.Region())
+
+	if value, ok, err := api.GetParameter(ctx, bootstrapVersionParameter); err != nil {
+		unchecked("CDK bootstrap", err)
+	} else if !ok {
+		add("CDK bootstrap", CheckFail, fmt.Sprintf("not bootstrapped, run cdk bootstrap aws://%s/%s", bootstrap.MainAccountId, api.Region()))
+	} else if version, err := strconv.Atoi(value); err != nil || version < minBootstrapVersion {
+		add("CDK bootstrap", CheckFail, fmt.Sprintf("version %s, at least %d is required, run cdk bootstrap again", value, minBootstrapVersion))
+	} else {
+		add("CDK bootstrap", CheckPass, fmt.Sprintf("version %d", version))
+	}
+
+	if quota, err := api.ServiceQuota(ctx, "ec2", gpuQuotaCode); err != nil {
+		unchecked("G and VT vCPU quota", err)
+	} else if quota < commons.BatchMaxvCpus {
+		add("G and VT vCPU quota", CheckFail, fmt.Sprintf(
+			"%.0f vCPUs, the Batch compute environment scales to %d, request an increase of %s", quota, commons.BatchMaxvCpus, gpuQuotaCode,
+		))
+	} else {
+		add("G and VT vCPU quota", CheckPass, fmt.Sprintf("%.0f vCPUs", quota))
+	}
+
+	zones, err := api.AvailabilityZones(ctx)
+	if err == nil && len(zones) <= batchZoneIndex {
+		err = fmt.Errorf("the region has %d availability zones, the networking stack uses 3", len(zones))
+	}
+	offering, offeringErr := api.InstanceTypeZones(ctx, commons.BatchGPUInstanceType)
+	check := commons.BatchGPUInstanceType + " offering"
+	switch {
+	case err != nil:
+		add(check, CheckFail, err.Error())
+	case offeringErr != nil:
+		unchecked(check, offeringErr)
+	case !slices.Contains(offering, zones[batchZoneIndex]):
+		add(check, CheckFail, fmt.Sprintf("not offered in %s where Batch runs, offered in [%s]", zones[batchZoneIndex], strings.Join(offering, ", ")))
+	default:
+		add(check, CheckPass, fmt.Sprintf("offered in %s where Batch runs", zones[batchZoneIndex]))
+	}
+
+	// A resource with a fixed name only conflicts when the stack owning it is not deployed yet.
+	physicalNames := []struct {
+		kind, name, stack string
+		exists            func(context.Context, string) (bool, error)
+	}{
+		{"IAM role", commons.BatchExecutionRoleName, commons.BatchStackName, api.RoleExists},
+		{"DynamoDB table", commons.StateTableName, commons.PersistenceStackName, api.TableExists},
+	}
+	for _, resource := range physicalNames {
+		check := fmt.Sprintf("%s %s", resource.kind, resource.name)
+		exists, err := resource.exists(ctx, resource.name)
+		if err != nil {
+			unchecked(check, err)
+			continue
+		}
+		if !exists {
+			add(check, CheckPass, "name is free")
+			continue
+		}
+
+		_, err = api.DescribeStack(ctx, resource.stack)
+		switch {
+		case errors.Is(err, ErrStackNotFound):
+			add(check, CheckFail, fmt.Sprintf("already exists outside of %s, delete or rename it", resource.stack))
+		case err != nil:
+			unchecked(check, err)
+		default:
+			add(check, CheckPass, "owned by "+resource.stack)
+		}
+	}
+
+	return report
+}
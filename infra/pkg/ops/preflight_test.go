@@ -0,0 +1,184 @@
+package ops_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/pkg/bootstrap"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/pkg/ops"
+)
+
+// preflightAPI is an account ready for the deployment unless a test changes it.
+type preflightAPI struct {
+	regionStacks
+	account   string
+	bootstrap string
+	quota     float64
+	quotaErr  error
+	zones     []string
+	offering  []string
+	roles     []string
+}
+
+func (p *preflightAPI) CallerIdentity(ctx context.Context) (string, string, error) {
+	return p.account, "arn:aws:iam::" + p.account + ":user/deployer", nil
+}
+
+func (p *preflightAPI) Region() string {
+	return bootstrap.MainRegion
+}
+
+func (p *preflightAPI) GetParameter(ctx context.Context, name string) (string, bool, error) {
+	return p.bootstrap, p.bootstrap != "", nil
+}
+
+func (p *preflightAPI) ServiceQuota(ctx context.Context, service, code string) (float64, error) {
+	return p.quota, p.quotaErr
+}
+
+func (p *preflightAPI) AvailabilityZones(ctx context.Context) ([]string, error) {
+	return p.zones, nil
+}
+
+func (p *preflightAPI) InstanceTypeZones(ctx context.Context, instanceType string) ([]string, error) {
+	return p.offering, nil
+}
+
+func (p *preflightAPI) RoleExists(ctx context.Context, name string) (bool, error) {
+	return slices.Contains(p.roles, name), nil
+}
+
+func (p *preflightAPI) TableExists(ctx context.Context, name string) (bool, error) {
+	return false, nil
+}
+
+// writeCertificate writes a self-signed UI certificate and its key to the working directory.
+func writeCertificate(t *testing.T, validFor time.Duration) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "metaflow.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validFor),
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]*pem.Block{
+		commons.UICertificateFile: {Type: "CERTIFICATE", Bytes: certificate},
+		commons.UIPrivateKeyFile:  {Type: "PRIVATE KEY", Bytes: privateKey},
+	}
+	for name, block := range files {
+		if err := os.WriteFile(name, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	tests := []struct {
+		name string
+		// change breaks the ready account.
+		change func(t *testing.T, api *preflightAPI)
+		passed bool
+		check  string
+		result string
+	}{
+		{"ready", func(t *testing.T, api *preflightAPI) {}, true, "G and VT vCPU quota", ops.CheckPass},
+		{"no cdk", func(t *testing.T, api *preflightAPI) {
+			t.Setenv("PATH", t.TempDir())
+		}, false, "cdk CLI", ops.CheckFail},
+		{"expired certificate", func(t *testing.T, api *preflightAPI) {
+			writeCertificate(t, -time.Minute)
+		}, false, "UI certificate", ops.CheckFail},
+		{"expiring certificate", func(t *testing.T, api *preflightAPI) {
+			writeCertificate(t, 7*24*time.Hour)
+		}, true, "UI certificate", ops.CheckWarn},
+		{"other account", func(t *testing.T, api *preflightAPI) {
+			api.account = "123456789012"
+		}, false, "AWS account", ops.CheckFail},
+		{"not bootstrapped", func(t *testing.T, api *preflightAPI) {
+			api.bootstrap = ""
+		}, false, "CDK bootstrap", ops.CheckFail},
+		{"old bootstrap", func(t *testing.T, api *preflightAPI) {
+			api.bootstrap = "5"
+		}, false, "CDK bootstrap", ops.CheckFail},
+		{"low GPU quota", func(t *testing.T, api *preflightAPI) {
+			api.quota = 8
+		}, false, "G and VT vCPU quota", ops.CheckFail},
+		{"unreadable GPU quota", func(t *testing.T, api *preflightAPI) {
+			api.quotaErr = errors.New("AccessDenied")
+		}, true, "G and VT vCPU quota", ops.CheckWarn},
+		{"GPU not offered in the Batch zone", func(t *testing.T, api *preflightAPI) {
+			api.offering = []string{"us-east-2a"}
+		}, false, commons.BatchGPUInstanceType + " offering", ops.CheckFail},
+		{"two zones", func(t *testing.T, api *preflightAPI) {
+			api.zones = api.zones[:2]
+		}, false, commons.BatchGPUInstanceType + " offering", ops.CheckFail},
+		{"role outside its stack", func(t *testing.T, api *preflightAPI) {
+			api.roles = []string{commons.BatchExecutionRoleName}
+		}, false, "IAM role " + commons.BatchExecutionRoleName, ops.CheckFail},
+		{"role owned by its stack", func(t *testing.T, api *preflightAPI) {
+			api.roles = []string{commons.BatchExecutionRoleName}
+			api.regionStacks[commons.BatchStackName] = stack(commons.BatchStackName, nil)
+		}, true, "IAM role " + commons.BatchExecutionRoleName, ops.CheckPass},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			writeCertificate(t, 365*24*time.Hour)
+			cdk := t.TempDir()
+			if err := os.WriteFile(filepath.Join(cdk, "cdk"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("PATH", cdk)
+
+			api := &preflightAPI{
+				regionStacks: regionStacks{},
+				account:      bootstrap.MainAccountId,
+				bootstrap:    "21",
+				quota:        commons.BatchMaxvCpus,
+				zones:        []string{"us-east-2a", "us-east-2b", "us-east-2c"},
+				offering:     []string{"us-east-2b", "us-east-2c"},
+			}
+			test.change(t, api)
+
+			report := ops.Preflight(context.Background(), api)
+			if report.Passed != test.passed {
+				t.Errorf("Passed = %v, want %v: %+v", report.Passed, test.passed, report.Checks)
+			}
+			index := slices.IndexFunc(report.Checks, func(check ops.PreflightCheck) bool {
+				return check.Check == test.check
+			})
+			if index < 0 {
+				t.Fatalf("no %s in %+v", test.check, report.Checks)
+			}
+			if check := report.Checks[index]; check.Result != test.result {
+				t.Errorf("%s = %s (%s), want %s", test.check, check.Result, check.Detail, test.result)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package ops
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+)
+
+type StatusAPI interface {
+	DescribeStacks(ctx context.Context) ([]Stack, error)
+	DescribeService(ctx context.Context, cluster, service string) (Service, error)
+	DescribeComputeEnvironment(ctx context.Context, name string) (BatchResource, error)
+	DescribeJobQueue(ctx context.Context, name string) (BatchResource, error)
+	DescribeNotebookInstance(ctx context.Context, name string) (NotebookInstance, error)
+	DatabaseStatus(ctx context.Context, identifier string, cluster bool) (string, error)
+}
+
+type ComponentStatus struct {
+	Component string `json:"component"`
+	Status    string `json:"status"`
+	Healthy   bool   `json:"healthy"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+type StatusReport struct {
+	Healthy    bool              `json:"healthy"`
+	Components []ComponentStatus `json:"components"`
+}
+
+// optionalStacks are only checked when deployed.
+var optionalStacks = []string{commons.EKSStackName}
+
+// Status checks the stacks, the metadata service, the Batch resources, the notebook instance and the database.
+// secondary returns the CloudFormation API of a region, the DR stack is deployed to the secondary region.
+func Status(ctx context.Context, api StatusAPI, secondary func(region string) CloudFormationAPI, httpClient *http.Client) StatusReport {
+	report := StatusReport{Healthy: true}
+	add := func(component, status string, healthy bool, detail string) {
+		report.Components = append(report.Components, ComponentStatus{component, status, healthy, detail})
+		report.Healthy = report.Healthy && healthy
+	}
+
+	deployed, err := api.DescribeStacks(ctx)
+	if err != nil {
+		add("stacks", "UNKNOWN", false, err.Error())
+		return report
+	}
+	stacks := map[string]Stack{}
+	for _, stack := range deployed {
+		stacks[stack.StackName] = stack
+	}
+
+	addStack := func(name string, stack Stack) {
+		healthy := slices.Contains([]string{"CREATE_COMPLETE", "UPDATE_COMPLETE", "IMPORT_COMPLETE"}, stack.StackStatus)
+		add(name, stack.StackStatus, healthy, stack.StackStatusReason)
+	}
+	for _, name := range StackNames() {
+		stack, ok := stacks[name]
+		switch {
+		case name == commons.DRStackName:
+		case !ok && slices.Contains(optionalStacks, name):
+		case !ok:
+			add(name, "NOT_DEPLOYED", false, "")
+		default:
+			addStack(name, stack)
+		}
+	}
+
+	// The persistence stack names the secondary region when disaster recovery is enabled.
+	if region, ok := stacks[commons.PersistenceStackName].Output("METAFLOW_DR_REGION"); ok {
+		stack, err := secondary(region).DescribeStack(ctx, commons.DRStackName)
+		switch {
+		case errors.Is(err, ErrStackNotFound):
+			add(commons.DRStackName, "NOT_DEPLOYED", false, "expected in "+region)
+		case err != nil:
+			add(commons.DRStackName, "UNKNOWN", false, err.Error())
+		default:
+			addStack(commons.DRStackName, stack)
+		}
+	}
+
+	result, ok := stacks[commons.ResultStackName]
+	if !ok {
+		add("components", "UNKNOWN", false, commons.ResultStackName+" is not deployed")
+		return report
+	}
+	output := func(name string) string {
+		value, _ := result.Output(name)
+		return value
+	}
+
+	serviceURL := strings.TrimSuffix(output("METAFLOW_SERVICE_URL"), "/")
+	if body, err := httpGet(ctx, httpClient, serviceURL+"/ping"); err != nil {
+		add("metadata service /ping", "UNREACHABLE", false, err.Error())
+	} else {
+		add("metadata service /ping", "OK", true, strings.TrimSpace(string(body)))
+	}
+
+	var schema struct {
+		IsUpToDate     bool `json:"is_up_to_date"`
+		CurrentVersion any  `json:"current_version"`
+	}
+	if body, err := httpGet(ctx, httpClient, serviceURL+"/db_schema_status"); err != nil {
+		add("metadata DB schema", "UNKNOWN", false, err.Error())
+	} else if err := json.Unmarshal(body, &schema); err != nil {
+		add("metadata DB schema", "UNKNOWN", false, fmt.Sprintf("decoding /db_schema_status: %s", err))
+	} else if schema.IsUpToDate {
+		add("metadata DB schema", "UP_TO_DATE", true, fmt.Sprintf("version %v", schema.CurrentVersion))
+	} else {
+		add("metadata DB schema", "OUT_OF_DATE", false, fmt.Sprintf("version %v", schema.CurrentVersion))
+	}
+
+	if service, err := api.DescribeService(ctx, output("METADATA_ECS_CLUSTER"), output("METADATA_ECS_SERVICE")); err != nil {
+		add("metadata ECS service", "UNKNOWN", false, err.Error())
+	} else {
+		healthy := service.Status == "ACTIVE" && service.DesiredCount > 0 && service.RunningCount >= service.DesiredCount
+		add("metadata ECS service", service.Status, healthy, fmt.Sprintf(
+			"%d/%d tasks running, %d pending", service.RunningCount, service.DesiredCount, service.PendingCount,
+		))
+	}
+
+	batchResource := func(component string, resource BatchResource, err error) {
+		if err != nil {
+			add(component, "UNKNOWN", false, err.Error())
+			return
+		}
+		healthy := resource.Status == "VALID" && resource.State == "ENABLED"
+		add(component, resource.Status+"/"+resource.State, healthy, resource.StatusReason)
+	}
+	environment, err := api.DescribeComputeEnvironment(ctx, output("BATCH_COMPUTE_ENVIRONMENT"))
+	batchResource("Batch compute environment", environment, err)
+	queue, err := api.DescribeJobQueue(ctx, output("METAFLOW_BATCH_JOB_QUEUE"))
+	batchResource("Batch job queue", queue, err)
+
+	// A stopped notebook is stopped on purpose, only failures are unhealthy.
+	if notebook, err := api.DescribeNotebookInstance(ctx, output("NOTEBOOK_INSTANCE_NAME")); err != nil {
+		add("notebook instance", "UNKNOWN", false, err.Error())
+	} else {
+		add("notebook instance", notebook.NotebookInstanceStatus, notebook.NotebookInstanceStatus != "Failed", notebook.FailureReason)
+	}
+
+	cluster := output("METADATA_DB_TYPE") == commons.DBClusterTargetType
+	if status, err := api.DatabaseStatus(ctx, output("METADATA_DB_IDENTIFIER"), cluster); err != nil {
+		add("metadata database", "UNKNOWN", false, err.Error())
+	} else {
+		add("metadata database", status, status == "available", "")
+	}
+
+	return report
+}
+
+func httpGet(ctx context.Context, httpClient *http.Client, url string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: HTTP %d", url, response.StatusCode)
+	}
+	return body, nil
+}
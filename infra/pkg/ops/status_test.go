@@ -0,0 +1,152 @@
+package ops_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/pkg/ops"
+)
+
+// statusAPI answers with a deployment whose stacks and components are all healthy unless a test changes them.
+type statusAPI struct {
+	stacks  []ops.Stack
+	service ops.Service
+}
+
+func (s *statusAPI) DescribeStacks(ctx context.Context) ([]ops.Stack, error) {
+	return s.stacks, nil
+}
+
+func (s *statusAPI) DescribeService(ctx context.Context, cluster, service string) (ops.Service, error) {
+	return s.service, nil
+}
+
+func (s *statusAPI) DescribeComputeEnvironment(ctx context.Context, name string) (ops.BatchResource, error) {
+	return ops.BatchResource{Name: name, Status: "VALID", State: "ENABLED"}, nil
+}
+
+func (s *statusAPI) DescribeJobQueue(ctx context.Context, name string) (ops.BatchResource, error) {
+	return ops.BatchResource{Name: name, Status: "VALID", State: "ENABLED"}, nil
+}
+
+func (s *statusAPI) DescribeNotebookInstance(ctx context.Context, name string) (ops.NotebookInstance, error) {
+	return ops.NotebookInstance{NotebookInstanceName: name, NotebookInstanceStatus: "Stopped"}, nil
+}
+
+func (s *statusAPI) DatabaseStatus(ctx context.Context, identifier string, cluster bool) (string, error) {
+	return "available", nil
+}
+
+// regionStacks is the CloudFormation API of one region, stacks not in it are not deployed.
+type regionStacks map[string]ops.Stack
+
+func (r regionStacks) DescribeStack(ctx context.Context, name string) (ops.Stack, error) {
+	stack, ok := r[name]
+	if !ok {
+		return ops.Stack{}, ops.ErrStackNotFound
+	}
+	return stack, nil
+}
+
+func (r regionStacks) DescribeStacks(ctx context.Context) ([]ops.Stack, error) {
+	var stacks []ops.Stack
+	for _, stack := range r {
+		stacks = append(stacks, stack)
+	}
+	return stacks, nil
+}
+
+func stack(name string, outputs map[string]string) ops.Stack {
+	stack := ops.Stack{StackName: name, StackStatus: "UPDATE_COMPLETE"}
+	for description, value := range outputs {
+		stack.Outputs = append(stack.Outputs, ops.StackOutput{OutputKey: description, OutputValue: value, Description: description})
+	}
+	return stack
+}
+
+// metadataService serves /ping and /db_schema_status like the metadata service.
+func metadataService(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "pong")
+	})
+	mux.HandleFunc("/db_schema_status", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"is_up_to_date": true, "current_version": 42}`)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		// change breaks the healthy deployment.
+		change    func(api *statusAPI, secondary regionStacks)
+		healthy   bool
+		component string
+		status    string
+	}{
+		{"healthy", func(api *statusAPI, secondary regionStacks) {}, true, "metadata ECS service", "ACTIVE"},
+		{"unhealthy service", func(api *statusAPI, secondary regionStacks) {
+			api.service.RunningCount = 0
+		}, false, "metadata ECS service", "ACTIVE"},
+		{"missing ResultStack", func(api *statusAPI, secondary regionStacks) {
+			api.stacks = slices.DeleteFunc(api.stacks, func(stack ops.Stack) bool {
+				return stack.StackName == commons.ResultStackName
+			})
+		}, false, "components", "UNKNOWN"},
+		{"DR stack in the secondary region", func(api *statusAPI, secondary regionStacks) {}, true, commons.DRStackName, "UPDATE_COMPLETE"},
+		{"missing DR stack", func(api *statusAPI, secondary regionStacks) {
+			delete(secondary, commons.DRStackName)
+		}, false, commons.DRStackName, "NOT_DEPLOYED"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := metadataService(t)
+			api := &statusAPI{service: ops.Service{Status: "ACTIVE", RunningCount: 1, DesiredCount: 1}}
+			for _, name := range ops.StackNames() {
+				switch name {
+				case commons.DRStackName, commons.EKSStackName:
+				case commons.PersistenceStackName:
+					api.stacks = append(api.stacks, stack(name, map[string]string{"METAFLOW_DR_REGION": "us-west-2"}))
+				case commons.ResultStackName:
+					api.stacks = append(api.stacks, stack(name, map[string]string{"METAFLOW_SERVICE_URL": server.URL + "/"}))
+				default:
+					api.stacks = append(api.stacks, stack(name, nil))
+				}
+			}
+			secondary := regionStacks{commons.DRStackName: stack(commons.DRStackName, nil)}
+			test.change(api, secondary)
+
+			var regions []string
+			report := ops.Status(context.Background(), api, func(region string) ops.CloudFormationAPI {
+				regions = append(regions, region)
+				return secondary
+			}, server.Client())
+
+			if report.Healthy != test.healthy {
+				t.Errorf("Healthy = %v, want %v: %+v", report.Healthy, test.healthy, report.Components)
+			}
+			index := slices.IndexFunc(report.Components, func(component ops.ComponentStatus) bool {
+				return component.Component == test.component
+			})
+			if index < 0 {
+				t.Fatalf("no %s in %+v", test.component, report.Components)
+			}
+			if status := report.Components[index].Status; status != test.status {
+				t.Errorf("%s = %s, want %s", test.component, status, test.status)
+			}
+			if !slices.Equal(regions, []string{"us-west-2"}) {
+				t.Errorf("the DR stack was read in %v, want us-west-2", regions)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package ops
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/pkg/bootstrap"
+)
+
+// ContextFile is the context file of the app next to cdk.json, the CDK CLI reads it on every run.
+const ContextFile = "cdk.context.json"
+
+// ImageVersions are the image tags of the metadata service and the UI.
+type ImageVersions struct {
+	Metadata string `json:"metadata"`
+	UI       string `json:"ui"`
+}
+
+// DeployedImageVersions returns the image tags the deployed stacks run. The outputs are captured first, the
+// outputs file misses the deploys made outside the CLI and an upgrade would compare against stale versions.
+func DeployedImageVersions(ctx context.Context, outputs *OutputsCache) (ImageVersions, error) {
+	if _, err := outputs.Capture(ctx); err != nil {
+		return ImageVersions{}, err
+	}
+	metadata, err := outputs.Output(ctx, commons.CoreStackName, "METAFLOW_METADATA_VERSION")
+	if err != nil {
+		return ImageVersions{}, err
+	}
+	ui, err := outputs.Output(ctx, commons.UIStackName, "METAFLOW_UI_VERSION")
+	if err != nil {
+		return ImageVersions{}, err
+	}
+	return ImageVersions{Metadata: metadata, UI: ui}, nil
+}
+
+// UpgradeTarget resolves the requested versions against the deployed ones. An empty version keeps the deployed
+// one, and a version older than the deployed one is refused unless allowDowngrade is set.
+func UpgradeTarget(current, requested ImageVersions, allowDowngrade bool) (ImageVersions, error) {
+	target := current
+	if requested.Metadata != "" {
+		target.Metadata = requested.Metadata
+	}
+	if requested.UI != "" {
+		target.UI = requested.UI
+	}
+	if allowDowngrade {
+		return target, nil
+	}
+	for _, image := range []struct{ name, current, target string }{
+		{"metadata", current.Metadata, target.Metadata},
+		{"ui", current.UI, target.UI},
+	} {
+		if older, ok := compareVersions(image.target, image.current); ok && older < 0 {
+			return target, fmt.Errorf("%s %s is older than the deployed %s, pass --allow-downgrade to downgrade", image.name, image.target, image.current)
+		}
+	}
+	return target, nil
+}
+
+// compareVersions compares image tags like v2.5.0, ok is false unless both are such tags.
+func compareVersions(a, b string) (int, bool) {
+	parse := func(version string) ([]int, bool) {
+		var numbers []int
+		for _, part := range strings.Split(strings.TrimPrefix(version, "v"), ".") {
+			number, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, false
+			}
+			numbers = append(numbers, number)
+		}
+		return numbers, true
+	}
+	x, okA := parse(a)
+	y, okB := parse(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	return slices.Compare(x, y), true
+}
+
+// Upgrade deploys the metadata service and the UI with the target images. Circuit breakers roll the services
+// back and the schema migration runs with the new metadata image. Once deployed, the versions are pinned in
+// ContextFile so the following deploys keep them.
+func Upgrade(ctx context.Context, cdk CDK, outputs *OutputsCache, target ImageVersions) error {
+	err := cdk.Run(
+		ctx, "deploy", commons.CoreStackName, commons.UIStackName,
+		"--exclusively",
+		"--require-approval", "never",
+		"-c", bootstrap.MetadataVersionContextKey+"="+target.Metadata,
+		"-c", bootstrap.UIVersionContextKey+"="+target.UI,
+	)
+	if err != nil {
+		return err
+	}
+	if err := PinImageVersions(target); err != nil {
+		return err
+	}
+	_, err = outputs.Capture(ctx)
+	return err
+}
+
+// PinImageVersions writes the image versions to ContextFile, keeping the context already there.
+func PinImageVersions(versions ImageVersions) error {
+	values := map[string]any{}
+	bytes, err := os.ReadFile(ContextFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("reading %s: %w", ContextFile, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(bytes, &values); err != nil {
+			return fmt.Errorf("decoding %s: %w", ContextFile, err)
+		}
+	}
+
+	values[bootstrap.MetadataVersionContextKey] = versions.Metadata
+	values[bootstrap.UIVersionContextKey] = versions.UI
+	if bytes, err = json.MarshalIndent(values, "", "  "); err != nil {
+		return fmt.Errorf("encoding %s: %w", ContextFile, err)
+	}
+	if err := os.WriteFile(ContextFile, append(bytes, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing %s: %w", ContextFile, err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package ops_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/pkg/ops"
+)
+
+func TestDeployedImageVersions(t *testing.T) {
+	t.Chdir(t.TempDir())
+	// The outputs file still has the versions of an upgrade made outside the CLI.
+	file := cached(ops.OutputsVersion, time.Now())
+	file.Stacks[commons.CoreStackName] = map[string]string{"METAFLOW_METADATA_VERSION": "v2.4.0"}
+	file.Stacks[commons.UIStackName] = map[string]string{"METAFLOW_UI_VERSION": "v1.2.0"}
+	writeOutputs(t, file)
+
+	api := newOutputsAPI()
+	api.regionStacks[commons.CoreStackName] = stack(commons.CoreStackName, map[string]string{"METAFLOW_METADATA_VERSION": "v2.5.0"})
+	api.regionStacks[commons.UIStackName] = stack(commons.UIStackName, map[string]string{"METAFLOW_UI_VERSION": "v1.3.0"})
+
+	versions, err := ops.DeployedImageVersions(context.Background(), ops.NewOutputsCache(api))
+	if err != nil {
+		t.Fatalf("DeployedImageVersions() = %v", err)
+	}
+	if want := (ops.ImageVersions{Metadata: "v2.5.0", UI: "v1.3.0"}); versions != want {
+		t.Errorf("DeployedImageVersions() = %+v, want the deployed %+v", versions, want)
+	}
+}
+
+func TestUpgradeTarget(t *testing.T) {
+	deployed := ops.ImageVersions{Metadata: "v2.5.0", UI: "v1.3.14"}
+	tests := []struct {
+		name           string
+		requested      ops.ImageVersions
+		allowDowngrade bool
+		target         ops.ImageVersions
+		err            string
+	}{
+		{"no flags keep the deployed versions", ops.ImageVersions{}, false, deployed, ""},
+		{"ui only keeps the deployed metadata", ops.ImageVersions{UI: "v1.4.0"}, false, ops.ImageVersions{Metadata: "v2.5.0", UI: "v1.4.0"}, ""},
+		{"metadata only keeps the deployed ui", ops.ImageVersions{Metadata: "v2.10.0"}, false, ops.ImageVersions{Metadata: "v2.10.0", UI: "v1.3.14"}, ""},
+		{"downgrade", ops.ImageVersions{Metadata: "v2.4.9"}, false, ops.ImageVersions{Metadata: "v2.4.9", UI: "v1.3.14"},
+			"metadata v2.4.9 is older than the deployed v2.5.0, pass --allow-downgrade to downgrade"},
+		{"ui downgrade", ops.ImageVersions{UI: "v1.3.2"}, false, ops.ImageVersions{Metadata: "v2.5.0", UI: "v1.3.2"},
+			"ui v1.3.2 is older than the deployed v1.3.14, pass --allow-downgrade to downgrade"},
+		{"allowed downgrade", ops.ImageVersions{Metadata: "v2.4.9"}, true, ops.ImageVersions{Metadata: "v2.4.9", UI: "v1.3.14"}, ""},
+		{"tag that is not a version", ops.ImageVersions{Metadata: "latest"}, false, ops.ImageVersions{Metadata: "latest", UI: "v1.3.14"}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target, err := ops.UpgradeTarget(deployed, test.requested, test.allowDowngrade)
+			switch {
+			case test.err == "" && err != nil:
+				t.Fatalf("UpgradeTarget() = %v, want no error", err)
+			case test.err != "" && (err == nil || err.Error() != test.err):
+				t.Fatalf("UpgradeTarget() = %v, want %q", err, test.err)
+			}
+			if target != test.target {
+				t.Errorf("UpgradeTarget() = %+v, want %+v", target, test.target)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// Package policy checks the constructs of the app against compliance rules while it is synthesized. The rules
+// run as a CDK aspect, findings become warnings, or errors that fail cdk synth in strict mode. A finding can
+// be suppressed on the construct, or on any of its scopes, with a justification.
+package policy
+
+import (
+	"fmt"
+
+	"github.com/AlekSi/pointer"
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/constructs-go/constructs/v10"
+)
+
+// suppressionMetadata is the metadata type of suppressions, they end up in the cloud assembly next to the
+// construct they apply to.
+const suppressionMetadata = "metaflow:policy-suppression"
+
+// Rule flags the constructs it does not accept.
+type Rule struct {
+	ID          string
+	Description string
+	// Check returns one finding per violation of the rule by the resource, it is called for every CloudFormation
+	// resource of the app.
+	Check func(node constructs.IConstruct) []string
+}
+
+// Checker is the aspect running the rules over the app.
+type Checker struct {
+	Rules []Rule
+	// Strict reports the findings as errors instead of warnings.
+	Strict bool
+}
+
+// Apply runs the rules over every construct of the app when it is synthesized.
+func Apply(app awscdk.App, strict bool, rules []Rule) {
+	awscdk.Aspects_Of(app).Add(&Checker{Rules: rules, Strict: strict}, nil)
+}
+
+func (c *Checker) Visit(node constructs.IConstruct) {
+	if !*awscdk.CfnResource_IsCfnResource(node) {
+		return
+	}
+	annotations := awscdk.Annotations_Of(node)
+	for _, rule := range c.Rules {
+		for _, finding := range rule.Check(node) {
+			message := fmt.Sprintf("[%s] %s", rule.ID, finding)
+			if justification, ok := suppression(node, rule.ID); ok {
+				annotations.AddInfo(pointer.ToString(fmt.Sprintf("%s (suppressed: %s)", message, justification)))
+			} else if c.Strict {
+				annotations.AddError(pointer.ToString(message))
+			} else {
+				annotations.AddWarningV2(pointer.ToString("policy:"+rule.ID), pointer.ToString(message))
+			}
+		}
+	}
+}
+
+// Suppress accepts the findings of the rule on the construct and the constructs it contains. The justification
+// is required, it is reported next to every finding it suppresses.
+func Suppress(construct constructs.IConstruct, ruleID string, justification string) {
+	if justification == "" {
+		awscdk.Annotations_Of(construct).AddError(pointer.ToString(fmt.Sprintf("suppression of %s has no justification", ruleID)))
+		return
+	}
+	construct.Node().AddMetadata(
+		pointer.ToString(suppressionMetadata),
+		map[string]string{"rule": ruleID, "justification": justification},
+		nil,
+	)
+}
+
+// suppression returns the justification of the closest suppression of the rule, looking from the node up.
+func suppression(node constructs.IConstruct, ruleID string) (string, bool) {
+	scopes := *node.Node().Scopes()
+	for i := len(scopes) - 1; i >= 0; i-- {
+		for _, entry := range *scopes[i].Node().Metadata() {
+			if *entry.Type != suppressionMetadata {
+				continue
+			}
+			data, _ := entry.Data.(map[string]any)
+			if data["rule"] == ruleID {
+				justification, _ := data["justification"].(string)
+				return justification, true
+			}
+		}
+	}
+	return "", false
+}
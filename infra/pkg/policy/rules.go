@@ -0,0 +1,307 @@
+package policy
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awselasticloadbalancingv2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awslogs"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsrds"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awss3"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssagemaker"
+	"github.com/aws/constructs-go/constructs/v10"
+)
+
+const (
+	OpenIngressRule         = "SG-OPEN-INGRESS"
+	WildcardWriteRule       = "IAM-WILDCARD-WRITE"
+	UnencryptedRule         = "UNENCRYPTED-STORAGE"
+	PublicDatabaseRule      = "RDS-PUBLIC"
+	UserDataSecretRule      = "USER-DATA-SECRET"
+	wildcardResource        = "*"
+	anyIPv4, anyIPv6        = "0.0.0.0/0", "::/0"
+	applicationLoadBalancer = "application"
+)
+
+// readActionPrefixes start the names of the IAM actions that only read, every other action may write.
+var readActionPrefixes = []string{"Describe", "List", "Get", "BatchGet", "BatchCheck", "Head", "Lookup", "Search", "Query", "Scan", "Select", "View"}
+
+// secretPatterns match credentials written in clear in a script.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`AKIA[0-9A-Z]{16}`),
+	regexp.MustCompile(`(?i)aws_secret_access_key\s*=`),
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`),
+	regexp.MustCompile(`(?i)(password|passwd|secret|token)\s*[=:]\s*['"]?[^\s'"$]{6,}`),
+	// A user:password pair piped to chpasswd sets a login password.
+	regexp.MustCompile(`[\w.-]+:[^\s'"$:]{6,}['"]?\s*\|\s*(sudo\s+)?chpasswd`),
+}
+
+// BuiltinRules is the rule pack of the app. Each call returns new rules, they cache what they read from the app.
+func BuiltinRules() []Rule {
+	return []Rule{
+		openIngress(),
+		{
+			ID:          WildcardWriteRule,
+			Description: "IAM statements of the Metaflow roles allowing actions that write on every resource",
+			Check:       wildcardWrite,
+		},
+		{
+			ID:          UnencryptedRule,
+			Description: "RDS databases, S3 buckets and CloudWatch log groups without encryption at rest",
+			Check:       unencrypted,
+		},
+		{
+			ID:          PublicDatabaseRule,
+			Description: "RDS instances reachable from the internet",
+			Check:       publicDatabase,
+		},
+		{
+			ID:          UserDataSecretRule,
+			Description: "credentials in clear in EC2 user data or notebook lifecycle scripts",
+			Check:       userDataSecret,
+		},
+	}
+}
+
+// openIngress flags security groups open to the internet, except the ones of application load balancers.
+func openIngress() Rule {
+	var once sync.Once
+	var loadBalancerGroups []string
+	// The group ids are tokens, a security group given to a load balancer of another stack keeps its token.
+	loadBalanced := func(node constructs.IConstruct, groupID *string) bool {
+		once.Do(func() {
+			for _, child := range *node.Node().Root().Node().FindAll(constructs.ConstructOrder_PREORDER) {
+				loadBalancer, ok := child.(awselasticloadbalancingv2.CfnLoadBalancer)
+				if !ok || (loadBalancer.Type() != nil && *loadBalancer.Type() != applicationLoadBalancer) || loadBalancer.SecurityGroups() == nil {
+					continue
+				}
+				for _, group := range *loadBalancer.SecurityGroups() {
+					loadBalancerGroups = append(loadBalancerGroups, *group)
+				}
+			}
+		})
+		return groupID != nil && slices.Contains(loadBalancerGroups, *groupID)
+	}
+
+	return Rule{
+		ID:          OpenIngressRule,
+		Description: "security groups allowing ingress from 0.0.0.0/0 or ::/0, other than the ones of application load balancers",
+		Check: func(node constructs.IConstruct) []string {
+			var findings []string
+			open := func(groupID *string, cidr string, protocol, fromPort, toPort any) {
+				if (cidr == anyIPv4 || cidr == anyIPv6) && !loadBalanced(node, groupID) {
+					findings = append(findings, fmt.Sprintf("ingress from %s on %s", cidr, ports(protocol, fromPort, toPort)))
+				}
+			}
+
+			switch resource := node.(type) {
+			case awsec2.CfnSecurityGroup:
+				rules, _ := resolve(node, resource.SecurityGroupIngress()).([]any)
+				for _, rule := range rules {
+					rule, _ := rule.(map[string]any)
+					for _, cidr := range []string{"cidrIp", "cidrIpv6"} {
+						value, _ := rule[cidr].(string)
+						open(resource.AttrGroupId(), value, rule["ipProtocol"], rule["fromPort"], rule["toPort"])
+					}
+				}
+			case awsec2.CfnSecurityGroupIngress:
+				for _, cidr := range []*string{resource.CidrIp(), resource.CidrIpv6()} {
+					if cidr != nil {
+						open(resource.GroupId(), *cidr, resource.IpProtocol(), resource.FromPort(), resource.ToPort())
+					}
+				}
+			}
+			return findings
+		},
+	}
+}
+
+func ports(protocol, fromPort, toPort any) string {
+	protocol = deref(protocol)
+	if protocol == "-1" {
+		return "all traffic"
+	}
+	fromPort, toPort = deref(fromPort), deref(toPort)
+	if fromPort == toPort {
+		return fmt.Sprintf("%v port %v", protocol, fromPort)
+	}
+	return fmt.Sprintf("%v ports %v-%v", protocol, fromPort, toPort)
+}
+
+func deref(value any) any {
+	switch value := value.(type) {
+	case *string:
+		return *value
+	case *float64:
+		return *value
+	}
+	return value
+}
+
+// wildcardWrite flags the statements of the roles of RolesStack allowing write actions on every resource.
+func wildcardWrite(node constructs.IConstruct) []string {
+	if stack := awscdk.Stack_Of(node); *stack.StackName() != commons.RolesStackName {
+		return nil
+	}
+
+	var documents []any
+	switch resource := node.(type) {
+	case awsiam.CfnPolicy:
+		documents = append(documents, resolve(node, resource.PolicyDocument()))
+	case awsiam.CfnManagedPolicy:
+		documents = append(documents, resolve(node, resource.PolicyDocument()))
+	case awsiam.CfnRole:
+		policies, _ := resolve(node, resource.Policies()).([]any)
+		for _, policy := range policies {
+			policy, _ := policy.(map[string]any)
+			documents = append(documents, policy["policyDocument"])
+		}
+	}
+
+	var findings []string
+	for _, document := range documents {
+		document, _ := document.(map[string]any)
+		statements, _ := document["Statement"].([]any)
+		for _, statement := range statements {
+			statement, _ := statement.(map[string]any)
+			if statement["Effect"] != "Allow" || !slices.Contains(list(statement["Resource"]), wildcardResource) {
+				continue
+			}
+			writes := slices.DeleteFunc(list(statement["Action"]), readOnly)
+			if len(writes) == 0 {
+				continue
+			}
+			name := "statement"
+			if sid, ok := statement["Sid"].(string); ok {
+				name = "statement " + sid
+			}
+			findings = append(findings, fmt.Sprintf("%s allows %s on resource *", name, strings.Join(writes, ", ")))
+		}
+	}
+	return findings
+}
+
+func readOnly(action string) bool {
+	_, name, _ := strings.Cut(action, ":")
+	for _, prefix := range readActionPrefixes {
+		if strings.HasPrefix(name, prefix) && !strings.Contains(name, "*") {
+			return true
+		}
+	}
+	return false
+}
+
+// unencrypted flags storage without encryption at rest. Instances of a cluster are encrypted by the cluster and
+// databases restored from a snapshot keep the encryption of the snapshot.
+func unencrypted(node constructs.IConstruct) []string {
+	switch resource := node.(type) {
+	case awsrds.CfnDBInstance:
+		if resource.DbClusterIdentifier() == nil && resource.DbSnapshotIdentifier() == nil && resolve(node, resource.StorageEncrypted()) != true {
+			return []string{"the database storage is not encrypted"}
+		}
+	case awsrds.CfnDBCluster:
+		if resource.SnapshotIdentifier() == nil && resolve(node, resource.StorageEncrypted()) != true {
+			return []string{"the database cluster storage is not encrypted"}
+		}
+	case awss3.CfnBucket:
+		if resource.BucketEncryption() == nil {
+			return []string{"the bucket has no default encryption"}
+		}
+	case awslogs.CfnLogGroup:
+		if resource.KmsKeyId() == nil {
+			return []string{"the log group is not encrypted with a KMS key"}
+		}
+	}
+	return nil
+}
+
+func publicDatabase(node constructs.IConstruct) []string {
+	if resource, ok := node.(awsrds.CfnDBInstance); ok && resolve(node, resource.PubliclyAccessible()) == true {
+		return []string{"the database instance is publicly accessible"}
+	}
+	return nil
+}
+
+// userDataSecret looks for credentials in the scripts instances and notebooks run at boot, they are readable by
+// anyone allowed to describe the resource.
+func userDataSecret(node constructs.IConstruct) []string {
+	var scripts []any
+	switch resource := node.(type) {
+	case awsec2.CfnLaunchTemplate:
+		data, _ := resolve(node, resource.LaunchTemplateData()).(map[string]any)
+		scripts = append(scripts, data["userData"])
+	case awsec2.CfnInstance:
+		scripts = append(scripts, resolve(node, resource.UserData()))
+	case awssagemaker.CfnNotebookInstanceLifecycleConfig:
+		scripts = append(scripts, resolve(node, resource.OnCreate()), resolve(node, resource.OnStart()))
+	}
+
+	var findings []string
+	for _, text := range texts(scripts) {
+		for _, pattern := range secretPatterns {
+			if match := pattern.FindString(text); match != "" {
+				findings = append(findings, fmt.Sprintf("the script contains what looks like a credential: %.12s...", match))
+			}
+		}
+	}
+	return findings
+}
+
+// texts returns the strings of a resolved value, the base64 encoded ones decoded as well.
+func texts(value any) []string {
+	switch value := value.(type) {
+	case string:
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err == nil && len(decoded) > 0 && utf8.Valid(decoded) {
+			return []string{value, string(decoded)}
+		}
+		return []string{value}
+	case []any:
+		var result []string
+		for _, item := range value {
+			result = append(result, texts(item)...)
+		}
+		return result
+	case map[string]any:
+		var result []string
+		for _, item := range value {
+			result = append(result, texts(item)...)
+		}
+		return result
+	}
+	return nil
+}
+
+// resolve turns a property of a resource into plain values, CloudFormation intrinsics stay maps.
+func resolve(node constructs.IConstruct, value any) any {
+	if value == nil {
+		return nil
+	}
+	return awscdk.Stack_Of(node).Resolve(value)
+}
+
+// list returns a policy element that is a string or a list of strings as a list.
+func list(value any) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []any:
+		var result []string
+		for _, item := range value {
+			if item, ok := item.(string); ok {
+				result = append(result, item)
+			}
+		}
+		return result
+	}
+	return nil
+}
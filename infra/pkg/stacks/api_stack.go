@@ -27,7 +27,7 @@ type ApiStackOutput struct {
 func BuildApiStack(input ApiStackInput) ApiStackOutput {
 	stack := awscdk.NewStack(
 		input.Account.App,
-		pointer.ToString("ApiStack"),
+		pointer.ToString(commons.ApiStackName),
 		&awscdk.StackProps{
 			Env: input.Account.Env(),
 		},
@@ -0,0 +1,152 @@
+package stacks
+
+import (
+	"fmt"
+
+	"github.com/AlekSi/pointer"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsapplicationautoscaling"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsecs"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awselasticloadbalancingv2"
+	"github.com/aws/constructs-go/constructs/v10"
+)
+
+func serviceScalableTarget(construct constructs.Construct, name string, cluster awsecs.Cluster, service awsecs.CfnService, config commons.ServiceConfig) awsapplicationautoscaling.CfnScalableTarget {
+	return awsapplicationautoscaling.NewCfnScalableTarget(
+		construct,
+		pointer.ToString(name),
+		&awsapplicationautoscaling.CfnScalableTargetProps{
+			MinCapacity:       pointer.ToFloat64(float64(config.MinTasks)),
+			MaxCapacity:       pointer.ToFloat64(float64(config.MaxTasks)),
+			ResourceId:        pointer.ToString(fmt.Sprintf("service/%s/%s", *cluster.ClusterName(), *service.AttrName())),
+			ScalableDimension: pointer.ToString("ecs:service:DesiredCount"),
+			ServiceNamespace:  pointer.ToString("ecs"),
+		},
+	)
+}
+
+func cpuScalingPolicy(construct constructs.Construct, name string, target awsapplicationautoscaling.CfnScalableTarget, config commons.ServiceConfig) awsapplicationautoscaling.CfnScalingPolicy {
+	return awsapplicationautoscaling.NewCfnScalingPolicy(
+		construct,
+		pointer.ToString(name),
+		&awsapplicationautoscaling.CfnScalingPolicyProps{
+			PolicyName:      pointer.ToString(name),
+			PolicyType:      pointer.ToString("TargetTrackingScaling"),
+			ScalingTargetId: target.Ref(),
+			TargetTrackingScalingPolicyConfiguration: &awsapplicationautoscaling.CfnScalingPolicy_TargetTrackingScalingPolicyConfigurationProperty{
+				TargetValue:      pointer.ToFloat64(config.TargetCPUUtilization),
+				ScaleInCooldown:  pointer.ToFloat64(300),
+				ScaleOutCooldown: pointer.ToFloat64(60),
+				PredefinedMetricSpecification: &awsapplicationautoscaling.CfnScalingPolicy_PredefinedMetricSpecificationProperty{
+					PredefinedMetricType: pointer.ToString("ECSServiceAverageCPUUtilization"),
+				},
+			},
+		},
+	)
+}
+
+// activeFlowsScalingPolicy tracks NLB active flows divided by the running tasks reported by Container Insights.
+func activeFlowsScalingPolicy(
+	construct constructs.Construct,
+	name string,
+	target awsapplicationautoscaling.CfnScalableTarget,
+	loadBalancer awselasticloadbalancingv2.CfnLoadBalancer,
+	cluster awsecs.Cluster,
+	service awsecs.CfnService,
+	config commons.ServiceConfig) awsapplicationautoscaling.CfnScalingPolicy {
+
+	return awsapplicationautoscaling.NewCfnScalingPolicy(
+		construct,
+		pointer.ToString(name),
+		&awsapplicationautoscaling.CfnScalingPolicyProps{
+			PolicyName:      pointer.ToString(name),
+			PolicyType:      pointer.ToString("TargetTrackingScaling"),
+			ScalingTargetId: target.Ref(),
+			TargetTrackingScalingPolicyConfiguration: &awsapplicationautoscaling.CfnScalingPolicy_TargetTrackingScalingPolicyConfigurationProperty{
+				TargetValue:      pointer.ToFloat64(config.TargetActiveFlows),
+				ScaleInCooldown:  pointer.ToFloat64(300),
+				ScaleOutCooldown: pointer.ToFloat64(60),
+				CustomizedMetricSpecification: &awsapplicationautoscaling.CfnScalingPolicy_CustomizedMetricSpecificationProperty{
+					Metrics: &[]*awsapplicationautoscaling.CfnScalingPolicy_TargetTrackingMetricDataQueryProperty{
+						{
+							Id: pointer.ToString("flows"),
+							MetricStat: &awsapplicationautoscaling.CfnScalingPolicy_TargetTrackingMetricStatProperty{
+								Metric: &awsapplicationautoscaling.CfnScalingPolicy_TargetTrackingMetricProperty{
+									Namespace:  pointer.ToString("AWS/NetworkELB"),
+									MetricName: pointer.ToString("ActiveFlowCount"),
+									Dimensions: &[]*awsapplicationautoscaling.CfnScalingPolicy_TargetTrackingMetricDimensionProperty{
+										{
+											Name:  pointer.ToString("LoadBalancer"),
+											Value: loadBalancer.AttrLoadBalancerFullName(),
+										},
+									},
+								},
+								Stat: pointer.ToString("Average"),
+							},
+							ReturnData: pointer.ToBool(false),
+						},
+						{
+							Id: pointer.ToString("tasks"),
+							MetricStat: &awsapplicationautoscaling.CfnScalingPolicy_TargetTrackingMetricStatProperty{
+								Metric: &awsapplicationautoscaling.CfnScalingPolicy_TargetTrackingMetricProperty{
+									Namespace:  pointer.ToString("ECS/ContainerInsights"),
+									MetricName: pointer.ToString("RunningTaskCount"),
+									Dimensions: &[]*awsapplicationautoscaling.CfnScalingPolicy_TargetTrackingMetricDimensionProperty{
+										{
+											Name:  pointer.ToString("ClusterName"),
+											Value: cluster.ClusterName(),
+										},
+										{
+											Name:  pointer.ToString("ServiceName"),
+											Value: service.AttrName(),
+										},
+									},
+								},
+								Stat: pointer.ToString("Average"),
+							},
+							ReturnData: pointer.ToBool(false),
+						},
+						{
+							Id:         pointer.ToString("flowsPerTask"),
+							Expression: pointer.ToString("flows / tasks"),
+							Label:      pointer.ToString("Active flows per task"),
+							ReturnData: pointer.ToBool(true),
+						},
+					},
+				},
+			},
+		},
+	)
+}
+
+func requestsScalingPolicy(
+	construct constructs.Construct,
+	name string,
+	target awsapplicationautoscaling.CfnScalableTarget,
+	loadBalancer awselasticloadbalancingv2.CfnLoadBalancer,
+	targetGroup awselasticloadbalancingv2.CfnTargetGroup,
+	config commons.ServiceConfig) awsapplicationautoscaling.CfnScalingPolicy {
+
+	return awsapplicationautoscaling.NewCfnScalingPolicy(
+		construct,
+		pointer.ToString(name),
+		&awsapplicationautoscaling.CfnScalingPolicyProps{
+			PolicyName:      pointer.ToString(name),
+			PolicyType:      pointer.ToString("TargetTrackingScaling"),
+			ScalingTargetId: target.Ref(),
+			TargetTrackingScalingPolicyConfiguration: &awsapplicationautoscaling.CfnScalingPolicy_TargetTrackingScalingPolicyConfigurationProperty{
+				TargetValue:      pointer.ToFloat64(config.TargetRequestsPerTask),
+				ScaleInCooldown:  pointer.ToFloat64(300),
+				ScaleOutCooldown: pointer.ToFloat64(60),
+				PredefinedMetricSpecification: &awsapplicationautoscaling.CfnScalingPolicy_PredefinedMetricSpecificationProperty{
+					PredefinedMetricType: pointer.ToString("ALBRequestCountPerTarget"),
+					ResourceLabel: pointer.ToString(fmt.Sprintf(
+						"%s/%s",
+						*loadBalancer.AttrLoadBalancerFullName(),
+						*targetGroup.AttrTargetGroupFullName(),
+					)),
+				},
+			},
+		},
+	)
+}
@@ -1,12 +1,16 @@
 package stacks
 
 import (
+	"fmt"
+
 	"github.com/AlekSi/pointer"
 	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
 	"github.com/aws/aws-cdk-go/awscdk/v2"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsbatch"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awskms"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awslogs"
 	"github.com/aws/constructs-go/constructs/v10"
 	"github.com/aws/jsii-runtime-go"
 	"go.uber.org/fx"
@@ -17,6 +21,7 @@ type BatchStackInput struct {
 	Account commons.Account
 	VPC     awsec2.Vpc       `name:"metaflow_vpc"`
 	SubnetC awsec2.CfnSubnet `name:"metaflow_subnet_c"`
+	LogsKey awskms.Key       `name:"logs_key"`
 }
 
 type BatchStackOutput struct {
@@ -30,7 +35,7 @@ type BatchStackOutput struct {
 func BuildBatchStack(in BatchStackInput) BatchStackOutput {
 	stack := awscdk.NewStack(
 		in.Account.App,
-		pointer.ToString("BatchStack"),
+		pointer.ToString(commons.BatchStackName),
 		&awscdk.StackProps{
 			Env: in.Account.Env(),
 		},
@@ -40,6 +45,18 @@ func BuildBatchStack(in BatchStackInput) BatchStackOutput {
 	computeEnv := buildComputeEnvironment(stack, in, batchRole, instanceProfile)
 	jobQueue := buildJobQueue(stack, computeEnv)
 
+	// The CloudWatch agent of the Batch hosts writes the per-job GPU records here.
+	awslogs.NewLogGroup(
+		stack,
+		pointer.ToString("GPUJobsLogGroup"),
+		&awslogs.LogGroupProps{
+			LogGroupName:  pointer.ToString(commons.GPUJobsLogGroup),
+			Retention:     awslogs.RetentionDays_ONE_MONTH,
+			RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
+			EncryptionKey: in.LogsKey,
+		},
+	)
+
 	out := BatchStackOutput{
 		Stack:      stack,
 		BatchRole:  batchRole,
@@ -71,6 +88,7 @@ func buildInstanceProfile(construct constructs.Construct) awsiam.CfnInstanceProf
 			ManagedPolicyArns: &[]any{
 				pointer.ToString("arn:aws:iam::aws:policy/service-role/AmazonEC2ContainerServiceforEC2Role"),
 				pointer.ToString("arn:aws:iam::aws:policy/AmazonS3FullAccess"),
+				pointer.ToString("arn:aws:iam::aws:policy/CloudWatchAgentServerPolicy"),
 			},
 		},
 	)
@@ -142,6 +160,7 @@ func buildComputeEnvironment(construct constructs.Construct, input BatchStackInp
 	userData.AddCommands(
 		jsii.String(`echo "ec2-user:YourStrongPasswordHere" | chpasswd`),
 	)
+	userData.AddCommands(jsii.String(gpuMetricsScript()))
 
 	multipartUserData := awsec2.NewMultipartUserData(nil)
 	multipartUserData.AddUserDataPart(
@@ -172,7 +191,7 @@ func buildComputeEnvironment(construct constructs.Construct, input BatchStackInp
 			ServiceRole: batchRole.RoleArn(),
 			ComputeResources: &awsbatch.CfnComputeEnvironment_ComputeResourcesProperty{
 				Type:     pointer.ToString("EC2"),
-				MaxvCpus: pointer.ToFloat64(32),
+				MaxvCpus: pointer.ToFloat64(commons.BatchMaxvCpus),
 				SecurityGroupIds: &[]*string{
 					securityGroup.SecurityGroupId(),
 				},
@@ -182,7 +201,7 @@ func buildComputeEnvironment(construct constructs.Construct, input BatchStackInp
 				},
 				InstanceRole: instanceProfile.Ref(),
 				InstanceTypes: &[]*string{
-					pointer.ToString("g6e.2xlarge"),
+					pointer.ToString(commons.BatchGPUInstanceType),
 				},
 				DesiredvCpus:       pointer.ToFloat64(0),
 				MinvCpus:           pointer.ToFloat64(0),
@@ -231,7 +250,7 @@ func buildBatchExecutionRole(construct constructs.Construct) awsiam.Role {
 				awsiam.NewServicePrincipal(pointer.ToString("ecs-tasks.amazonaws.com"), nil),
 				awsiam.NewServicePrincipal(pointer.ToString("batch.amazonaws.com"), nil),
 			),
-			RoleName: pointer.ToString("BatchExecutionRole"),
+			RoleName: pointer.ToString(commons.BatchExecutionRoleName),
 		},
 	)
 	role.AddToPolicy(
@@ -410,3 +429,70 @@ func buildBatchExecutionRole(construct constructs.Construct) awsiam.Role {
 
 	return role
 }
+
+// gpuMetricsScript runs the CloudWatch agent with the NVIDIA GPU metrics of the instance, rolled up by instance
+// type. Every minute a timer sends the utilization of the GPUs assigned to each Batch job on the instance to the
+// agent as an embedded metric format record with a JobId dimension, the records land in GPUJobsLogGroup.
+func gpuMetricsScript() string {
+	return fmt.Sprintf(`yum install -y amazon-cloudwatch-agent
+mkdir -p /opt/metaflow
+cat > /opt/aws/amazon-cloudwatch-agent/etc/metaflow-gpu.json <<'CONFIG'
+{
+  "agent": {"metrics_collection_interval": 60},
+  "metrics": {
+    "namespace": "%[1]s",
+    "append_dimensions": {"InstanceId": "${aws:InstanceId}", "InstanceType": "${aws:InstanceType}"},
+    "aggregation_dimensions": [["InstanceType"]],
+    "metrics_collected": {
+      "nvidia_gpu": {
+        "measurement": ["utilization_gpu", "utilization_memory", "memory_used", "memory_total", "temperature_gpu", "power_draw"]
+      }
+    }
+  },
+  "logs": {
+    "metrics_collected": {"emf": {}}
+  }
+}
+CONFIG
+/opt/aws/amazon-cloudwatch-agent/bin/amazon-cloudwatch-agent-ctl -a fetch-config -m ec2 -c file:/opt/aws/amazon-cloudwatch-agent/etc/metaflow-gpu.json -s
+cat > /opt/metaflow/gpu-jobs.sh <<'SCRIPT'
+#!/bin/bash
+token=$(curl -s -X PUT http://169.254.169.254/latest/api/token -H 'X-aws-ec2-metadata-token-ttl-seconds: 60')
+instance=$(curl -s -H "X-aws-ec2-metadata-token: $token" http://169.254.169.254/latest/meta-data/instance-id)
+gpus=$(nvidia-smi --query-gpu=index,uuid,utilization.gpu,utilization.memory --format=csv,noheader,nounits)
+for container in $(docker ps -q 2>/dev/null); do
+  env=$(docker inspect --format '{{range .Config.Env}}{{println .}}{{end}}' $container)
+  job=$(echo "$env" | sed -n 's/^AWS_BATCH_JOB_ID=//p')
+  devices=$(echo "$env" | sed -n 's/^NVIDIA_VISIBLE_DEVICES=//p')
+  if [ -z "$job" ] || [ -z "$devices" ]; then
+    continue
+  fi
+  # NVIDIA_VISIBLE_DEVICES lists the GPUs of the job by index or UUID.
+  record=$(echo "$gpus" | awk -F', ' -v devices="$devices" -v job="$job" -v instance="$instance" -v timestamp="$(date +%%s%%3N)" '
+    BEGIN { n = split(devices, visible, ","); for (i = 1; i <= n; i++) assigned[visible[i]] = 1 }
+    devices == "all" || ($1 in assigned) || ($2 in assigned) { count++; gpu += $3; memory += $4 }
+    END {
+      if (count == 0) exit
+      print "{\"_aws\": {\"Timestamp\": " timestamp ", \"LogGroupName\": \"%[2]s\", \"CloudWatchMetrics\": [{\"Namespace\": \"%[1]s\", \"Dimensions\": [[\"JobId\"]], \"Metrics\": [{\"Name\": \"nvidia_smi_utilization_gpu\", \"Unit\": \"Percent\"}, {\"Name\": \"nvidia_smi_utilization_memory\", \"Unit\": \"Percent\"}]}]}, \"JobId\": \"" job "\", \"InstanceId\": \"" instance "\", \"GPUs\": " count ", \"nvidia_smi_utilization_gpu\": " gpu / count ", \"nvidia_smi_utilization_memory\": " memory / count "}"
+    }')
+  if [ -n "$record" ]; then
+    echo "$record" > /dev/tcp/127.0.0.1/25888
+  fi
+done
+SCRIPT
+chmod +x /opt/metaflow/gpu-jobs.sh
+cat > /etc/systemd/system/metaflow-gpu-jobs.service <<'UNIT'
+[Service]
+Type=oneshot
+ExecStart=/opt/metaflow/gpu-jobs.sh
+UNIT
+cat > /etc/systemd/system/metaflow-gpu-jobs.timer <<'UNIT'
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec=1min
+[Install]
+WantedBy=timers.target
+UNIT
+systemctl daemon-reload
+systemctl enable --now metaflow-gpu-jobs.timer`, commons.GPUMetricsNamespace, commons.GPUJobsLogGroup)
+}
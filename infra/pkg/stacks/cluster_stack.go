@@ -4,8 +4,10 @@ import (
 	"github.com/AlekSi/pointer"
 	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
 	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsecr"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsecs"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awskms"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awss3"
 	"go.uber.org/fx"
 )
@@ -13,19 +15,22 @@ import (
 type ClusterStackInput struct {
 	fx.In
 	Account commons.Account
+	Config  commons.Config
 	Bucket  awss3.Bucket `name:"s3_bucket"`
+	DataKey awskms.Key   `name:"data_key"`
 }
 
 type ClusterStackOutput struct {
 	fx.Out
 	Cluster     awsecs.Cluster `name:"ecs_cluster"`
 	ECSTaskRole awsiam.Role    `name:"ecs_task_role"`
+	UITaskRole  awsiam.Role    `name:"ui_task_role"`
 }
 
 func BuildClusterStack(input ClusterStackInput) ClusterStackOutput {
 	stack := awscdk.NewStack(
 		input.Account.App,
-		pointer.ToString("ClusterStack"),
+		pointer.ToString(commons.ClusterStackName),
 		&awscdk.StackProps{
 			Env: input.Account.Env(),
 		},
@@ -33,10 +38,15 @@ func BuildClusterStack(input ClusterStackInput) ClusterStackOutput {
 
 	cluster := ecsCluster(stack)
 	ecsRole := buildMetadataSvcECSTaskRole(stack, input)
+	uiRole := buildUISvcECSTaskRole(stack, input)
+	if input.Config.Images.PullThroughCache {
+		pullThroughCacheRules(stack, input.Config.Images)
+	}
 
 	return ClusterStackOutput{
 		Cluster:     cluster,
 		ECSTaskRole: ecsRole,
+		UITaskRole:  uiRole,
 	}
 }
 
@@ -50,11 +60,35 @@ func buildMetadataSvcECSTaskRole(stack awscdk.Stack, in ClusterStackInput) awsia
 	)
 
 	role.ApplyRemovalPolicy(awscdk.RemovalPolicy_DESTROY)
+	grantDatastoreRead(role, "MetadataService", in)
+
+	return role
+}
+
+// buildUISvcECSTaskRole is the role of the UI tasks, kept apart from the metadata service role so that each
+// can only connect to the RDS Proxy as its own DB user.
+func buildUISvcECSTaskRole(stack awscdk.Stack, in ClusterStackInput) awsiam.Role {
+	role := awsiam.NewRole(
+		stack, pointer.ToString("UISvcECSTaskRole"),
+		&awsiam.RoleProps{
+			AssumedBy: awsiam.NewServicePrincipal(pointer.ToString("ecs-tasks.amazonaws.com"), nil),
+		},
+	)
+
+	role.ApplyRemovalPolicy(awscdk.RemovalPolicy_DESTROY)
+	grantDatastoreRead(role, "UIService", in)
+
+	return role
+}
+
+// grantDatastoreRead lets a service role read the datastore objects, naming the statements after sid.
+func grantDatastoreRead(role awsiam.Role, sid string, in ClusterStackInput) {
+	in.DataKey.GrantDecrypt(role)
 
 	role.AddToPolicy(
 		awsiam.NewPolicyStatement(
 			&awsiam.PolicyStatementProps{
-				Sid:    pointer.ToString("ObjectAccessMetadataService"),
+				Sid:    pointer.ToString("ObjectAccess" + sid),
 				Effect: awsiam.Effect_ALLOW,
 				Actions: &[]*string{
 					pointer.ToString("s3:GetObject"),
@@ -69,7 +103,7 @@ func buildMetadataSvcECSTaskRole(stack awscdk.Stack, in ClusterStackInput) awsia
 	role.AddToPolicy(
 		awsiam.NewPolicyStatement(
 			&awsiam.PolicyStatementProps{
-				Sid:    pointer.ToString("ObjectAccessMetadataServiceNonExistentKeys"),
+				Sid:    pointer.ToString("ObjectAccess" + sid + "NonExistentKeys"),
 				Effect: awsiam.Effect_ALLOW,
 				Actions: &[]*string{
 					pointer.ToString("s3:ListBucket"),
@@ -80,8 +114,6 @@ func buildMetadataSvcECSTaskRole(stack awscdk.Stack, in ClusterStackInput) awsia
 			},
 		),
 	)
-
-	return role
 }
 
 func ecsCluster(stack awscdk.Stack) awsecs.Cluster {
@@ -94,3 +126,28 @@ func ecsCluster(stack awscdk.Stack) awsecs.Cluster {
 		},
 	)
 }
+
+func pullThroughCacheRules(stack awscdk.Stack, images commons.ImagesConfig) {
+	awsecr.NewCfnPullThroughCacheRule(
+		stack,
+		pointer.ToString("ECRPublicPullThroughCache"),
+		&awsecr.CfnPullThroughCacheRuleProps{
+			EcrRepositoryPrefix: pointer.ToString(commons.ECRPublicCachePrefix),
+			UpstreamRegistry:    pointer.ToString("ecr-public"),
+			UpstreamRegistryUrl: pointer.ToString(commons.MetaflowStaticUIRegistry),
+		},
+	)
+
+	if images.DockerHubCredentialArn != "" {
+		awsecr.NewCfnPullThroughCacheRule(
+			stack,
+			pointer.ToString("DockerHubPullThroughCache"),
+			&awsecr.CfnPullThroughCacheRuleProps{
+				EcrRepositoryPrefix: pointer.ToString(commons.DockerHubCachePrefix),
+				UpstreamRegistry:    pointer.ToString("docker-hub"),
+				UpstreamRegistryUrl: pointer.ToString("registry-1.docker.io"),
+				CredentialArn:       pointer.ToString(images.DockerHubCredentialArn),
+			},
+		)
+	}
+}
@@ -0,0 +1,320 @@
+package stacks
+
+import (
+	"github.com/AlekSi/pointer"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsecs"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awslambda"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awslogs"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsrds"
+	"github.com/aws/aws-cdk-go/awscdk/v2/customresources"
+	"github.com/aws/constructs-go/constructs/v10"
+)
+
+// dbUsersScript creates the per-service users with the master credentials. The metadata user takes over
+// the schema so it can keep running migrations. The UI backend creates notify functions and replaces the
+// triggers on the tables every time it starts, and Postgres only lets the table owner drop a trigger, so
+// the UI user is a member of the metadata user.
+const dbUsersScript = `
+import os, psycopg2
+from psycopg2 import sql
+
+conn = psycopg2.connect(
+	host=os.environ['MF_METADATA_DB_HOST'],
+	dbname=os.environ['MF_METADATA_DB_NAME'],
+	user=os.environ['MASTER_DB_USER'],
+	password=os.environ['MASTER_DB_PSWD'],
+	sslmode='prefer',
+)
+conn.autocommit = True
+cur = conn.cursor()
+
+metadata = sql.Identifier(os.environ['METADATA_DB_USER'])
+ui = sql.Identifier(os.environ['UI_DB_USER'])
+database = sql.Identifier(os.environ['MF_METADATA_DB_NAME'])
+
+for user, password in ((os.environ['METADATA_DB_USER'], os.environ['METADATA_DB_PSWD']), (os.environ['UI_DB_USER'], os.environ['UI_DB_PSWD'])):
+	cur.execute("SELECT 1 FROM pg_roles WHERE rolname = %s", (user,))
+	verb = "ALTER" if cur.fetchone() else "CREATE"
+	cur.execute(sql.SQL(verb + " ROLE {} WITH LOGIN PASSWORD %s").format(sql.Identifier(user)), (password,))
+
+cur.execute(sql.SQL("GRANT {} TO CURRENT_USER").format(metadata))
+cur.execute(sql.SQL("GRANT CONNECT ON DATABASE {} TO {}, {}").format(database, metadata, ui))
+cur.execute(sql.SQL("GRANT USAGE, CREATE ON SCHEMA public TO {}").format(metadata))
+
+cur.execute("SELECT tablename FROM pg_tables WHERE schemaname = 'public' AND tableowner = CURRENT_USER")
+for (table,) in cur.fetchall():
+	cur.execute(sql.SQL("ALTER TABLE public.{} OWNER TO {}").format(sql.Identifier(table), metadata))
+
+cur.execute(sql.SQL("GRANT {} TO {}").format(metadata, ui))
+conn.close()
+`
+
+// dbAuthTokenScript keeps an IAM auth token for the proxy in PGPASSFILE, writing a new one every 10 minutes
+// as they expire after 15. A failed attempt is retried after a few seconds.
+const dbAuthTokenScript = `
+umask 077
+while true; do
+	if token=$(aws rds generate-db-auth-token --hostname "$DB_HOST" --port "$DB_PORT" --username "$DB_USER"); then
+		token=$(printf '%s' "$token" | sed 's/[\\:]/\\&/g')
+		printf '%s:%s:*:%s:%s\n' "$DB_HOST" "$DB_PORT" "$DB_USER" "$token" > "$PGPASSFILE.tmp"
+		mv "$PGPASSFILE.tmp" "$PGPASSFILE"
+		sleep 600
+	else
+		sleep 5
+	fi
+done
+`
+
+// proxyIAMAuth lets the task role connect to the proxy as user. A sidecar running the AWS CLI image keeps an IAM
+// auth token for user in a pgpass file on a volume shared with container, which starts once the first token is
+// written. The services take an empty password, so libpq falls back to the file.
+func proxyIAMAuth(
+	construct constructs.Construct,
+	id string,
+	task awsecs.TaskDefinition,
+	container awsecs.ContainerDefinition,
+	proxy awsrds.CfnDBProxy,
+	user string,
+	image string,
+	logGroup awslogs.LogGroup) {
+
+	proxyID := awscdk.Fn_Select(pointer.ToFloat64(6), awscdk.Fn_Split(pointer.ToString(":"), proxy.AttrDbProxyArn(), nil))
+	awsiam.NewPolicy(
+		construct,
+		pointer.ToString(id),
+		&awsiam.PolicyProps{
+			Roles: &[]awsiam.IRole{task.TaskRole()},
+			Statements: &[]awsiam.PolicyStatement{
+				awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+					Actions: &[]*string{pointer.ToString("rds-db:connect")},
+					Resources: &[]*string{awscdk.Fn_Sub(
+						pointer.ToString("arn:${AWS::Partition}:rds-db:${AWS::Region}:${AWS::AccountId}:dbuser:${ProxyId}/"+user),
+						&map[string]*string{"ProxyId": proxyID},
+					)},
+				}),
+			},
+		},
+	)
+
+	passfile := "/db-auth/.pgpass"
+	task.AddVolume(&awsecs.Volume{Name: pointer.ToString("db-auth")})
+	sidecar := task.AddContainer(
+		pointer.ToString("DB auth token container"),
+		&awsecs.ContainerDefinitionOptions{
+			ContainerName: pointer.ToString("db-auth-token"),
+			Image:         awsecs.AssetImage_FromRegistry(pointer.ToString(image), nil),
+			EntryPoint:    &[]*string{pointer.ToString("/bin/sh"), pointer.ToString("-c")},
+			Command:       &[]*string{pointer.ToString(dbAuthTokenScript)},
+			Environment: &map[string]*string{
+				"DB_HOST":    proxy.AttrEndpoint(),
+				"DB_PORT":    pointer.ToString("5432"),
+				"DB_USER":    pointer.ToString(user),
+				"PGPASSFILE": pointer.ToString(passfile),
+				"AWS_REGION": awscdk.Aws_REGION(),
+			},
+			HealthCheck: &awsecs.HealthCheck{
+				Command:     &[]*string{pointer.ToString("CMD-SHELL"), pointer.ToString("test -s " + passfile)},
+				Interval:    awscdk.Duration_Seconds(pointer.ToFloat64(5)),
+				StartPeriod: awscdk.Duration_Seconds(pointer.ToFloat64(10)),
+			},
+			Logging: awsecs.LogDriver_AwsLogs(
+				&awsecs.AwsLogDriverProps{
+					StreamPrefix: pointer.ToString("db-auth"),
+					LogGroup:     logGroup,
+				},
+			),
+		},
+	)
+	sidecar.AddMountPoints(&awsecs.MountPoint{
+		SourceVolume:  pointer.ToString("db-auth"),
+		ContainerPath: pointer.ToString("/db-auth"),
+		ReadOnly:      pointer.ToBool(false),
+	})
+
+	container.AddMountPoints(&awsecs.MountPoint{
+		SourceVolume:  pointer.ToString("db-auth"),
+		ContainerPath: pointer.ToString("/db-auth"),
+		ReadOnly:      pointer.ToBool(true),
+	})
+	container.AddContainerDependencies(&awsecs.ContainerDependency{
+		Container: sidecar,
+		Condition: awsecs.ContainerDependencyCondition_HEALTHY,
+	})
+	container.AddEnvironment(pointer.ToString("MF_METADATA_DB_USER"), pointer.ToString(user))
+	container.AddEnvironment(pointer.ToString("MF_METADATA_DB_PSWD"), pointer.ToString(""))
+	container.AddEnvironment(pointer.ToString("PGPASSFILE"), pointer.ToString(passfile))
+}
+
+// dbUsers runs dbUsersScript in a one-off Fargate task with the metadata image, which already ships psycopg2.
+// The provider waits for the task to stop and fails the deploy if the script did not exit cleanly.
+func dbUsers(stack awscdk.Stack, input MetaflowMetadataTaskDefinitionInput) awscdk.CustomResource {
+	executionRole := commons.CreateECSExecutionRole(stack, "ECS DB Users Role")
+	task := awsecs.NewTaskDefinition(
+		stack,
+		pointer.ToString("Definition of DB users task"),
+		&awsecs.TaskDefinitionProps{
+			Family:        pointer.ToString("metaflow-db-users"),
+			Cpu:           pointer.ToString("256"),
+			MemoryMiB:     pointer.ToString("512"),
+			NetworkMode:   awsecs.NetworkMode_AWS_VPC,
+			Compatibility: awsecs.Compatibility_FARGATE,
+			ExecutionRole: executionRole,
+		},
+	)
+
+	logGroup := awslogs.NewLogGroup(
+		stack,
+		pointer.ToString("DBUsersLogGroup"),
+		&awslogs.LogGroupProps{
+			LogGroupName:  pointer.ToString("ecs/metaflow-db-users"),
+			Retention:     awslogs.RetentionDays_ONE_MONTH,
+			RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
+			EncryptionKey: input.LogsKey,
+		},
+	)
+	logGroup.GrantWrite(executionRole)
+
+	task.AddContainer(
+		pointer.ToString("DB users container"),
+		&awsecs.ContainerDefinitionOptions{
+			ContainerName: pointer.ToString("db-users"),
+			Image: awsecs.AssetImage_FromRegistry(
+				pointer.ToString(input.Config.Images.MetadataImage(input.Account)),
+				nil,
+			),
+			EntryPoint: &[]*string{pointer.ToString("/opt/latest/bin/python3"), pointer.ToString("-c")},
+			Command:    &[]*string{pointer.ToString(dbUsersScript)},
+			Environment: &map[string]*string{
+				"MF_METADATA_DB_HOST": input.DB.AttrEndpointAddress(),
+				"MF_METADATA_DB_NAME": pointer.ToString(commons.MetaflowDBName),
+				"METADATA_DB_USER":    pointer.ToString(commons.MetadataDBUser),
+				"UI_DB_USER":          pointer.ToString(commons.UIDBUser),
+			},
+			Secrets: &map[string]awsecs.Secret{
+				"MASTER_DB_USER":   awsecs.Secret_FromSecretsManager(input.Credentials, pointer.ToString("username")),
+				"MASTER_DB_PSWD":   awsecs.Secret_FromSecretsManager(input.Credentials, pointer.ToString("password")),
+				"METADATA_DB_PSWD": awsecs.Secret_FromSecretsManager(input.MetadataDBCredentials, pointer.ToString("password")),
+				"UI_DB_PSWD":       awsecs.Secret_FromSecretsManager(input.UIDBCredentials, pointer.ToString("password")),
+			},
+			Logging: awsecs.LogDriver_AwsLogs(
+				&awsecs.AwsLogDriverProps{
+					StreamPrefix: pointer.ToString("ecs"),
+					LogGroup:     logGroup,
+				},
+			),
+		},
+	)
+	input.Credentials.GrantRead(executionRole, nil)
+	input.MetadataDBCredentials.GrantRead(executionRole, nil)
+	input.UIDBCredentials.GrantRead(executionRole, nil)
+	input.DataKey.GrantDecrypt(executionRole)
+
+	onEvent := awslambda.NewFunction(
+		stack,
+		pointer.ToString("DBUsersOnEvent"),
+		&awslambda.FunctionProps{
+			Runtime: awslambda.Runtime_PYTHON_3_12(),
+			Handler: pointer.ToString("index.handler"),
+			Timeout: awscdk.Duration_Minutes(pointer.ToFloat64(1)),
+			Code: awslambda.Code_FromInline(pointer.ToString(`
+import os, boto3
+
+def handler(event, context):
+	if event['RequestType'] == 'Delete':
+		return {'PhysicalResourceId': event['PhysicalResourceId']}
+
+	response = boto3.client('ecs').run_task(
+		cluster=os.environ['CLUSTER'],
+		taskDefinition=os.environ['TASK_DEFINITION'],
+		launchType='FARGATE',
+		networkConfiguration={'awsvpcConfiguration': {
+			'subnets': os.environ['SUBNETS'].split(','),
+			'securityGroups': [os.environ['SECURITY_GROUP']],
+			'assignPublicIp': 'ENABLED',
+		}},
+	)
+	if response['failures']:
+		raise Exception(response['failures'])
+
+	return {'PhysicalResourceId': 'metaflow-db-users', 'Data': {'TaskArn': response['tasks'][0]['taskArn']}}
+`)),
+			Environment: &map[string]*string{
+				"CLUSTER":         input.ECSCluster.ClusterArn(),
+				"TASK_DEFINITION": task.TaskDefinitionArn(),
+				"SUBNETS":         awscdk.Fn_Join(pointer.ToString(","), &[]*string{input.SubnetA.Ref(), input.SubnetB.Ref()}),
+				"SECURITY_GROUP":  input.FargateSecurityGroup.SecurityGroupId(),
+			},
+		},
+	)
+	onEvent.AddToRolePolicy(awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+		Actions:   &[]*string{pointer.ToString("ecs:RunTask")},
+		Resources: &[]*string{task.TaskDefinitionArn()},
+	}))
+	onEvent.AddToRolePolicy(awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+		Actions:   &[]*string{pointer.ToString("iam:PassRole")},
+		Resources: &[]*string{executionRole.RoleArn()},
+	}))
+
+	isComplete := awslambda.NewFunction(
+		stack,
+		pointer.ToString("DBUsersIsComplete"),
+		&awslambda.FunctionProps{
+			Runtime: awslambda.Runtime_PYTHON_3_12(),
+			Handler: pointer.ToString("index.handler"),
+			Timeout: awscdk.Duration_Minutes(pointer.ToFloat64(1)),
+			Code: awslambda.Code_FromInline(pointer.ToString(`
+import os, boto3
+
+def handler(event, context):
+	if event['RequestType'] == 'Delete':
+		return {'IsComplete': True}
+
+	task = boto3.client('ecs').describe_tasks(
+		cluster=os.environ['CLUSTER'],
+		tasks=[event['Data']['TaskArn']],
+	)['tasks'][0]
+	if task['lastStatus'] != 'STOPPED':
+		return {'IsComplete': False}
+
+	container = task['containers'][0]
+	if container.get('exitCode') != 0:
+		raise Exception('DB users task failed: {} {}'.format(task.get('stoppedReason'), container.get('reason', '')))
+
+	return {'IsComplete': True}
+`)),
+			Environment: &map[string]*string{
+				"CLUSTER": input.ECSCluster.ClusterArn(),
+			},
+		},
+	)
+	isComplete.AddToRolePolicy(awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+		Actions:   &[]*string{pointer.ToString("ecs:DescribeTasks")},
+		Resources: &[]*string{pointer.ToString("*")},
+	}))
+
+	provider := customresources.NewProvider(
+		stack,
+		pointer.ToString("DBUsersProvider"),
+		&customresources.ProviderProps{
+			OnEventHandler:    onEvent,
+			IsCompleteHandler: isComplete,
+			QueryInterval:     awscdk.Duration_Seconds(pointer.ToFloat64(15)),
+			TotalTimeout:      awscdk.Duration_Minutes(pointer.ToFloat64(15)),
+		},
+	)
+
+	return awscdk.NewCustomResource(
+		stack,
+		pointer.ToString("MetaflowDBUsers"),
+		&awscdk.CustomResourceProps{
+			ServiceToken: provider.ServiceToken(),
+			ResourceType: pointer.ToString("Custom::MetaflowDBUsers"),
+			Properties: &map[string]interface{}{
+				"TaskDefinition": task.TaskDefinitionArn(),
+			},
+		},
+	)
+}
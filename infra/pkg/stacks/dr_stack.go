@@ -0,0 +1,96 @@
+package stacks
+
+import (
+	"fmt"
+
+	"github.com/AlekSi/pointer"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsbackup"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awskms"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awss3"
+	"go.uber.org/fx"
+)
+
+type DRStackInput struct {
+	fx.In
+	Account commons.Account
+	Config  commons.Config
+}
+
+type DRStackOutput struct {
+	fx.Out
+	Stack         awscdk.Stack          `group:"stacks"`
+	DRStack       awscdk.Stack          `name:"dr_stack"`
+	DRKey         awskms.Key            `name:"dr_key"`
+	ReplicaBucket awss3.Bucket          `name:"dr_replica_bucket"`
+	BackupVault   awsbackup.BackupVault `name:"dr_backup_vault"`
+}
+
+// BuildDRStack holds the copies kept in the secondary region: the datastore replica, the DynamoDB replica key
+// and the vault receiving the RDS snapshot copies. It is only provided when disasterRecovery is enabled.
+func BuildDRStack(in DRStackInput) DRStackOutput {
+	config := in.Config.DisasterRecovery
+	stack := awscdk.NewStack(
+		in.Account.App,
+		pointer.ToString(commons.DRStackName),
+		&awscdk.StackProps{
+			Env: &awscdk.Environment{
+				Account: pointer.ToString(in.Account.AccountId),
+				Region:  pointer.ToString(config.SecondaryRegion),
+			},
+			CrossRegionReferences: pointer.ToBool(true),
+		},
+	)
+
+	key := awskms.NewKey(
+		stack,
+		pointer.ToString("MetaflowDRKey"),
+		&awskms.KeyProps{
+			Alias:             pointer.ToString("alias/metaflow/dr"),
+			Description:       pointer.ToString("Metaflow disaster recovery copies"),
+			EnableKeyRotation: pointer.ToBool(true),
+			RemovalPolicy:     awscdk.RemovalPolicy_RETAIN,
+		},
+	)
+
+	removalPolicy := awscdk.RemovalPolicy_DESTROY
+	if in.Config.Datastore.Retained() {
+		removalPolicy = awscdk.RemovalPolicy_RETAIN
+	}
+
+	replica := awss3.NewBucket(
+		stack,
+		pointer.ToString("MetaflowReplicaBucket"),
+		&awss3.BucketProps{
+			BucketName:        pointer.ToString(fmt.Sprintf("metaflow-datastore-replica-%s-%s", in.Account.AccountId, config.SecondaryRegion)),
+			AccessControl:     awss3.BucketAccessControl_PRIVATE,
+			Encryption:        awss3.BucketEncryption_KMS,
+			EncryptionKey:     key,
+			BucketKeyEnabled:  pointer.ToBool(true),
+			BlockPublicAccess: awss3.BlockPublicAccess_BLOCK_ALL(),
+			Versioned:         pointer.ToBool(true),
+			LifecycleRules:    bucketLifecycleRules(in.Config.Datastore),
+			AutoDeleteObjects: pointer.ToBool(!in.Config.Datastore.Retained()),
+			RemovalPolicy:     removalPolicy,
+		},
+	)
+
+	vault := awsbackup.NewBackupVault(
+		stack,
+		pointer.ToString("MetaflowDRBackupVault"),
+		&awsbackup.BackupVaultProps{
+			BackupVaultName: pointer.ToString(commons.DRBackupVaultName),
+			EncryptionKey:   key,
+			RemovalPolicy:   awscdk.RemovalPolicy_RETAIN,
+		},
+	)
+
+	return DRStackOutput{
+		Stack:         stack,
+		DRStack:       stack,
+		DRKey:         key,
+		ReplicaBucket: replica,
+		BackupVault:   vault,
+	}
+}
@@ -0,0 +1,425 @@
+package stacks
+
+import (
+	"fmt"
+
+	"github.com/AlekSi/pointer"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsdynamodb"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseksv2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awskms"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awslambda"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awss3"
+	"go.uber.org/fx"
+)
+
+type EKSStackInput struct {
+	fx.In
+	Account          commons.Account
+	Config           commons.Config
+	VPC              awsec2.Vpc                 `name:"metaflow_vpc"`
+	SubnetA          awsec2.CfnSubnet           `name:"metaflow_subnet_a"`
+	SubnetB          awsec2.CfnSubnet           `name:"metaflow_subnet_b"`
+	SubnetC          awsec2.CfnSubnet           `name:"metaflow_subnet_c"`
+	SubnetD          awsec2.CfnSubnet           `name:"metaflow_subnet_d"`
+	MetaflowBucket   awss3.Bucket               `name:"s3_bucket"`
+	StateDDB         awsdynamodb.CfnGlobalTable `name:"state_ddb"`
+	DataKey          awskms.Key                 `name:"data_key"`
+	MetaflowUserRole awsiam.Role                `name:"metaflow_user_role"`
+}
+
+type EKSStackOutput struct {
+	fx.Out
+	Stack   awscdk.Stack     `group:"stacks"`
+	Cluster awseksv2.Cluster `name:"eks_cluster"`
+}
+
+// BuildEKSStack runs Metaflow @kubernetes steps and Argo Workflows next to Batch, on the same datastore.
+// It is only provided when kubernetes is enabled.
+func BuildEKSStack(in EKSStackInput) EKSStackOutput {
+	config := in.Config.Kubernetes
+	stack := awscdk.NewStack(
+		in.Account.App,
+		pointer.ToString(commons.EKSStackName),
+		&awscdk.StackProps{
+			Env: in.Account.Env(),
+		},
+	)
+
+	subnet := func(name string, subnet awsec2.CfnSubnet) awsec2.ISubnet {
+		return awsec2.Subnet_FromSubnetAttributes(stack, pointer.ToString(name), &awsec2.SubnetAttributes{
+			SubnetId:         subnet.AttrSubnetId(),
+			AvailabilityZone: subnet.AvailabilityZone(),
+		})
+	}
+	subnetC := subnet("ImportedSubnetC", in.SubnetC)
+	subnetD := subnet("ImportedSubnetD", in.SubnetD)
+
+	cluster := awseksv2.NewCluster(
+		stack,
+		pointer.ToString("MetaflowCluster"),
+		&awseksv2.ClusterProps{
+			ClusterName: pointer.ToString(commons.EKSClusterName),
+			Version:     awseksv2.KubernetesVersion_Of(pointer.ToString(config.Version)),
+			Vpc:         in.VPC,
+			VpcSubnets: &[]*awsec2.SubnetSelection{
+				{
+					Subnets: &[]awsec2.ISubnet{
+						subnet("ImportedSubnetA", in.SubnetA),
+						subnet("ImportedSubnetB", in.SubnetB),
+						subnetC,
+						subnetD,
+					},
+				},
+			},
+			EndpointAccess:       awseksv2.EndpointAccess_PUBLIC_AND_PRIVATE(),
+			DefaultCapacityType:  awseksv2.DefaultCapacityType_NODEGROUP,
+			DefaultCapacity:      pointer.ToFloat64(0),
+			SecretsEncryptionKey: awskms.Key_FromKeyArn(stack, pointer.ToString("SecretsKey"), in.DataKey.KeyArn()),
+			ClusterLogging: &[]awseksv2.ClusterLoggingTypes{
+				awseksv2.ClusterLoggingTypes_API,
+				awseksv2.ClusterLoggingTypes_AUDIT,
+				awseksv2.ClusterLoggingTypes_AUTHENTICATOR,
+			},
+			KubectlProviderOptions: &awseksv2.KubectlProviderOptions{
+				KubectlLayer: awslambda.LayerVersion_FromLayerVersionArn(stack, pointer.ToString("KubectlLayer"), pointer.ToString(config.KubectlLayerArn)),
+			},
+			OutputConfigCommand: pointer.ToBool(true),
+		},
+	)
+
+	nodeGroups(cluster, subnetC, subnetD, config)
+	clusterAutoscaler(cluster, in)
+	metaflowServiceAccount := kubernetesJobsAccess(stack, cluster, in)
+	argo(cluster, metaflowServiceAccount)
+
+	return EKSStackOutput{
+		Stack:   stack,
+		Cluster: cluster,
+	}
+}
+
+// nodeGroups adds on demand CPU nodes and GPU nodes scaling from zero, the GPU label matches the
+// node affinity of the NVIDIA device plugin. The CPU nodes run the Argo controllers, so they span both private
+// subnets. The GPU nodes stay in SubnetC, the zone Batch already checks for GPU capacity, a lost zone only stops
+// GPU steps until it comes back.
+func nodeGroups(cluster awseksv2.Cluster, subnetC, subnetD awsec2.ISubnet, config commons.KubernetesConfig) {
+	instanceTypes := func(names []string) *[]awsec2.InstanceType {
+		types := make([]awsec2.InstanceType, 0, len(names))
+		for _, name := range names {
+			types = append(types, awsec2.NewInstanceType(pointer.ToString(name)))
+		}
+		return &types
+	}
+
+	cluster.AddNodegroupCapacity(
+		pointer.ToString("CPUNodes"),
+		&awseksv2.NodegroupOptions{
+			NodegroupName: pointer.ToString("metaflow-cpu"),
+			AmiType:       awseksv2.NodegroupAmiType_AL2023_X86_64_STANDARD,
+			InstanceTypes: instanceTypes(config.CPUInstanceTypes),
+			MinSize:       pointer.ToFloat64(1),
+			DesiredSize:   pointer.ToFloat64(1),
+			MaxSize:       pointer.ToFloat64(float64(config.CPUMaxNodes)),
+			Subnets:       &awsec2.SubnetSelection{Subnets: &[]awsec2.ISubnet{subnetC, subnetD}},
+		},
+	)
+
+	if config.GPUMaxNodes == 0 {
+		return
+	}
+
+	cluster.AddNodegroupCapacity(
+		pointer.ToString("GPUNodes"),
+		&awseksv2.NodegroupOptions{
+			NodegroupName: pointer.ToString("metaflow-gpu"),
+			AmiType:       awseksv2.NodegroupAmiType_AL2023_X86_64_NVIDIA,
+			InstanceTypes: instanceTypes(config.GPUInstanceTypes),
+			MinSize:       pointer.ToFloat64(0),
+			DesiredSize:   pointer.ToFloat64(0),
+			MaxSize:       pointer.ToFloat64(float64(config.GPUMaxNodes)),
+			DiskSize:      pointer.ToFloat64(100),
+			Labels: &map[string]*string{
+				"nvidia.com/gpu.present": pointer.ToString("true"),
+			},
+			Subnets: &awsec2.SubnetSelection{Subnets: &[]awsec2.ISubnet{subnetC}},
+		},
+	)
+
+	cluster.AddHelmChart(
+		pointer.ToString("NvidiaDevicePlugin"),
+		&awseksv2.HelmChartOptions{
+			Chart:      pointer.ToString("nvidia-device-plugin"),
+			Repository: pointer.ToString("https://nvidia.github.io/k8s-device-plugin"),
+			Version:    pointer.ToString(commons.NvidiaDevicePluginChartVersion),
+			Namespace:  pointer.ToString("kube-system"),
+			Release:    pointer.ToString("nvidia-device-plugin"),
+		},
+	)
+}
+
+// clusterAutoscaler discovers the managed node groups through their ASG tags. The priority expander keeps
+// CPU pods off the GPU nodes, GPU pods only fit the GPU group anyway.
+func clusterAutoscaler(cluster awseksv2.Cluster, in EKSStackInput) {
+	serviceAccount := cluster.AddServiceAccount(
+		pointer.ToString("ClusterAutoscalerServiceAccount"),
+		&awseksv2.ServiceAccountOptions{
+			Name:         pointer.ToString("cluster-autoscaler"),
+			Namespace:    pointer.ToString("kube-system"),
+			IdentityType: awseksv2.IdentityType_IRSA,
+		},
+	)
+
+	serviceAccount.AddToPrincipalPolicy(
+		awsiam.NewPolicyStatement(
+			&awsiam.PolicyStatementProps{
+				Sid:    pointer.ToString("DescribeCapacity"),
+				Effect: awsiam.Effect_ALLOW,
+				Actions: &[]*string{
+					pointer.ToString("autoscaling:DescribeAutoScalingGroups"),
+					pointer.ToString("autoscaling:DescribeAutoScalingInstances"),
+					pointer.ToString("autoscaling:DescribeLaunchConfigurations"),
+					pointer.ToString("autoscaling:DescribeScalingActivities"),
+					pointer.ToString("autoscaling:DescribeTags"),
+					pointer.ToString("ec2:DescribeImages"),
+					pointer.ToString("ec2:DescribeInstanceTypes"),
+					pointer.ToString("ec2:DescribeLaunchTemplateVersions"),
+					pointer.ToString("ec2:GetInstanceTypesFromInstanceRequirements"),
+					pointer.ToString("eks:DescribeNodegroup"),
+				},
+				Resources: &[]*string{
+					pointer.ToString("*"),
+				},
+			},
+		),
+	)
+
+	serviceAccount.AddToPrincipalPolicy(
+		awsiam.NewPolicyStatement(
+			&awsiam.PolicyStatementProps{
+				Sid:    pointer.ToString("ScaleOwnedGroups"),
+				Effect: awsiam.Effect_ALLOW,
+				Actions: &[]*string{
+					pointer.ToString("autoscaling:SetDesiredCapacity"),
+					pointer.ToString("autoscaling:TerminateInstanceInAutoScalingGroup"),
+				},
+				Resources: &[]*string{
+					pointer.ToString("*"),
+				},
+				Conditions: &map[string]interface{}{
+					"StringEquals": map[string]*string{
+						"aws:ResourceTag/k8s.io/cluster-autoscaler/" + commons.EKSClusterName: pointer.ToString("owned"),
+					},
+				},
+			},
+		),
+	)
+
+	chart := cluster.AddHelmChart(
+		pointer.ToString("ClusterAutoscaler"),
+		&awseksv2.HelmChartOptions{
+			Chart:      pointer.ToString("cluster-autoscaler"),
+			Repository: pointer.ToString("https://kubernetes.github.io/autoscaler"),
+			Version:    pointer.ToString(commons.ClusterAutoscalerChartVersion),
+			Namespace:  pointer.ToString("kube-system"),
+			Release:    pointer.ToString("cluster-autoscaler"),
+			Values: &map[string]interface{}{
+				"awsRegion": in.Account.Region,
+				"autoDiscovery": map[string]interface{}{
+					"clusterName": cluster.ClusterName(),
+				},
+				"image": map[string]interface{}{
+					"tag": fmt.Sprintf("v%s.0", in.Config.Kubernetes.Version),
+				},
+				"rbac": map[string]interface{}{
+					"serviceAccount": map[string]interface{}{
+						"create": false,
+						"name":   serviceAccount.ServiceAccountName(),
+					},
+				},
+				"extraArgs": map[string]interface{}{
+					"expander":                    "priority",
+					"balance-similar-node-groups": true,
+				},
+				"expanderPriorities": map[string][]string{
+					"10": {".*metaflow-cpu.*"},
+					"1":  {".*metaflow-gpu.*"},
+				},
+			},
+		},
+	)
+	chart.Node().AddDependency(serviceAccount)
+}
+
+// kubernetesJobsAccess creates the Metaflow namespace with the IRSA service account the steps run as, it
+// gets the same datastore and state permissions as BatchS3Role. The Metaflow users may edit the namespace.
+func kubernetesJobsAccess(stack awscdk.Stack, cluster awseksv2.Cluster, in EKSStackInput) awseksv2.ServiceAccount {
+	namespace := cluster.AddManifest(
+		pointer.ToString("MetaflowNamespace"),
+		&map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata": map[string]interface{}{
+				"name": commons.KubernetesNamespace,
+			},
+		},
+	)
+
+	serviceAccount := cluster.AddServiceAccount(
+		pointer.ToString("MetaflowServiceAccount"),
+		&awseksv2.ServiceAccountOptions{
+			Name:         pointer.ToString(commons.KubernetesServiceAccount),
+			Namespace:    pointer.ToString(commons.KubernetesNamespace),
+			IdentityType: awseksv2.IdentityType_IRSA,
+		},
+	)
+	serviceAccount.Node().AddDependency(namespace)
+
+	in.DataKey.GrantEncryptDecrypt(serviceAccount)
+	for _, statement := range jobDataStatements(in.Account, in.MetaflowBucket, in.StateDDB) {
+		serviceAccount.AddToPrincipalPolicy(statement)
+	}
+
+	cluster.GrantAccess(
+		pointer.ToString("MetaflowUserAccess"),
+		in.MetaflowUserRole.RoleArn(),
+		&[]awseksv2.IAccessPolicy{
+			awseksv2.AccessPolicy_FromAccessPolicyName(
+				pointer.ToString("AmazonEKSEditPolicy"),
+				&awseksv2.AccessPolicyNameOptions{
+					AccessScopeType: awseksv2.AccessScopeType_NAMESPACE,
+					Namespaces:      &[]*string{pointer.ToString(commons.KubernetesNamespace)},
+				},
+			),
+		},
+		nil,
+	)
+
+	awsiam.NewPolicy(
+		stack,
+		pointer.ToString("MetaflowUserClusterAccess"),
+		&awsiam.PolicyProps{
+			Roles: &[]awsiam.IRole{in.MetaflowUserRole},
+			Statements: &[]awsiam.PolicyStatement{
+				awsiam.NewPolicyStatement(
+					&awsiam.PolicyStatementProps{
+						Effect: awsiam.Effect_ALLOW,
+						Actions: &[]*string{
+							pointer.ToString("eks:DescribeCluster"),
+						},
+						Resources: &[]*string{
+							cluster.ClusterArn(),
+						},
+					},
+				),
+			},
+		},
+	)
+
+	return serviceAccount
+}
+
+// argo installs Argo Workflows and Argo Events, then the RBAC, event bus and webhook event source
+// Metaflow expects in its namespace.
+func argo(cluster awseksv2.Cluster, serviceAccount awseksv2.ServiceAccount) {
+	workflows := cluster.AddHelmChart(
+		pointer.ToString("ArgoWorkflows"),
+		&awseksv2.HelmChartOptions{
+			Chart:           pointer.ToString("argo-workflows"),
+			Repository:      pointer.ToString("https://argoproj.github.io/argo-helm"),
+			Version:         pointer.ToString(commons.ArgoWorkflowsChartVersion),
+			Namespace:       pointer.ToString("argo"),
+			CreateNamespace: pointer.ToBool(true),
+			Release:         pointer.ToString("argo-workflows"),
+		},
+	)
+
+	events := cluster.AddHelmChart(
+		pointer.ToString("ArgoEvents"),
+		&awseksv2.HelmChartOptions{
+			Chart:           pointer.ToString("argo-events"),
+			Repository:      pointer.ToString("https://argoproj.github.io/argo-helm"),
+			Version:         pointer.ToString(commons.ArgoEventsChartVersion),
+			Namespace:       pointer.ToString("argo-events"),
+			CreateNamespace: pointer.ToBool(true),
+			Release:         pointer.ToString("argo-events"),
+		},
+	)
+
+	role := func(name string, rules ...map[string]interface{}) []*map[string]interface{} {
+		return []*map[string]interface{}{
+			{
+				"apiVersion": "rbac.authorization.k8s.io/v1",
+				"kind":       "Role",
+				"metadata":   map[string]interface{}{"name": name, "namespace": commons.KubernetesNamespace},
+				"rules":      rules,
+			},
+			{
+				"apiVersion": "rbac.authorization.k8s.io/v1",
+				"kind":       "RoleBinding",
+				"metadata":   map[string]interface{}{"name": name, "namespace": commons.KubernetesNamespace},
+				"roleRef": map[string]interface{}{
+					"apiGroup": "rbac.authorization.k8s.io",
+					"kind":     "Role",
+					"name":     name,
+				},
+				"subjects": []map[string]interface{}{
+					{"kind": "ServiceAccount", "name": name, "namespace": commons.KubernetesNamespace},
+				},
+			},
+		}
+	}
+
+	manifests := []*map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "ServiceAccount",
+			"metadata":   map[string]interface{}{"name": commons.ArgoEventsServiceAccount, "namespace": commons.KubernetesNamespace},
+		},
+		{
+			"apiVersion": "argoproj.io/v1alpha1",
+			"kind":       "EventBus",
+			"metadata":   map[string]interface{}{"name": commons.ArgoEventsEventBus, "namespace": commons.KubernetesNamespace},
+			"spec": map[string]interface{}{
+				"jetstream": map[string]interface{}{"version": commons.ArgoEventsJetStreamVersion},
+			},
+		},
+		{
+			"apiVersion": "argoproj.io/v1alpha1",
+			"kind":       "EventSource",
+			"metadata":   map[string]interface{}{"name": commons.ArgoEventsEventSource, "namespace": commons.KubernetesNamespace},
+			"spec": map[string]interface{}{
+				"eventBusName": commons.ArgoEventsEventBus,
+				"service": map[string]interface{}{
+					"ports": []map[string]interface{}{
+						{"port": commons.ArgoEventsWebhookPort, "targetPort": commons.ArgoEventsWebhookPort},
+					},
+				},
+				"webhook": map[string]interface{}{
+					commons.ArgoEventsEvent: map[string]interface{}{
+						"port":     fmt.Sprint(commons.ArgoEventsWebhookPort),
+						"endpoint": "/" + commons.ArgoEventsEvent,
+						"method":   "POST",
+					},
+				},
+			},
+		},
+	}
+	// The workflow pods report their step results, the sensors submit the workflows of triggered flows.
+	manifests = append(manifests, role(*serviceAccount.ServiceAccountName(), map[string]interface{}{
+		"apiGroups": []string{"argoproj.io"},
+		"resources": []string{"workflowtaskresults"},
+		"verbs":     []string{"create", "patch"},
+	})...)
+	manifests = append(manifests, role(commons.ArgoEventsServiceAccount, map[string]interface{}{
+		"apiGroups": []string{"argoproj.io"},
+		"resources": []string{"workflows", "workflowtemplates"},
+		"verbs":     []string{"create", "get", "list", "watch"},
+	})...)
+
+	resources := cluster.AddManifest(pointer.ToString("MetaflowArgoResources"), manifests...)
+	resources.Node().AddDependency(serviceAccount, workflows, events)
+}
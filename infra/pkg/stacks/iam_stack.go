@@ -23,7 +23,7 @@ type IAMStackOutput struct {
 func BuildIAMStack(input IAMStackInput) IAMStackOutput {
 	stack := awscdk.NewStack(
 		input.Account.App,
-		pointer.ToString("IAMStack"),
+		pointer.ToString(commons.IAMStackName),
 		&awscdk.StackProps{
 			Env: input.Account.Env(),
 		},
@@ -0,0 +1,128 @@
+package stacks
+
+import (
+	"fmt"
+
+	"github.com/AlekSi/pointer"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awskms"
+	"github.com/aws/constructs-go/constructs/v10"
+	"go.uber.org/fx"
+)
+
+type KMSStackInput struct {
+	fx.In
+	Account commons.Account
+}
+
+type KMSStackOutput struct {
+	fx.Out
+	Stack   awscdk.Stack `group:"stacks"`
+	DataKey awskms.Key   `name:"data_key"`
+	LogsKey awskms.Key   `name:"logs_key"`
+}
+
+// BuildKMSStack creates one customer managed key per data class: the datastore, secrets and DynamoDB
+// state share the data key, CloudWatch log groups use the logs key.
+func BuildKMSStack(in KMSStackInput) KMSStackOutput {
+	stack := awscdk.NewStack(
+		in.Account.App,
+		pointer.ToString(commons.KMSStackName),
+		&awscdk.StackProps{
+			Env: in.Account.Env(),
+		},
+	)
+
+	return KMSStackOutput{
+		Stack:   stack,
+		DataKey: dataKey(stack, in),
+		LogsKey: logsKey(stack, in),
+	}
+}
+
+func dataKey(construct constructs.Construct, in KMSStackInput) awskms.Key {
+	key := awskms.NewKey(
+		construct,
+		pointer.ToString("MetaflowDataKey"),
+		&awskms.KeyProps{
+			Alias:             pointer.ToString("alias/metaflow/data"),
+			Description:       pointer.ToString("Metaflow datastore, secrets, DynamoDB state and SNS topics"),
+			EnableKeyRotation: pointer.ToBool(true),
+			RemovalPolicy:     awscdk.RemovalPolicy_RETAIN,
+		},
+	)
+
+	// EventBridge rules and CloudWatch alarms publish to the encrypted SNS topics.
+	key.AddToResourcePolicy(
+		awsiam.NewPolicyStatement(
+			&awsiam.PolicyStatementProps{
+				Sid:    pointer.ToString("NotificationPublishers"),
+				Effect: awsiam.Effect_ALLOW,
+				Principals: &[]awsiam.IPrincipal{
+					awsiam.NewServicePrincipal(pointer.ToString("events.amazonaws.com"), nil),
+					awsiam.NewServicePrincipal(pointer.ToString("cloudwatch.amazonaws.com"), nil),
+				},
+				Actions: &[]*string{
+					pointer.ToString("kms:Decrypt"),
+					pointer.ToString("kms:GenerateDataKey*"),
+				},
+				Resources: &[]*string{
+					pointer.ToString("*"),
+				},
+				Conditions: &map[string]interface{}{
+					"StringEquals": map[string]*string{
+						"aws:SourceAccount": pointer.ToString(in.Account.AccountId),
+					},
+				},
+			},
+		),
+		nil,
+	)
+
+	return key
+}
+
+func logsKey(construct constructs.Construct, in KMSStackInput) awskms.Key {
+	key := awskms.NewKey(
+		construct,
+		pointer.ToString("MetaflowLogsKey"),
+		&awskms.KeyProps{
+			Alias:             pointer.ToString("alias/metaflow/logs"),
+			Description:       pointer.ToString("Metaflow CloudWatch log groups"),
+			EnableKeyRotation: pointer.ToBool(true),
+			RemovalPolicy:     awscdk.RemovalPolicy_RETAIN,
+		},
+	)
+
+	key.AddToResourcePolicy(
+		awsiam.NewPolicyStatement(
+			&awsiam.PolicyStatementProps{
+				Sid:    pointer.ToString("CloudWatchLogs"),
+				Effect: awsiam.Effect_ALLOW,
+				Principals: &[]awsiam.IPrincipal{
+					awsiam.NewServicePrincipal(pointer.ToString(fmt.Sprintf("logs.%s.amazonaws.com", in.Account.Region)), nil),
+				},
+				Actions: &[]*string{
+					pointer.ToString("kms:Encrypt*"),
+					pointer.ToString("kms:Decrypt*"),
+					pointer.ToString("kms:ReEncrypt*"),
+					pointer.ToString("kms:GenerateDataKey*"),
+					pointer.ToString("kms:Describe*"),
+				},
+				Resources: &[]*string{
+					pointer.ToString("*"),
+				},
+				Conditions: &map[string]interface{}{
+					"ArnLike": map[string]*string{
+						"kms:EncryptionContext:aws:logs:arn": pointer.ToString(fmt.Sprintf("arn:aws:logs:%[1]s:%[2]s:log-group:*", in.Account.Region, in.Account.AccountId)),
+					},
+				},
+			},
+		),
+		nil,
+	)
+
+	return key
+}
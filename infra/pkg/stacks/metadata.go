@@ -37,7 +37,7 @@ type MetaflowMetadataOutput struct {
 }
 
 func BuildMetaflowMetadataStack(input MetaflowMetadataInput) MetaflowMetadataOutput {
-	stack_name := "MetaflowMetadataStack"
+	stack_name := commons.MetadataStackName
 	stack := awscdk.NewStack(
 		input.Account.App,
 		&stack_name,
@@ -181,26 +181,77 @@ func migrateFunction(construct constructs.Construct, in MetaflowMetadataInput, n
 			Code: awslambda.CfnFunction_CodeProperty{
 				ZipFile: pointer.ToString(
 					`
-import os, json
+import os, json, re
 from urllib import request
 
-def handler(event, context):
+def schema_status():
+	with request.urlopen("{}/db_schema_status".format(os.environ.get('MD_LB_ADDRESS'))) as status:
+		return json.loads(status.read())
+
+def migrate():
 	response = {}
-	status_endpoint = "{}/db_schema_status".format(os.environ.get('MD_LB_ADDRESS'))
 	upgrade_endpoint = "{}/upgrade".format(os.environ.get('MD_LB_ADDRESS'))
 
-	with request.urlopen(status_endpoint) as status:
-		response['init-status'] = json.loads(status.read())
+	response['init-status'] = schema_status()
 
 	upgrade_patch = request.Request(upgrade_endpoint, method='PATCH')
 	with request.urlopen(upgrade_patch) as upgrade:
 		response['upgrade-result'] = upgrade.read().decode()
 
-	with request.urlopen(status_endpoint) as status:
-		response['final-status'] = json.loads(status.read())
+	response['final-status'] = schema_status()
 
 	print(response)
-	return(response)
+	return response
+
+# version returns the numbers of the image tag, or None for a tag without any such as latest or a digest.
+def version(properties):
+	parts = re.findall(r'\d+', (properties or {}).get('MetadataVersion', ''))
+	return tuple(int(part) for part in parts) if parts else None
+
+def handler(event, context):
+	if 'RequestType' not in event:
+		return(migrate())
+
+	import cfnresponse
+	physical_id = 'metaflow-db-schema'
+	if event['RequestType'] == 'Delete':
+		cfnresponse.send(event, context, cfnresponse.SUCCESS, {}, physical_id)
+		return
+
+	# An update rollback sends the old properties back: the older image cannot migrate a schema its successor
+	# already upgraded, so the migration is skipped when both tags have a version and the new one is older.
+	# Deliberate downgrades take the same path. The schema still has to report itself as up to date.
+	new, old = version(event['ResourceProperties']), version(event.get('OldResourceProperties'))
+	if event['RequestType'] == 'Update' and new is not None and old is not None and new < old:
+		data = {'IsUpToDate': 'unknown', 'CurrentVersion': 'unknown', 'UpgradeResult': 'skipped, the image is older than the schema'}
+		try:
+			status = schema_status()
+			data['IsUpToDate'] = str(status.get('is_up_to_date'))
+			data['CurrentVersion'] = str(status.get('current_version'))
+		except Exception as e:
+			print('reading the schema status: {}'.format(e))
+		if data['IsUpToDate'] != 'True':
+			reason = 'DB schema is not up to date after skipping the migration: {}'.format(json.dumps(data))
+			cfnresponse.send(event, context, cfnresponse.FAILED, data, physical_id, reason=reason)
+			return
+		cfnresponse.send(event, context, cfnresponse.SUCCESS, data, physical_id)
+		return
+
+	try:
+		response = migrate()
+		final = response['final-status']
+		data = {
+			'IsUpToDate': str(final.get('is_up_to_date')),
+			'CurrentVersion': str(final.get('current_version')),
+			'UpgradeResult': response['upgrade-result'][:256],
+		}
+		if not final.get('is_up_to_date'):
+			reason = 'DB schema is still out of date: {}'.format(json.dumps(final))
+			cfnresponse.send(event, context, cfnresponse.FAILED, data, physical_id, reason=reason)
+			return
+		cfnresponse.send(event, context, cfnresponse.SUCCESS, data, physical_id)
+	except Exception as e:
+		cfnresponse.send(event, context, cfnresponse.FAILED, {}, physical_id, reason=str(e))
 					`,
 				),
 			},
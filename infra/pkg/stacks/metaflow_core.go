@@ -1,6 +1,8 @@
 package stacks
 
 import (
+	"strconv"
+
 	"github.com/AlekSi/pointer"
 	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
 	"github.com/aws/aws-cdk-go/awscdk/v2"
@@ -8,6 +10,8 @@ import (
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsecs"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awselasticloadbalancingv2"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awskms"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awslambda"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awslogs"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsrds"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awssecretsmanager"
@@ -17,29 +21,39 @@ import (
 type MetaflowMetadataTaskDefinitionInput struct {
 	fx.In
 	Account               commons.Account
-	VPC                   awsec2.Vpc                               `name:"metaflow_vpc"`
-	ECSCluster            awsecs.Cluster                           `name:"ecs_cluster"`
-	FargateSecurityGroup  awsec2.SecurityGroup                     `name:"fargate_security_group"`
-	SubnetA               awsec2.CfnSubnet                         `name:"metaflow_subnet_a"`
-	SubnetB               awsec2.CfnSubnet                         `name:"metaflow_subnet_b"`
-	NLBTargetGroup        awselasticloadbalancingv2.CfnTargetGroup `name:"nlb_target_group"`
-	NLBTargetGroupMigrate awselasticloadbalancingv2.CfnTargetGroup `name:"nlb_target_group_migrate"`
-	DB                    awsrds.CfnDBInstance                     `name:"DB"`
-	Credentials           awssecretsmanager.Secret                 `name:"db_credentials"`
-	ECSTaskRole           awsiam.Role                              `name:"ecs_task_role"`
+	Config                commons.Config
+	VPC                   awsec2.Vpc                                `name:"metaflow_vpc"`
+	ECSCluster            awsecs.Cluster                            `name:"ecs_cluster"`
+	FargateSecurityGroup  awsec2.SecurityGroup                      `name:"fargate_security_group"`
+	SubnetA               awsec2.CfnSubnet                          `name:"metaflow_subnet_a"`
+	SubnetB               awsec2.CfnSubnet                          `name:"metaflow_subnet_b"`
+	NLBTargetGroup        awselasticloadbalancingv2.CfnTargetGroup  `name:"nlb_target_group"`
+	NLBTargetGroupMigrate awselasticloadbalancingv2.CfnTargetGroup  `name:"nlb_target_group_migrate"`
+	LoadBalancer          awselasticloadbalancingv2.CfnLoadBalancer `name:"network_load_balancer"`
+	MigrateFunction       awslambda.CfnFunction                     `name:"migrate_function"`
+	DB                    awsrds.CfnDBInstance                      `name:"DB"`
+	Credentials           awssecretsmanager.Secret                  `name:"db_credentials"`
+	DBProxy               awsrds.CfnDBProxy                         `name:"db_proxy"`
+	MetadataDBCredentials awssecretsmanager.Secret                  `name:"metadata_db_credentials"`
+	UIDBCredentials       awssecretsmanager.Secret                  `name:"ui_db_credentials"`
+	DataKey               awskms.Key                                `name:"data_key"`
+	LogsKey               awskms.Key                                `name:"logs_key"`
+	ECSTaskRole           awsiam.Role                               `name:"ecs_task_role"`
 }
 
 type MetaflowMetadataTaskDefinitionOutput struct {
 	fx.Out
-	Stack          awscdk.Stack          `group:"stacks"`
-	MainDefinition awsecs.TaskDefinition `name:"main_task_definition"`
-	MainService    awsecs.CfnService     `name:"main_metaflow_service"`
+	Stack           awscdk.Stack          `group:"stacks"`
+	MainDefinition  awsecs.TaskDefinition `name:"main_task_definition"`
+	MainService     awsecs.CfnService     `name:"main_metaflow_service"`
+	SchemaMigration awscdk.CustomResource `name:"schema_migration"`
+	DBUsers         awscdk.CustomResource `name:"db_users"`
 }
 
 func TaskDefinitionsStack(input MetaflowMetadataTaskDefinitionInput) MetaflowMetadataTaskDefinitionOutput {
 	stack := awscdk.NewStack(
 		input.Account.App,
-		pointer.ToString("MetaflowCoreStack"),
+		pointer.ToString(commons.CoreStackName),
 		&awscdk.StackProps{
 			Env: input.Account.Env(),
 		},
@@ -52,13 +66,47 @@ func TaskDefinitionsStack(input MetaflowMetadataTaskDefinitionInput) MetaflowMet
 		input.NLBTargetGroup,
 		input.ECSCluster,
 		input.NLBTargetGroupMigrate,
+		input.Config.MetadataService,
 		input.SubnetA,
 		input.SubnetB)
+	var users awscdk.CustomResource
+	if input.DBProxy != nil {
+		users = dbUsers(stack, input)
+		mainService.Node().AddDependency(users)
+	}
+
+	scalableTarget := serviceScalableTarget(stack, "MetadataServiceScalableTarget", input.ECSCluster, mainService, input.Config.MetadataService)
+	cpuScalingPolicy(stack, "MetadataServiceCPUScaling", scalableTarget, input.Config.MetadataService)
+	activeFlowsScalingPolicy(
+		stack,
+		"MetadataServiceActiveFlowsScaling",
+		scalableTarget,
+		input.LoadBalancer,
+		input.ECSCluster,
+		mainService,
+		input.Config.MetadataService,
+	)
+
+	if input.Config.Persistence.RotationDays > 0 && input.DBProxy == nil {
+		redeployOnRotation(stack, "MetadataServiceCredentialsRotated", input.Credentials, input.ECSCluster, mainService)
+	}
+
+	schemaMigration := schemaMigration(stack, input.MigrateFunction, mainService, input.Config.Images)
+
+	awscdk.NewCfnOutput(
+		stack, pointer.ToString("METAFLOW_METADATA_VERSION"),
+		&awscdk.CfnOutputProps{
+			Value:       pointer.ToString(input.Config.Images.MetadataVersion),
+			Description: pointer.ToString("METAFLOW_METADATA_VERSION"),
+		},
+	)
 
 	return MetaflowMetadataTaskDefinitionOutput{
-		Stack:          stack,
-		MainDefinition: mainTaskDefinition,
-		MainService:    mainService,
+		Stack:           stack,
+		MainDefinition:  mainTaskDefinition,
+		MainService:     mainService,
+		SchemaMigration: schemaMigration,
+		DBUsers:         users,
 	}
 }
 
@@ -69,6 +117,7 @@ func mainService(
 	nlbTarget awselasticloadbalancingv2.CfnTargetGroup,
 	cluster awsecs.Cluster,
 	migrateTarget awselasticloadbalancingv2.CfnTargetGroup,
+	config commons.ServiceConfig,
 	subnets ...awsec2.CfnSubnet) awsecs.CfnService {
 
 	subnetsIds := make([]*string, len(subnets))
@@ -83,10 +132,12 @@ func mainService(
 		&awsecs.CfnServiceProps{
 			LaunchType: pointer.ToString("FARGATE"),
 			DeploymentConfiguration: &awsecs.CfnService_DeploymentConfigurationProperty{
-				MaximumPercent:        pointer.ToFloat64(200),
-				MinimumHealthyPercent: pointer.ToFloat64(100),
+				MaximumPercent:           pointer.ToFloat64(200),
+				MinimumHealthyPercent:    pointer.ToFloat64(100),
+				DeploymentCircuitBreaker: deploymentCircuitBreaker(),
 			},
-			DesiredCount: pointer.ToFloat64(1),
+			// No DesiredCount, the scalable target owns the task count and a deploy must not reset it.
+			AvailabilityZoneRebalancing: pointer.ToString("ENABLED"),
 			NetworkConfiguration: awsecs.CfnService_NetworkConfigurationProperty{
 				AwsvpcConfiguration: awsecs.CfnService_AwsVpcConfigurationProperty{
 					AssignPublicIp: pointer.ToString("ENABLED"),
@@ -122,8 +173,8 @@ func mainTaskDefinition(stack awscdk.Stack, input MetaflowMetadataTaskDefinition
 		pointer.ToString("Definition of main metaflow service"),
 		&awsecs.TaskDefinitionProps{
 			Family:        pointer.ToString("metadata-service-v2"),
-			Cpu:           pointer.ToString("512"),
-			MemoryMiB:     pointer.ToString("1024"),
+			Cpu:           pointer.ToString(strconv.Itoa(input.Config.MetadataService.Cpu)),
+			MemoryMiB:     pointer.ToString(strconv.Itoa(input.Config.MetadataService.MemoryMiB)),
 			NetworkMode:   awsecs.NetworkMode_AWS_VPC,
 			Compatibility: awsecs.Compatibility_EC2_AND_FARGATE,
 			ExecutionRole: executionRole,
@@ -138,25 +189,36 @@ func mainTaskDefinition(stack awscdk.Stack, input MetaflowMetadataTaskDefinition
 			LogGroupName:  pointer.ToString("ecs/metadata-service-v2"),
 			Retention:     awslogs.RetentionDays_EIGHTEEN_MONTHS,
 			RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
+			EncryptionKey: input.LogsKey,
 		},
 	)
 
 	containerLogGroup.GrantWrite(executionRole)
 
-	task.AddContainer(
+	environment := map[string]*string{
+		"MF_METADATA_DB_HOST":     input.DB.AttrEndpointAddress(),
+		"MF_METADATA_DB_PORT":     pointer.ToString("5432"),
+		"MF_METADATA_DB_SSL_MODE": pointer.ToString("prefer"),
+		"MF_METADATA_DB_NAME":     pointer.ToString("metaflow"),
+	}
+	secrets := map[string]awsecs.Secret{}
+	if input.DBProxy != nil {
+		environment["MF_METADATA_DB_HOST"] = input.DBProxy.AttrEndpoint()
+	} else {
+		secrets["MF_METADATA_DB_USER"] = awsecs.Secret_FromSecretsManager(input.Credentials, pointer.ToString("username"))
+		secrets["MF_METADATA_DB_PSWD"] = awsecs.Secret_FromSecretsManager(input.Credentials, pointer.ToString("password"))
+		input.Credentials.GrantRead(executionRole, nil)
+	}
+
+	container := task.AddContainer(
 		pointer.ToString("Metaflow execution container"),
 		&awsecs.ContainerDefinitionOptions{
-			ContainerName: pointer.ToString("metadata-service-v2"),
-			Environment: &map[string]*string{
-				"MF_METADATA_DB_HOST":     input.DB.AttrEndpointAddress(),
-				"MF_METADATA_DB_PORT":     pointer.ToString("5432"),
-				"MF_METADATA_DB_SSL_MODE": pointer.ToString("prefer"),
-				"MF_METADATA_DB_NAME":     pointer.ToString("metaflow"),
-			},
-			Cpu:            pointer.ToFloat64(512),
-			MemoryLimitMiB: pointer.ToFloat64(1024),
+			ContainerName:  pointer.ToString("metadata-service-v2"),
+			Environment:    &environment,
+			Cpu:            pointer.ToFloat64(float64(input.Config.MetadataService.Cpu)),
+			MemoryLimitMiB: pointer.ToFloat64(float64(input.Config.MetadataService.MemoryMiB)),
 			Image: awsecs.AssetImage_FromRegistry(
-				pointer.ToString(commons.MetaflowMetadataImage),
+				pointer.ToString(input.Config.Images.MetadataImage(input.Account)),
 				nil,
 			),
 			PortMappings: &[]*awsecs.PortMapping{
@@ -173,14 +235,69 @@ func mainTaskDefinition(stack awscdk.Stack, input MetaflowMetadataTaskDefinition
 					LogGroup:     containerLogGroup,
 				},
 			),
-			Secrets: &map[string]awsecs.Secret{
-				"MF_METADATA_DB_USER": awsecs.Secret_FromSecretsManager(input.Credentials, pointer.ToString("username")),
-				"MF_METADATA_DB_PSWD": awsecs.Secret_FromSecretsManager(input.Credentials, pointer.ToString("password")),
-			},
+			Secrets: &secrets,
 		},
 	)
-	input.Credentials.GrantRead(executionRole, nil)
-	input.Credentials.GrantWrite(executionRole)
+	// Behind the proxy the service logs in as its own user with IAM auth tokens.
+	if input.DBProxy != nil {
+		proxyIAMAuth(
+			stack, "MetadataServiceDBConnect", task, container, input.DBProxy, commons.MetadataDBUser,
+			input.Config.Images.AWSCLIImage(input.Account), containerLogGroup,
+		)
+	}
+	input.DataKey.GrantDecrypt(executionRole)
 
 	return task
 }
+
+// schemaMigration runs the migrate function once the service is stable and again whenever the metadata image changes.
+// The function answers FAILED while /db_schema_status still reports the schema as out of date, failing the deploy.
+// Going back to an older versioned image, as an update rollback does, skips the migration but not the status check.
+func schemaMigration(stack awscdk.Stack, migrateFunction awslambda.CfnFunction, service awsecs.CfnService, images commons.ImagesConfig) awscdk.CustomResource {
+	migration := awscdk.NewCustomResource(
+		stack,
+		pointer.ToString("MetaflowSchemaMigration"),
+		&awscdk.CustomResourceProps{
+			ServiceToken: migrateFunction.AttrArn(),
+			ResourceType: pointer.ToString("Custom::MetaflowSchemaMigration"),
+			Properties: &map[string]interface{}{
+				"MetadataVersion": images.MetadataVersion,
+			},
+		},
+	)
+	migration.Node().AddDependency(service)
+
+	awscdk.NewCfnOutput(
+		stack, pointer.ToString("METAFLOW_DB_SCHEMA_UP_TO_DATE"),
+		&awscdk.CfnOutputProps{
+			Value:       migration.GetAttString(pointer.ToString("IsUpToDate")),
+			Description: pointer.ToString("METAFLOW_DB_SCHEMA_UP_TO_DATE"),
+		},
+	)
+
+	awscdk.NewCfnOutput(
+		stack, pointer.ToString("METAFLOW_DB_SCHEMA_VERSION"),
+		&awscdk.CfnOutputProps{
+			Value:       migration.GetAttString(pointer.ToString("CurrentVersion")),
+			Description: pointer.ToString("METAFLOW_DB_SCHEMA_VERSION"),
+		},
+	)
+
+	awscdk.NewCfnOutput(
+		stack, pointer.ToString("METAFLOW_DB_MIGRATION_RESULT"),
+		&awscdk.CfnOutputProps{
+			Value:       migration.GetAttString(pointer.ToString("UpgradeResult")),
+			Description: pointer.ToString("METAFLOW_DB_MIGRATION_RESULT"),
+		},
+	)
+
+	return migration
+}
+
+// deploymentCircuitBreaker rolls a service back to its last steady deployment when new tasks keep failing.
+func deploymentCircuitBreaker() *awsecs.CfnService_DeploymentCircuitBreakerProperty {
+	return &awsecs.CfnService_DeploymentCircuitBreakerProperty{
+		Enable:   pointer.ToBool(true),
+		Rollback: pointer.ToBool(true),
+	}
+}
@@ -13,6 +13,7 @@ import (
 type MetaflowNetworkingInput struct {
 	fx.In
 	Account commons.Account
+	Config  commons.Config
 }
 
 type MetaflowNetworkingOutput struct {
@@ -26,13 +27,15 @@ type MetaflowNetworkingOutput struct {
 	SubnetA              awsec2.CfnSubnet               `name:"metaflow_subnet_a"`
 	SubnetB              awsec2.CfnSubnet               `name:"metaflow_subnet_b"`
 	SubnetC              awsec2.CfnSubnet               `name:"metaflow_subnet_c"`
+	SubnetD              awsec2.CfnSubnet               `name:"metaflow_subnet_d"`
+	NatGateway           awsec2.CfnNatGateway           `name:"metaflow_nat_gateway"`
 	FargateSecurityGroup awsec2.SecurityGroup           `name:"fargate_security_group"`
 	DBSecurityGroup      awsec2.SecurityGroup           `name:"db_security_group"`
 	UISecurityGroup      awsec2.SecurityGroup           `name:"ui_security_group"`
 }
 
 func BuildMetaflowNetworkingStack(input MetaflowNetworkingInput) MetaflowNetworkingOutput {
-	stack_name := "MetaflowNetworkingStack"
+	stack_name := commons.NetworkingStackName
 
 	nested_stack := awscdk.NewStack(
 		input.Account.App,
@@ -46,7 +49,8 @@ func BuildMetaflowNetworkingStack(input MetaflowNetworkingInput) MetaflowNetwork
 
 	subnetA := metaflowSubnetA(nested_stack, vpc)
 	subnetB := metaflowSubnetB(nested_stack, vpc)
-	subnetC := metaflowSubnetC(nested_stack, vpc, subnetB)
+	subnetC, natGateway, privateRouteTable := metaflowSubnetC(nested_stack, vpc, subnetB)
+	subnetD := metaflowSubnetD(nested_stack, vpc, privateRouteTable)
 
 	iGateway := metaflowVPCInternetGateway(nested_stack)
 	gatewayAttachment := internetGatewayAttachment(nested_stack, vpc, iGateway)
@@ -66,7 +70,7 @@ func BuildMetaflowNetworkingStack(input MetaflowNetworkingInput) MetaflowNetwork
 	)
 	fargateSecurityGroup := fargateSecurityGroup(nested_stack, vpc)
 
-	dbSecurityGroup := dbSecurityGroup(nested_stack, vpc, fargateSecurityGroup)
+	dbSecurityGroup := dbSecurityGroup(nested_stack, vpc, fargateSecurityGroup, input.Config.Persistence.PubliclyAccessible)
 
 	uiSecurityGroup := uiSecurityGroup(nested_stack, vpc, fargateSecurityGroup)
 
@@ -105,6 +109,8 @@ func BuildMetaflowNetworkingStack(input MetaflowNetworkingInput) MetaflowNetwork
 		SubnetA:              subnetA,
 		SubnetB:              subnetB,
 		SubnetC:              subnetC,
+		SubnetD:              subnetD,
+		NatGateway:           natGateway,
 		FargateSecurityGroup: fargateSecurityGroup,
 		DBSecurityGroup:      dbSecurityGroup,
 		UISecurityGroup:      uiSecurityGroup,
@@ -177,7 +183,7 @@ func metaflowSubnetB(stack awscdk.Stack, vpc awsec2.Vpc) awsec2.CfnSubnet {
 	return subnetB
 }
 
-func metaflowSubnetC(stack awscdk.Stack, vpc awsec2.Vpc, publicSubnet awsec2.CfnSubnet) awsec2.CfnSubnet {
+func metaflowSubnetC(stack awscdk.Stack, vpc awsec2.Vpc, publicSubnet awsec2.CfnSubnet) (awsec2.CfnSubnet, awsec2.CfnNatGateway, awsec2.CfnRouteTable) {
 	subnetCCIDR := "10.20.2.0/24"
 	subnetCName := "SubnetC"
 	subnetC := awsec2.NewCfnSubnet(
@@ -221,7 +227,36 @@ func metaflowSubnetC(stack awscdk.Stack, vpc awsec2.Vpc, publicSubnet awsec2.Cfn
 		SubnetId:     subnetC.Ref(),
 	})
 
-	return subnetC
+	return subnetC, natGw, routeTable
+}
+
+// metaflowSubnetD is a second private subnet, in the zone of SubnetA, for the EKS CPU nodes. It goes out through
+// the NAT gateway of SubnetC.
+func metaflowSubnetD(stack awscdk.Stack, vpc awsec2.Vpc, routeTable awsec2.CfnRouteTable) awsec2.CfnSubnet {
+	subnetDName := "SubnetD"
+	subnetD := awsec2.NewCfnSubnet(
+		stack,
+		&subnetDName,
+		&awsec2.CfnSubnetProps{
+			VpcId:               vpc.VpcId(),
+			CidrBlock:           pointer.ToString("10.20.3.0/24"),
+			AvailabilityZone:    (*stack.AvailabilityZones())[0],
+			MapPublicIpOnLaunch: pointer.ToBool(false),
+			Tags: &[]*awscdk.CfnTag{
+				{
+					Key:   pointer.ToString("Name"),
+					Value: &subnetDName,
+				},
+			},
+		},
+	)
+
+	awsec2.NewCfnSubnetRouteTableAssociation(stack, jsii.String("SubnetDAssociation"), &awsec2.CfnSubnetRouteTableAssociationProps{
+		RouteTableId: routeTable.Ref(),
+		SubnetId:     subnetD.Ref(),
+	})
+
+	return subnetD
 }
 
 func metaflowVPCInternetGateway(stack awscdk.Stack) awsec2.CfnInternetGateway {
@@ -289,7 +324,7 @@ func subnetRouteTableAssociation(name string, stack awscdk.Stack, subnet awsec2.
 	)
 }
 
-func dbSecurityGroup(construct constructs.Construct, vpc awsec2.Vpc, fargateSecurityGroup awsec2.SecurityGroup) awsec2.SecurityGroup {
+func dbSecurityGroup(construct constructs.Construct, vpc awsec2.Vpc, fargateSecurityGroup awsec2.SecurityGroup, publiclyAccessible bool) awsec2.SecurityGroup {
 	dbSecurityGroup := awsec2.NewSecurityGroup(
 		construct,
 		pointer.ToString("MetaflowDBSecurityGroup"),
@@ -312,12 +347,14 @@ func dbSecurityGroup(construct constructs.Construct, vpc awsec2.Vpc, fargateSecu
 		nil,
 	)
 
-	dbSecurityGroup.AddIngressRule( // for debugging purposes
-		awsec2.Peer_AnyIpv4(),
-		awsec2.Port_AllTraffic(),
-		pointer.ToString("Allow access to DB from internet"),
-		nil,
-	)
+	if publiclyAccessible {
+		dbSecurityGroup.AddIngressRule( // for debugging purposes
+			awsec2.Peer_AnyIpv4(),
+			awsec2.Port_AllTraffic(),
+			pointer.ToString("Allow access to DB from internet"),
+			nil,
+		)
+	}
 
 	return dbSecurityGroup
 }
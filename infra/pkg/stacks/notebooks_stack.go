@@ -13,6 +13,7 @@ import (
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awselasticloadbalancingv2"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awskms"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awss3"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awssagemaker"
 	"github.com/aws/constructs-go/constructs/v10"
@@ -33,6 +34,7 @@ type NotebookStackInput struct {
 	StateDDB          awsdynamodb.CfnGlobalTable                `name:"state_ddb"`
 	StepFunctionsRole awsiam.Role                               `name:"step_functions_role"`
 	BatchRole         awsiam.Role                               `name:"batch_execution_role"`
+	DataKey           awskms.Key                                `name:"data_key"`
 }
 
 type NotebookStackOutput struct {
@@ -46,13 +48,14 @@ type NotebookStackOutput struct {
 func BuildNotebooksStack(in NotebookStackInput) NotebookStackOutput {
 	stack := awscdk.NewStack(
 		in.Account.App,
-		pointer.ToString("NotebookStack"),
+		pointer.ToString(commons.NotebookStackName),
 		&awscdk.StackProps{
 			Env: in.Account.Env(),
 		},
 	)
 
 	notebookExecutionRole := buildSageMakerExecutionRole(stack)
+	in.DataKey.GrantEncryptDecrypt(notebookExecutionRole)
 	securityGroup := buildSageMakerSecurityGroup(stack, in)
 	notebookLifecycleConfig := buildNotebookLyfecycle(stack, in)
 
@@ -98,6 +101,7 @@ echo 'export AWS_DEFAULT_REGION=%[3]s' >> /etc/profile.d/jupyter-env.sh
 echo 'export METAFLOW_DEFAULT_DATASTORE=s3' >> /etc/profile.d/jupyter-env.sh
 echo 'export METAFLOW_DEFAULT_METADATA=service' >> /etc/profile.d/jupyter-env.sh
 echo 'export METAFLOW_BATCH_JOB_QUEUE=%[4]s' >> /etc/profile.d/jupyter-env.sh
+echo 'export METAFLOW_BATCH_EMIT_TAGS=true' >> /etc/profile.d/jupyter-env.sh
 echo 'export METAFLOW_ECS_S3_ACCESS_IAM_ROLE=%[5]s' >> /etc/profile.d/jupyter-env.sh
 echo 'export METAFLOW_EVENTS_SFN_ACCESS_IAM_ROLE=%[6]s' >> /etc/profile.d/jupyter-env.sh
 echo 'export METAFLOW_SFN_DYNAMO_DB_TABLE=%[7]s' >> /etc/profile.d/jupyter-env.sh
@@ -0,0 +1,387 @@
+package stacks
+
+import (
+	"fmt"
+
+	"github.com/AlekSi/pointer"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsapigateway"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsbatch"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awschatbot"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awscloudwatch"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awscloudwatchactions"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsecs"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awselasticloadbalancingv2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsevents"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseventstargets"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awskms"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awslambda"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssns"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssnssubscriptions"
+	"github.com/aws/aws-cdk-go/awscdk/v2/interfaces/interfacesawscloudwatch"
+	"github.com/aws/constructs-go/constructs/v10"
+	"go.uber.org/fx"
+)
+
+type ObservabilityStackInput struct {
+	fx.In
+	Account        commons.Account
+	Config         commons.Config
+	DataKey        awskms.Key                                `name:"data_key"`
+	ECSCluster     awsecs.Cluster                            `name:"ecs_cluster"`
+	MainService    awsecs.CfnService                         `name:"main_metaflow_service"`
+	LoadBalancer   awselasticloadbalancingv2.CfnLoadBalancer `name:"network_load_balancer"`
+	NLBTargetGroup awselasticloadbalancingv2.CfnTargetGroup  `name:"nlb_target_group"`
+	Database       commons.IDatabase                         `name:"database"`
+	ApiGateway     awsapigateway.RestApi                     `name:"api_gateway"`
+	ComputeEnv     awsbatch.CfnComputeEnvironment            `name:"batch_compute_environment"`
+	JobQueue       awsbatch.CfnJobQueue                      `name:"batch_job_queue"`
+	NatGateway     awsec2.CfnNatGateway                      `name:"metaflow_nat_gateway"`
+	FailuresTopic  awssns.Topic                              `name:"sfn_failures_topic"`
+	FailuresAlarm  awscloudwatch.Alarm                       `name:"sfn_failures_alarm"`
+}
+
+type ObservabilityStackOutput struct {
+	fx.Out
+	Stack       awscdk.Stack            `group:"stacks"`
+	AlarmsTopic awssns.Topic            `name:"alarms_topic"`
+	Dashboard   awscloudwatch.Dashboard `name:"metaflow_dashboard"`
+}
+
+// BuildObservabilityStack puts the metadata service, the database, Batch and its GPUs, the NAT gateway and the
+// API on one dashboard and publishes their alarms to a topic, optionally relayed to Slack through AWS Chatbot.
+func BuildObservabilityStack(in ObservabilityStackInput) ObservabilityStackOutput {
+	stack := awscdk.NewStack(
+		in.Account.App,
+		pointer.ToString(commons.ObservabilityStackName),
+		&awscdk.StackProps{
+			Env: in.Account.Env(),
+		},
+	)
+
+	topic := awssns.NewTopic(
+		stack,
+		pointer.ToString("AlarmsTopic"),
+		&awssns.TopicProps{
+			TopicName:   pointer.ToString(commons.AlarmsTopicName),
+			DisplayName: pointer.ToString("Metaflow alarms"),
+			MasterKey:   awskms.Key_FromKeyArn(stack, pointer.ToString("TopicKey"), in.DataKey.KeyArn()),
+			EnforceSSL:  pointer.ToBool(true),
+		},
+	)
+	if email := in.Config.Observability.AlarmEmail; email != "" {
+		topic.AddSubscription(awssnssubscriptions.NewEmailSubscription(pointer.ToString(email), nil))
+	}
+
+	batchMetrics(stack, in)
+
+	dashboard := awscloudwatch.NewDashboard(
+		stack,
+		pointer.ToString("Dashboard"),
+		&awscloudwatch.DashboardProps{
+			DashboardName:   pointer.ToString("metaflow"),
+			DefaultInterval: awscdk.Duration_Hours(pointer.ToFloat64(3)),
+		},
+	)
+
+	alarms := platformAlarms(stack, in, dashboard)
+	action := awscloudwatchactions.NewSnsAction(topic)
+	widgetAlarms := []interfacesawscloudwatch.IAlarmRef{in.FailuresAlarm}
+	for _, alarm := range alarms {
+		alarm.AddAlarmAction(action)
+		alarm.AddOkAction(action)
+		widgetAlarms = append(widgetAlarms, alarm)
+	}
+
+	dashboard.AddWidgets(awscloudwatch.NewAlarmStatusWidget(&awscloudwatch.AlarmStatusWidgetProps{
+		Title:  pointer.ToString("Alarms"),
+		Alarms: &widgetAlarms,
+		Width:  pointer.ToFloat64(24),
+	}))
+
+	if in.Config.Observability.SlackEnabled() {
+		awschatbot.NewSlackChannelConfiguration(
+			stack,
+			pointer.ToString("SlackChannel"),
+			&awschatbot.SlackChannelConfigurationProps{
+				SlackChannelConfigurationName: pointer.ToString("metaflow-alarms"),
+				SlackWorkspaceId:              pointer.ToString(in.Config.Observability.SlackWorkspaceId),
+				SlackChannelId:                pointer.ToString(in.Config.Observability.SlackChannelId),
+				NotificationTopics:            &[]awssns.ITopic{topic, in.FailuresTopic},
+				LoggingLevel:                  awschatbot.LoggingLevel_ERROR,
+			},
+		)
+	}
+
+	awscdk.NewCfnOutput(
+		stack, pointer.ToString("METAFLOW_ALARMS_TOPIC"),
+		&awscdk.CfnOutputProps{
+			Value:       topic.TopicArn(),
+			Description: pointer.ToString("METAFLOW_ALARMS_TOPIC"),
+		},
+	)
+
+	awscdk.NewCfnOutput(
+		stack, pointer.ToString("METAFLOW_DASHBOARD_URL"),
+		&awscdk.CfnOutputProps{
+			Value: pointer.ToString(fmt.Sprintf(
+				"https://%[1]s.console.aws.amazon.com/cloudwatch/home?region=%[1]s#dashboards/dashboard/metaflow",
+				in.Account.Region,
+			)),
+			Description: pointer.ToString("METAFLOW_DASHBOARD_URL"),
+		},
+	)
+
+	return ObservabilityStackOutput{
+		Stack:       stack,
+		AlarmsTopic: topic,
+		Dashboard:   dashboard,
+	}
+}
+
+func metric(namespace, name string, statistic *string, dimensions map[string]*string, label string) awscloudwatch.Metric {
+	return awscloudwatch.NewMetric(&awscloudwatch.MetricProps{
+		Namespace:     pointer.ToString(namespace),
+		MetricName:    pointer.ToString(name),
+		DimensionsMap: &dimensions,
+		Statistic:     statistic,
+		Period:        awscdk.Duration_Minutes(pointer.ToFloat64(5)),
+		Label:         pointer.ToString(label),
+	})
+}
+
+func graph(title string, left ...awscloudwatch.IMetric) awscloudwatch.GraphWidget {
+	return awscloudwatch.NewGraphWidget(&awscloudwatch.GraphWidgetProps{
+		Title: pointer.ToString(title),
+		Left:  &left,
+		Width: pointer.ToFloat64(8),
+	})
+}
+
+// platformAlarms fills the dashboard and returns the alarms raised on its metrics.
+func platformAlarms(construct constructs.Construct, in ObservabilityStackInput, dashboard awscloudwatch.Dashboard) []awscloudwatch.Alarm {
+	service := map[string]*string{
+		"ClusterName": in.ECSCluster.ClusterName(),
+		"ServiceName": in.MainService.AttrName(),
+	}
+	serviceCPU := metric("AWS/ECS", "CPUUtilization", awscloudwatch.Stats_AVERAGE(), service, "CPU")
+	serviceMemory := metric("AWS/ECS", "MemoryUtilization", awscloudwatch.Stats_AVERAGE(), service, "Memory")
+
+	targets := map[string]*string{
+		"LoadBalancer": in.LoadBalancer.AttrLoadBalancerFullName(),
+		"TargetGroup":  in.NLBTargetGroup.AttrTargetGroupFullName(),
+	}
+	healthyHosts := metric("AWS/NetworkELB", "HealthyHostCount", awscloudwatch.Stats_MINIMUM(), targets, "Healthy")
+	unhealthyHosts := metric("AWS/NetworkELB", "UnHealthyHostCount", awscloudwatch.Stats_MAXIMUM(), targets, "Unhealthy")
+
+	database := map[string]*string{"DBInstanceIdentifier": in.Database.GetRef()}
+	if in.Database.GetTargetType() == commons.DBClusterTargetType {
+		database = map[string]*string{"DBClusterIdentifier": in.Database.GetRef()}
+	}
+	databaseCPU := metric("AWS/RDS", "CPUUtilization", awscloudwatch.Stats_AVERAGE(), database, "CPU")
+	databaseConnections := metric("AWS/RDS", "DatabaseConnections", awscloudwatch.Stats_MAXIMUM(), database, "Connections")
+	freeStorage := metric("AWS/RDS", "FreeStorageSpace", awscloudwatch.Stats_MINIMUM(), database, "Free storage")
+
+	queue := map[string]*string{"JobQueue": in.JobQueue.JobQueueName()}
+	runnableJobs := metric(commons.BatchMetricsNamespace, "RunnableJobs", awscloudwatch.Stats_MAXIMUM(), queue, "Runnable")
+	runningJobs := metric(commons.BatchMetricsNamespace, "RunningJobs", awscloudwatch.Stats_MAXIMUM(), queue, "Running")
+	gpuInstances := metric(commons.BatchMetricsNamespace, "RunningInstances", awscloudwatch.Stats_MAXIMUM(), queue, "GPU instances")
+
+	// The CloudWatch agent on the Batch hosts rolls the GPU metrics up by instance type.
+	gpu := map[string]*string{"InstanceType": pointer.ToString(commons.BatchGPUInstanceType)}
+	gpuUtilization := metric(commons.GPUMetricsNamespace, "nvidia_smi_utilization_gpu", awscloudwatch.Stats_AVERAGE(), gpu, "GPU")
+	gpuMemory := metric(commons.GPUMetricsNamespace, "nvidia_smi_utilization_memory", awscloudwatch.Stats_AVERAGE(), gpu, "Memory")
+	gpuTemperature := metric(commons.GPUMetricsNamespace, "nvidia_smi_temperature_gpu", awscloudwatch.Stats_MAXIMUM(), gpu, "Temperature (C)")
+	gpuPower := metric(commons.GPUMetricsNamespace, "nvidia_smi_power_draw", awscloudwatch.Stats_AVERAGE(), gpu, "Power (W)")
+	// The Batch hosts also publish the utilization of the GPUs of each job with a JobId dimension.
+	gpuByJob := awscloudwatch.NewMathExpression(&awscloudwatch.MathExpressionProps{
+		Expression: pointer.ToString(fmt.Sprintf(
+			`SEARCH('{%s,JobId} MetricName="nvidia_smi_utilization_gpu"', 'Average', 300)`,
+			commons.GPUMetricsNamespace,
+		)),
+		UsingMetrics: &map[string]awscloudwatch.IMetric{},
+		Period:       awscdk.Duration_Minutes(pointer.ToFloat64(5)),
+	})
+
+	nat := map[string]*string{"NatGatewayId": in.NatGateway.Ref()}
+	natOut := metric("AWS/NATGateway", "BytesOutToDestination", awscloudwatch.Stats_SUM(), nat, "Out to destination")
+	natIn := metric("AWS/NATGateway", "BytesInFromDestination", awscloudwatch.Stats_SUM(), nat, "In from destination")
+	natPortErrors := metric("AWS/NATGateway", "ErrorPortAllocation", awscloudwatch.Stats_SUM(), nat, "Port allocation errors")
+
+	api := map[string]*string{"ApiName": in.ApiGateway.RestApiName()}
+	api5xx := metric("AWS/ApiGateway", "5XXError", awscloudwatch.Stats_SUM(), api, "5xx")
+	api4xx := metric("AWS/ApiGateway", "4XXError", awscloudwatch.Stats_SUM(), api, "4xx")
+
+	dashboard.AddWidgets(
+		graph("Metadata service CPU and memory (%)", serviceCPU, serviceMemory),
+		graph("Metadata service NLB targets", healthyHosts, unhealthyHosts),
+		graph("API Gateway errors", api5xx, api4xx),
+	)
+	dashboard.AddWidgets(
+		graph("Database CPU (%)", databaseCPU),
+		graph("Database connections", databaseConnections),
+		graph("Database free storage (bytes)", freeStorage),
+	)
+	dashboard.AddWidgets(
+		graph("Batch queue depth", runnableJobs, runningJobs),
+		graph("Batch running GPU instances", gpuInstances),
+		graph("NAT gateway bytes", natOut, natIn),
+	)
+	dashboard.AddWidgets(
+		graph("GPU utilization (%)", gpuUtilization, gpuMemory),
+		graph("GPU utilization by job (%)", gpuByJob),
+		awscloudwatch.NewGraphWidget(&awscloudwatch.GraphWidgetProps{
+			Title: pointer.ToString("GPU temperature and power"),
+			Left:  &[]awscloudwatch.IMetric{gpuTemperature},
+			Right: &[]awscloudwatch.IMetric{gpuPower},
+			Width: pointer.ToFloat64(8),
+		}),
+	)
+
+	// Batch only launches instances for RUNNABLE jobs, a backlog with none running means it cannot place them.
+	stuckJobs := awscloudwatch.NewMathExpression(&awscloudwatch.MathExpressionProps{
+		Expression: pointer.ToString("IF(runnable > 0 AND instances == 0, 1, 0)"),
+		UsingMetrics: &map[string]awscloudwatch.IMetric{
+			"runnable":  runnableJobs,
+			"instances": gpuInstances,
+		},
+		Period: awscdk.Duration_Minutes(pointer.ToFloat64(5)),
+		Label:  pointer.ToString("Runnable jobs without instances"),
+	})
+
+	idleGPUs := awscloudwatch.NewMathExpression(&awscloudwatch.MathExpressionProps{
+		Expression: pointer.ToString("IF(instances > 0 AND utilization < 10, 1, 0)"),
+		UsingMetrics: &map[string]awscloudwatch.IMetric{
+			"instances":   gpuInstances,
+			"utilization": gpuUtilization,
+		},
+		Period: awscdk.Duration_Minutes(pointer.ToFloat64(5)),
+		Label:  pointer.ToString("Running GPU instances below 10% utilization"),
+	})
+
+	alarm := func(id, description string, m awscloudwatch.IMetric, operator awscloudwatch.ComparisonOperator, threshold, periods float64, missing awscloudwatch.TreatMissingData) awscloudwatch.Alarm {
+		return awscloudwatch.NewAlarm(
+			construct,
+			pointer.ToString(id+"Alarm"),
+			&awscloudwatch.AlarmProps{
+				AlarmName:          pointer.ToString("Metaflow" + id),
+				AlarmDescription:   pointer.ToString(description),
+				Metric:             m,
+				Threshold:          pointer.ToFloat64(threshold),
+				EvaluationPeriods:  pointer.ToFloat64(periods),
+				ComparisonOperator: operator,
+				TreatMissingData:   missing,
+			},
+		)
+	}
+
+	alarms := []awscloudwatch.Alarm{
+		alarm("MetadataServiceCPUHigh", "Metadata service CPU above 85% for 15 minutes",
+			serviceCPU, awscloudwatch.ComparisonOperator_GREATER_THAN_THRESHOLD, 85, 3, awscloudwatch.TreatMissingData_MISSING),
+		alarm("MetadataServiceMemoryHigh", "Metadata service memory above 85% for 15 minutes",
+			serviceMemory, awscloudwatch.ComparisonOperator_GREATER_THAN_THRESHOLD, 85, 3, awscloudwatch.TreatMissingData_MISSING),
+		alarm("MetadataServiceNoHealthyHosts", "No healthy metadata service targets behind the NLB",
+			healthyHosts, awscloudwatch.ComparisonOperator_LESS_THAN_THRESHOLD, 1, 2, awscloudwatch.TreatMissingData_BREACHING),
+		alarm("DatabaseCPUHigh", "Metadata database CPU above 80% for 15 minutes",
+			databaseCPU, awscloudwatch.ComparisonOperator_GREATER_THAN_THRESHOLD, 80, 3, awscloudwatch.TreatMissingData_MISSING),
+		alarm("ApiGateway5xx", "The Metaflow API returned 5xx responses",
+			api5xx, awscloudwatch.ComparisonOperator_GREATER_THAN_OR_EQUAL_TO_THRESHOLD, 5, 1, awscloudwatch.TreatMissingData_NOT_BREACHING),
+		alarm("BatchJobsStuck", "Batch jobs runnable for 30 minutes without a running instance",
+			stuckJobs, awscloudwatch.ComparisonOperator_GREATER_THAN_OR_EQUAL_TO_THRESHOLD, 1, 6, awscloudwatch.TreatMissingData_NOT_BREACHING),
+		alarm("GPUIdle", "Batch GPU instances below 10% utilization for 30 minutes",
+			idleGPUs, awscloudwatch.ComparisonOperator_GREATER_THAN_OR_EQUAL_TO_THRESHOLD, 1, 6, awscloudwatch.TreatMissingData_NOT_BREACHING),
+		alarm("NatGatewayPortAllocationErrors", "The NAT gateway ran out of source ports",
+			natPortErrors, awscloudwatch.ComparisonOperator_GREATER_THAN_OR_EQUAL_TO_THRESHOLD, 1, 1, awscloudwatch.TreatMissingData_NOT_BREACHING),
+	}
+
+	// Aurora Serverless grows its storage on its own, only instances can run out of it.
+	if in.Database.GetTargetType() == commons.DBInstanceTargetType {
+		alarms = append(alarms, alarm("DatabaseFreeStorageLow", "Metadata database has less than 2 GiB of free storage",
+			freeStorage, awscloudwatch.ComparisonOperator_LESS_THAN_THRESHOLD, 2*1024*1024*1024, 1, awscloudwatch.TreatMissingData_MISSING))
+	}
+
+	return alarms
+}
+
+// batchMetrics publishes the job queue depth and the instances of the GPU compute environment every minute,
+// Batch has no CloudWatch metrics of its own.
+func batchMetrics(construct constructs.Construct, in ObservabilityStackInput) {
+	function := awslambda.NewFunction(
+		construct,
+		pointer.ToString("BatchMetrics"),
+		&awslambda.FunctionProps{
+			Runtime: awslambda.Runtime_PYTHON_3_12(),
+			Handler: pointer.ToString("index.handler"),
+			Timeout: awscdk.Duration_Seconds(pointer.ToFloat64(30)),
+			Code: awslambda.Code_FromInline(pointer.ToString(`
+import os, boto3
+
+batch = boto3.client('batch')
+ecs = boto3.client('ecs')
+cloudwatch = boto3.client('cloudwatch')
+
+def count_jobs(status):
+	pages = batch.get_paginator('list_jobs').paginate(jobQueue=os.environ['JOB_QUEUE'], jobStatus=status)
+	return sum(len(page['jobSummaryList']) for page in pages)
+
+def count_instances():
+	environment = batch.describe_compute_environments(
+		computeEnvironments=[os.environ['COMPUTE_ENVIRONMENT']],
+	)['computeEnvironments'][0]
+	if not environment.get('ecsClusterArn'):
+		return 0
+	pages = ecs.get_paginator('list_container_instances').paginate(cluster=environment['ecsClusterArn'], status='ACTIVE')
+	return sum(len(page['containerInstanceArns']) for page in pages)
+
+def handler(event, context):
+	dimensions = [{'Name': 'JobQueue', 'Value': os.environ['JOB_QUEUE']}]
+	cloudwatch.put_metric_data(Namespace=os.environ['NAMESPACE'], MetricData=[
+		{'MetricName': 'RunnableJobs', 'Dimensions': dimensions, 'Value': count_jobs('RUNNABLE'), 'Unit': 'Count'},
+		{'MetricName': 'RunningJobs', 'Dimensions': dimensions, 'Value': count_jobs('RUNNING'), 'Unit': 'Count'},
+		{'MetricName': 'RunningInstances', 'Dimensions': dimensions, 'Value': count_instances(), 'Unit': 'Count'},
+	])
+`)),
+			Environment: &map[string]*string{
+				"JOB_QUEUE":           in.JobQueue.JobQueueName(),
+				"COMPUTE_ENVIRONMENT": in.ComputeEnv.Ref(),
+				"NAMESPACE":           pointer.ToString(commons.BatchMetricsNamespace),
+			},
+		},
+	)
+
+	function.AddToRolePolicy(awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+		Actions: &[]*string{
+			pointer.ToString("batch:ListJobs"),
+			pointer.ToString("batch:DescribeComputeEnvironments"),
+		},
+		Resources: &[]*string{pointer.ToString("*")},
+	}))
+	function.AddToRolePolicy(awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+		Actions: &[]*string{pointer.ToString("ecs:ListContainerInstances")},
+		Resources: &[]*string{pointer.ToString(fmt.Sprintf(
+			"arn:aws:ecs:%s:%s:cluster/AWSBatch-*", in.Account.Region, in.Account.AccountId,
+		))},
+	}))
+	function.AddToRolePolicy(awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+		Actions:   &[]*string{pointer.ToString("cloudwatch:PutMetricData")},
+		Resources: &[]*string{pointer.ToString("*")},
+		Conditions: &map[string]any{
+			"StringEquals": map[string]any{
+				"cloudwatch:namespace": commons.BatchMetricsNamespace,
+			},
+		},
+	}))
+
+	rule := awsevents.NewRule(
+		construct,
+		pointer.ToString("BatchMetricsSchedule"),
+		&awsevents.RuleProps{
+			Description: pointer.ToString("Publish the Metaflow Batch queue metrics"),
+			Schedule:    awsevents.Schedule_Rate(awscdk.Duration_Minutes(pointer.ToFloat64(1))),
+		},
+	)
+	rule.AddTarget(awseventstargets.NewLambdaFunction(function, nil))
+}
@@ -1,11 +1,18 @@
 package stacks
 
 import (
+	"fmt"
+	"strconv"
+
 	"github.com/AlekSi/pointer"
 	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
 	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsbackup"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsdynamodb"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsevents"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awskms"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsrds"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awss3"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awssecretsmanager"
@@ -16,43 +23,100 @@ import (
 type PersistenceStackInput struct {
 	fx.In
 	Account              commons.Account
-	SubnetA              awsec2.CfnSubnet     `name:"metaflow_subnet_a"`
-	SubnetB              awsec2.CfnSubnet     `name:"metaflow_subnet_b"`
-	FargateSecurityGroup awsec2.SecurityGroup `name:"fargate_security_group"`
-	DBSecurityGroup      awsec2.SecurityGroup `name:"db_security_group"`
+	Config               commons.Config
+	VPC                  awsec2.Vpc            `name:"metaflow_vpc"`
+	SubnetA              awsec2.CfnSubnet      `name:"metaflow_subnet_a"`
+	SubnetB              awsec2.CfnSubnet      `name:"metaflow_subnet_b"`
+	SubnetC              awsec2.CfnSubnet      `name:"metaflow_subnet_c"`
+	FargateSecurityGroup awsec2.SecurityGroup  `name:"fargate_security_group"`
+	DBSecurityGroup      awsec2.SecurityGroup  `name:"db_security_group"`
+	DataKey              awskms.Key            `name:"data_key"`
+	DRStack              awscdk.Stack          `name:"dr_stack" optional:"true"`
+	DRKey                awskms.Key            `name:"dr_key" optional:"true"`
+	DRReplicaBucket      awss3.Bucket          `name:"dr_replica_bucket" optional:"true"`
+	DRBackupVault        awsbackup.BackupVault `name:"dr_backup_vault" optional:"true"`
 }
 
 type PersistenceStackOutput struct {
 	fx.Out
-	Construct   constructs.Construct       `group:"stacks"`
-	DB          awsrds.CfnDBInstance       `name:"DB"`
-	Credentials awssecretsmanager.Secret   `name:"db_credentials"`
-	Bucket      awss3.Bucket               `name:"s3_bucket"`
-	StateDDB    awsdynamodb.CfnGlobalTable `name:"state_ddb"`
+	Construct             constructs.Construct       `group:"stacks"`
+	DB                    awsrds.CfnDBInstance       `name:"DB"`
+	Database              commons.IDatabase          `name:"database"`
+	Credentials           awssecretsmanager.Secret   `name:"db_credentials"`
+	DBProxy               awsrds.CfnDBProxy          `name:"db_proxy"`
+	MetadataDBCredentials awssecretsmanager.Secret   `name:"metadata_db_credentials"`
+	UIDBCredentials       awssecretsmanager.Secret   `name:"ui_db_credentials"`
+	Bucket                awss3.Bucket               `name:"s3_bucket"`
+	StateDDB              awsdynamodb.CfnGlobalTable `name:"state_ddb"`
 }
 
 func BuildPersistenceStack(in PersistenceStackInput) PersistenceStackOutput {
 	stack := awscdk.NewStack(
 		in.Account.App,
-		pointer.ToString("PersistenceStack"),
+		pointer.ToString(commons.PersistenceStackName),
 		&awscdk.StackProps{
-			Env: in.Account.Env(),
+			Env:                   in.Account.Env(),
+			CrossRegionReferences: pointer.ToBool(in.DRStack != nil),
 		},
 	)
+	if in.DRStack != nil {
+		stack.AddDependency(in.DRStack, pointer.ToString("The DR copies are encrypted with the secondary region key"))
+	}
 
 	subnetGroup := dbSubnetGroup(stack, in.SubnetA, in.SubnetB)
-	dbCredentials := dbCredentials(stack)
-	db := dbInstance(stack, dbCredentials, subnetGroup, in)
-	_ = credentialsAttachmentToDB(stack, db, dbCredentials)
-	bucket := bucket(stack)
-	ddb := graphStateDB(stack)
+	// Secret grants go through the key policy for a key owned by the stack, which would make the KMSStack
+	// depend on every stack reading the secrets. The imported key keeps those grants in the IAM policies.
+	secretsKey := awskms.Key_FromKeyArn(stack, pointer.ToString("SecretsKey"), in.DataKey.KeyArn())
+	dbCredentials := dbCredentials(stack, "DBCredentials", commons.MetaflowDBUsername, secretsKey)
+	var dbKey awskms.Key
+	if in.Config.Persistence.StorageEncrypted {
+		dbKey = dbEncryptionKey(stack)
+	}
+	var db commons.IDatabase
+	if in.Config.Persistence.Engine == commons.AuroraServerlessEngine {
+		cluster, writer := auroraServerlessCluster(stack, dbCredentials, subnetGroup, dbKey, in)
+		db = commons.DBCluster{Cluster: cluster, Writer: writer}
+	} else {
+		db = commons.DBInstance{Instance: dbInstance(stack, dbCredentials, subnetGroup, dbKey, in)}
+	}
+	attachment := credentialsAttachmentToDB(stack, "SecretAttachmentToDB", db, dbCredentials)
+	if in.Config.Persistence.RotationDays > 0 {
+		credentialsRotation(stack, "DBCredentialsRotation", dbCredentials, attachment, true, in)
+	}
+
+	var proxy awsrds.CfnDBProxy
+	var metadataCredentials, uiCredentials awssecretsmanager.Secret
+	if in.Config.Persistence.Proxy {
+		metadataCredentials = serviceDBCredentials(stack, "MetadataDBCredentials", commons.MetadataDBUser, db, secretsKey, in)
+		uiCredentials = serviceDBCredentials(stack, "UIDBCredentials", commons.UIDBUser, db, secretsKey, in)
+		proxy = dbProxy(stack, db, dbCredentials, metadataCredentials, uiCredentials, in)
+	}
+
+	var replicationRules *[]*awss3.ReplicationRule
+	if in.DRStack != nil {
+		replicationRules = datastoreReplicationRules(in)
+	}
+	bucket := bucket(stack, in.Config.Datastore, in.DataKey, replicationRules)
+	if len(in.Config.Scheduling.Triggers) > 0 {
+		bucket.EnableEventBridgeNotification()
+	}
+	ddb := graphStateDB(stack, in.DataKey, in)
+
+	if in.DRStack != nil {
+		snapshotCopies(stack, db, in)
+		drOutputs(stack, bucket, in)
+	}
 
 	return PersistenceStackOutput{
-		Construct:   stack,
-		DB:          db,
-		Credentials: dbCredentials,
-		Bucket:      bucket,
-		StateDDB:    ddb,
+		Construct:             stack,
+		DB:                    db.AsInstance(),
+		Database:              db,
+		Credentials:           dbCredentials,
+		DBProxy:               proxy,
+		MetadataDBCredentials: metadataCredentials,
+		UIDBCredentials:       uiCredentials,
+		Bucket:                bucket,
+		StateDDB:              ddb,
 	}
 }
 
@@ -73,69 +137,318 @@ func dbSubnetGroup(construct constructs.Construct, subnets ...awsec2.CfnSubnet)
 	return group
 }
 
-func dbCredentials(construct constructs.Construct) awssecretsmanager.Secret {
+func dbCredentials(construct constructs.Construct, name string, username string, key awskms.IKey) awssecretsmanager.Secret {
 	secret := awssecretsmanager.NewSecret(
 		construct,
-		pointer.ToString("DBCredentials"),
+		pointer.ToString(name),
 		&awssecretsmanager.SecretProps{
 			GenerateSecretString: &awssecretsmanager.SecretStringGenerator{
-				SecretStringTemplate: pointer.ToString(`{"username": "master"}`),
+				SecretStringTemplate: pointer.ToString(fmt.Sprintf(`{"username": %q}`, username)),
 				GenerateStringKey:    pointer.ToString("password"),
 				PasswordLength:       pointer.ToFloat64(16),
 				ExcludeCharacters:    pointer.ToString("\"@/\\"),
 			},
-			SecretName: awscdk.PhysicalName_GENERATE_IF_NEEDED(),
+			SecretName:    awscdk.PhysicalName_GENERATE_IF_NEEDED(),
+			EncryptionKey: key,
 		},
 	)
 
 	return secret
 }
 
-func credentialsAttachmentToDB(construct constructs.Construct, db awsrds.CfnDBInstance, secret awssecretsmanager.Secret) awssecretsmanager.CfnSecretTargetAttachment {
+func credentialsAttachmentToDB(construct constructs.Construct, name string, db commons.IDatabase, secret awssecretsmanager.Secret) awssecretsmanager.CfnSecretTargetAttachment {
 	attachment := awssecretsmanager.NewCfnSecretTargetAttachment(
 		construct,
-		pointer.ToString("SecretAttachmentToDB"),
+		pointer.ToString(name),
 		&awssecretsmanager.CfnSecretTargetAttachmentProps{
 			SecretId:   secret.SecretArn(),
-			TargetId:   db.Ref(),
-			TargetType: pointer.ToString("AWS::RDS::DBInstance"),
+			TargetId:   db.GetRef(),
+			TargetType: pointer.ToString(db.GetTargetType()),
 		},
 	)
 	return attachment
 }
 
-func dbInstance(construct constructs.Construct, credentials awssecretsmanager.Secret, subnetGroup awsrds.CfnDBSubnetGroup, input PersistenceStackInput) awsrds.CfnDBInstance {
+// credentialsRotation runs the hosted single user Postgres rotation from the private subnet,
+// which reaches the DB through the Fargate security group and Secrets Manager through the NAT.
+func credentialsRotation(construct constructs.Construct, name string, secret awssecretsmanager.Secret, attachment awssecretsmanager.CfnSecretTargetAttachment, rotateImmediately bool, input PersistenceStackInput) awssecretsmanager.RotationSchedule {
+	subnet := awsec2.Subnet_FromSubnetId(construct, pointer.ToString(name+"Subnet"), input.SubnetC.Ref())
+
+	rotation := secret.AddRotationSchedule(
+		pointer.ToString(name),
+		&awssecretsmanager.RotationScheduleOptions{
+			AutomaticallyAfter:        awscdk.Duration_Days(pointer.ToFloat64(float64(input.Config.Persistence.RotationDays))),
+			RotateImmediatelyOnUpdate: pointer.ToBool(rotateImmediately),
+			HostedRotation: awssecretsmanager.HostedRotation_PostgreSqlSingleUser(&awssecretsmanager.SingleUserHostedRotationOptions{
+				Vpc:               input.VPC,
+				VpcSubnets:        &awsec2.SubnetSelection{Subnets: &[]awsec2.ISubnet{subnet}},
+				SecurityGroups:    &[]awsec2.ISecurityGroup{input.FargateSecurityGroup},
+				ExcludeCharacters: pointer.ToString("\"@/\\"),
+			}),
+		},
+	)
+	rotation.Node().AddDependency(attachment)
+
+	return rotation
+}
+
+// serviceDBCredentials holds the password of a per-service DB user. The user itself is created by the
+// MetaflowCoreStack, so the first rotation waits for the schedule instead of running on deploy.
+func serviceDBCredentials(construct constructs.Construct, name string, username string, db commons.IDatabase, key awskms.IKey, input PersistenceStackInput) awssecretsmanager.Secret {
+	secret := dbCredentials(construct, name, username, key)
+	attachment := credentialsAttachmentToDB(construct, name+"Attachment", db, secret)
+	if input.Config.Persistence.RotationDays > 0 {
+		credentialsRotation(construct, name+"Rotation", secret, attachment, false, input)
+	}
+
+	return secret
+}
+
+// dbProxy pools the service connections in front of the database. Every user is IAM-only through the proxy,
+// the Metaflow services get their tokens from the proxyIAMAuth sidecar and the proxy logs in to the database
+// with the user secrets.
+func dbProxy(construct constructs.Construct, db commons.IDatabase, master awssecretsmanager.Secret, metadata awssecretsmanager.Secret, ui awssecretsmanager.Secret, input PersistenceStackInput) awsrds.CfnDBProxy {
+	role := awsiam.NewRole(
+		construct,
+		pointer.ToString("DBProxyRole"),
+		&awsiam.RoleProps{
+			AssumedBy: awsiam.NewServicePrincipal(pointer.ToString("rds.amazonaws.com"), nil),
+		},
+	)
+	for _, secret := range []awssecretsmanager.Secret{master, metadata, ui} {
+		secret.GrantRead(role, nil)
+	}
+	input.DataKey.GrantDecrypt(role)
+
+	// The proxy shares the DB security group, so it has to be able to reach its own members.
+	awsec2.NewCfnSecurityGroupIngress(
+		construct,
+		pointer.ToString("DBProxyToDBIngress"),
+		&awsec2.CfnSecurityGroupIngressProps{
+			GroupId:               input.DBSecurityGroup.SecurityGroupId(),
+			SourceSecurityGroupId: input.DBSecurityGroup.SecurityGroupId(),
+			IpProtocol:            pointer.ToString("tcp"),
+			FromPort:              pointer.ToFloat64(5432),
+			ToPort:                pointer.ToFloat64(5432),
+			Description:           pointer.ToString("Allow access to DB from RDS Proxy"),
+		},
+	)
+
+	auth := func(secret awssecretsmanager.Secret, iamAuth string) *awsrds.CfnDBProxy_AuthFormatProperty {
+		return &awsrds.CfnDBProxy_AuthFormatProperty{
+			AuthScheme:             pointer.ToString("SECRETS"),
+			SecretArn:              secret.SecretArn(),
+			IamAuth:                pointer.ToString(iamAuth),
+			ClientPasswordAuthType: pointer.ToString("POSTGRES_SCRAM_SHA_256"),
+		}
+	}
+
+	proxy := awsrds.NewCfnDBProxy(
+		construct,
+		pointer.ToString("MetaflowDBProxy"),
+		&awsrds.CfnDBProxyProps{
+			DbProxyName:  pointer.ToString("metaflow-db-proxy"),
+			EngineFamily: pointer.ToString("POSTGRESQL"),
+			RoleArn:      role.RoleArn(),
+			RequireTls:   pointer.ToBool(true),
+			Auth: &[]any{
+				auth(master, "REQUIRED"),
+				auth(metadata, "REQUIRED"),
+				auth(ui, "REQUIRED"),
+			},
+			VpcSubnetIds:        &[]any{input.SubnetA.Ref(), input.SubnetB.Ref()},
+			VpcSecurityGroupIds: &[]any{input.DBSecurityGroup.SecurityGroupId()},
+		},
+	)
+
+	targetGroup := &awsrds.CfnDBProxyTargetGroupProps{
+		DbProxyName:     proxy.Ref(),
+		TargetGroupName: pointer.ToString("default"),
+	}
+	if db.GetTargetType() == commons.DBClusterTargetType {
+		targetGroup.DbClusterIdentifiers = &[]any{db.GetRef()}
+	} else {
+		targetGroup.DbInstanceIdentifiers = &[]*string{db.GetRef()}
+	}
+	awsrds.NewCfnDBProxyTargetGroup(
+		construct,
+		pointer.ToString("MetaflowDBProxyTargetGroup"),
+		targetGroup,
+	)
+
+	return proxy
+}
+
+func dbEncryptionKey(construct constructs.Construct) awskms.Key {
+	return awskms.NewKey(
+		construct,
+		pointer.ToString("MetaflowDBKey"),
+		&awskms.KeyProps{
+			Description:       pointer.ToString("Metaflow metadata database storage"),
+			EnableKeyRotation: pointer.ToBool(true),
+			RemovalPolicy:     awscdk.RemovalPolicy_RETAIN,
+		},
+	)
+}
+
+// dbInstance restores the database from SnapshotIdentifier when it is set, the name, the master user and the
+// encryption then come from the snapshot. The password stays the one of the credentials secret the snapshot was
+// taken with.
+func dbInstance(construct constructs.Construct, credentials awssecretsmanager.Secret, subnetGroup awsrds.CfnDBSubnetGroup, key awskms.Key, input PersistenceStackInput) awsrds.CfnDBInstance {
 	usernameToken := credentials.SecretValueFromJson(pointer.ToString("username"))
 	passwordToken := credentials.SecretValueFromJson(pointer.ToString("password"))
+	config := input.Config.Persistence
+
+	props := &awsrds.CfnDBInstanceProps{
+		AllocatedStorage:      pointer.ToString(strconv.Itoa(config.AllocatedStorageGiB)),
+		DbInstanceClass:       pointer.ToString(config.InstanceClass),
+		StorageType:           pointer.ToString(config.StorageType),
+		Engine:                pointer.ToString("postgres"),
+		EngineVersion:         awsrds.PostgresEngineVersion_VER_16_12().PostgresFullVersion(),
+		DbSubnetGroupName:     subnetGroup.Ref(),
+		VpcSecurityGroups:     &[]any{input.DBSecurityGroup.SecurityGroupId()},
+		PubliclyAccessible:    pointer.ToBool(config.PubliclyAccessible),
+		MultiAz:               pointer.ToBool(config.MultiAZ),
+		BackupRetentionPeriod: pointer.ToFloat64(float64(config.BackupRetentionDays)),
+		DeletionProtection:    pointer.ToBool(config.DeletionProtection),
+		CopyTagsToSnapshot:    pointer.ToBool(true),
+	}
+
+	if config.SnapshotIdentifier != "" {
+		props.DbSnapshotIdentifier = pointer.ToString(config.SnapshotIdentifier)
+	} else {
+		props.DbName = pointer.ToString("metaflow")
+		props.DeleteAutomatedBackups = pointer.ToBool(!config.SnapshotOnDelete)
+		props.MasterUsername = usernameToken.UnsafeUnwrap()
+		props.MasterUserPassword = passwordToken.UnsafeUnwrap()
+		props.StorageEncrypted = pointer.ToBool(config.StorageEncrypted)
+		if key != nil {
+			props.KmsKeyId = key.KeyArn()
+		}
+	}
+
+	if config.MaxAllocatedStorageGiB > 0 {
+		props.MaxAllocatedStorage = pointer.ToFloat64(float64(config.MaxAllocatedStorageGiB))
+	}
+
+	if config.PerformanceInsights {
+		props.EnablePerformanceInsights = pointer.ToBool(true)
+		if config.SnapshotIdentifier == "" {
+			props.PerformanceInsightsRetentionPeriod = pointer.ToFloat64(7)
+			if key != nil {
+				props.PerformanceInsightsKmsKeyId = key.KeyArn()
+			}
+		}
+	}
 
 	db := awsrds.NewCfnDBInstance(
 		construct,
 		pointer.ToString("MetaflowDB"),
-		&awsrds.CfnDBInstanceProps{
-			DbName:                 pointer.ToString("metaflow"),
-			AllocatedStorage:       pointer.ToString("20"),
-			DbInstanceClass:        pointer.ToString("db.t3.small"),
-			DeleteAutomatedBackups: pointer.ToBool(true),
-			StorageType:            pointer.ToString("gp2"),
-			Engine:                 pointer.ToString("postgres"),
-			EngineVersion:          awsrds.PostgresEngineVersion_VER_16_12().PostgresFullVersion(),
-			MasterUsername:         usernameToken.UnsafeUnwrap(),
-			MasterUserPassword:     passwordToken.UnsafeUnwrap(),
-			DbSubnetGroupName:      subnetGroup.Ref(),
-			VpcSecurityGroups:      &[]any{input.DBSecurityGroup.SecurityGroupId()},
-			PubliclyAccessible:     pointer.ToBool(true),
-		},
+		props,
 	)
+	retainOnReplace(db, config)
+
 	return db
 }
 
-func bucket(scope constructs.Construct) awss3.Bucket {
+// retainOnReplace keeps the database when a change replaces it, such as turning on storage encryption. The new
+// database starts empty and the old one has to be deleted by hand once its data is restored.
+func retainOnReplace(db awscdk.CfnResource, config commons.PersistenceConfig) {
+	if config.SnapshotOnDelete {
+		db.ApplyRemovalPolicy(awscdk.RemovalPolicy_SNAPSHOT, nil)
+	}
+	db.CfnOptions().SetUpdateReplacePolicy(awscdk.CfnDeletionPolicy_RETAIN)
+}
+
+// auroraServerlessCluster scales between the configured ACUs and adds a reader in a second AZ when MultiAZ is set.
+// It returns the cluster and the instance created as its writer. The writer has the highest promotion tier, but
+// after a failover the reader is the writer, so consumers must use the cluster endpoint and identifier.
+func auroraServerlessCluster(construct constructs.Construct, credentials awssecretsmanager.Secret, subnetGroup awsrds.CfnDBSubnetGroup, key awskms.Key, input PersistenceStackInput) (awsrds.CfnDBCluster, awsrds.CfnDBInstance) {
+	usernameToken := credentials.SecretValueFromJson(pointer.ToString("username"))
+	passwordToken := credentials.SecretValueFromJson(pointer.ToString("password"))
+	config := input.Config.Persistence
+
+	props := &awsrds.CfnDBClusterProps{
+		DatabaseName:          pointer.ToString("metaflow"),
+		Engine:                pointer.ToString("aurora-postgresql"),
+		EngineVersion:         awsrds.AuroraPostgresEngineVersion_VER_16_6().AuroraPostgresFullVersion(),
+		Port:                  pointer.ToFloat64(5432),
+		MasterUsername:        usernameToken.UnsafeUnwrap(),
+		MasterUserPassword:    passwordToken.UnsafeUnwrap(),
+		DbSubnetGroupName:     subnetGroup.Ref(),
+		VpcSecurityGroupIds:   &[]any{input.DBSecurityGroup.SecurityGroupId()},
+		StorageEncrypted:      pointer.ToBool(config.StorageEncrypted),
+		BackupRetentionPeriod: pointer.ToFloat64(float64(max(config.BackupRetentionDays, 1))),
+		DeletionProtection:    pointer.ToBool(config.DeletionProtection),
+		CopyTagsToSnapshot:    pointer.ToBool(true),
+		ServerlessV2ScalingConfiguration: &awsrds.CfnDBCluster_ServerlessV2ScalingConfigurationProperty{
+			MinCapacity: pointer.ToFloat64(config.MinCapacityACU),
+			MaxCapacity: pointer.ToFloat64(config.MaxCapacityACU),
+		},
+	}
+
+	if key != nil {
+		props.KmsKeyId = key.KeyArn()
+	}
+
+	cluster := awsrds.NewCfnDBCluster(
+		construct,
+		pointer.ToString("MetaflowDBCluster"),
+		props,
+	)
+
+	retainOnReplace(cluster, config)
+
+	// The promotion tier is the position in the list, Aurora promotes the reader when the writer fails.
+	instances := []string{"MetaflowDBWriter"}
+	if config.MultiAZ {
+		instances = append(instances, "MetaflowDBReader")
+	}
+
+	var writer awsrds.CfnDBInstance
+	for tier, name := range instances {
+		instanceProps := &awsrds.CfnDBInstanceProps{
+			DbClusterIdentifier: cluster.Ref(),
+			DbInstanceClass:     pointer.ToString("db.serverless"),
+			Engine:              pointer.ToString("aurora-postgresql"),
+			PubliclyAccessible:  pointer.ToBool(config.PubliclyAccessible),
+			PromotionTier:       pointer.ToFloat64(float64(tier)),
+		}
+		if config.PerformanceInsights {
+			instanceProps.EnablePerformanceInsights = pointer.ToBool(true)
+			instanceProps.PerformanceInsightsRetentionPeriod = pointer.ToFloat64(7)
+			if key != nil {
+				instanceProps.PerformanceInsightsKmsKeyId = key.KeyArn()
+			}
+		}
+
+		instance := awsrds.NewCfnDBInstance(
+			construct,
+			pointer.ToString(name),
+			instanceProps,
+		)
+		if writer == nil {
+			writer = instance
+		}
+	}
+
+	return cluster, writer
+}
+
+func bucket(scope constructs.Construct, config commons.DatastoreConfig, key awskms.Key, replicationRules *[]*awss3.ReplicationRule) awss3.Bucket {
+	removalPolicy := awscdk.RemovalPolicy_DESTROY
+	if config.Retained() {
+		removalPolicy = awscdk.RemovalPolicy_RETAIN
+	}
+
 	bucket := awss3.NewBucket(
 		scope,
 		pointer.ToString("MetaflowBucket"),
 		&awss3.BucketProps{
-			AccessControl: awss3.BucketAccessControl_PRIVATE,
-			Encryption:    awss3.BucketEncryption_S3_MANAGED,
+			AccessControl:    awss3.BucketAccessControl_PRIVATE,
+			Encryption:       awss3.BucketEncryption_KMS,
+			EncryptionKey:    key,
+			BucketKeyEnabled: pointer.ToBool(true),
 			BlockPublicAccess: awss3.NewBlockPublicAccess(
 				&awss3.BlockPublicAccessOptions{
 					BlockPublicAcls:       pointer.ToBool(true),
@@ -144,17 +457,76 @@ func bucket(scope constructs.Construct) awss3.Bucket {
 					RestrictPublicBuckets: pointer.ToBool(true),
 				},
 			),
-			AutoDeleteObjects: pointer.ToBool(true),
-			RemovalPolicy:     awscdk.RemovalPolicy_DESTROY,
+			Versioned:         pointer.ToBool(config.IsVersioned() || replicationRules != nil),
+			LifecycleRules:    bucketLifecycleRules(config),
+			ReplicationRules:  replicationRules,
+			AutoDeleteObjects: pointer.ToBool(!config.Retained()),
+			RemovalPolicy:     removalPolicy,
 		},
 	)
 
-	bucket.ApplyRemovalPolicy(awscdk.RemovalPolicy_DESTROY)
+	bucket.ApplyRemovalPolicy(removalPolicy)
 
 	return bucket
 }
 
-func graphStateDB(scope constructs.Construct) awsdynamodb.CfnGlobalTable {
+func bucketLifecycleRules(config commons.DatastoreConfig) *[]*awss3.LifecycleRule {
+	rules := []*awss3.LifecycleRule{}
+
+	if config.AbortIncompleteMultipartUploadDays > 0 {
+		rules = append(rules, &awss3.LifecycleRule{
+			Id:                                  pointer.ToString("AbortIncompleteMultipartUploads"),
+			AbortIncompleteMultipartUploadAfter: awscdk.Duration_Days(pointer.ToFloat64(float64(config.AbortIncompleteMultipartUploadDays))),
+		})
+	}
+
+	if config.IsVersioned() && config.NoncurrentVersionExpirationDays > 0 {
+		rules = append(rules, &awss3.LifecycleRule{
+			Id:                          pointer.ToString("ExpireNoncurrentVersions"),
+			NoncurrentVersionExpiration: awscdk.Duration_Days(pointer.ToFloat64(float64(config.NoncurrentVersionExpirationDays))),
+		})
+	}
+
+	if config.TransitionAfterDays > 0 || config.ExpirationDays > 0 {
+		rule := &awss3.LifecycleRule{
+			Id:     pointer.ToString("ArtifactsRetention"),
+			Prefix: pointer.ToString(config.Prefix),
+		}
+		if config.TransitionAfterDays > 0 {
+			rule.Transitions = &[]*awss3.Transition{
+				{
+					StorageClass:    awss3.NewStorageClass(pointer.ToString(config.StorageClass)),
+					TransitionAfter: awscdk.Duration_Days(pointer.ToFloat64(float64(config.TransitionAfterDays))),
+				},
+			}
+		}
+		if config.ExpirationDays > 0 {
+			rule.Expiration = awscdk.Duration_Days(pointer.ToFloat64(float64(config.ExpirationDays)))
+		}
+		rules = append(rules, rule)
+	}
+
+	return &rules
+}
+
+func graphStateDB(scope constructs.Construct, key awskms.Key, input PersistenceStackInput) awsdynamodb.CfnGlobalTable {
+	replicas := []any{
+		awsdynamodb.CfnGlobalTable_ReplicaSpecificationProperty{
+			Region: pointer.ToString(input.Account.Region),
+			SseSpecification: &awsdynamodb.CfnGlobalTable_ReplicaSSESpecificationProperty{
+				KmsMasterKeyId: key.KeyArn(),
+			},
+		},
+	}
+	if input.DRStack != nil {
+		replicas = append(replicas, awsdynamodb.CfnGlobalTable_ReplicaSpecificationProperty{
+			Region: pointer.ToString(input.Config.DisasterRecovery.SecondaryRegion),
+			SseSpecification: &awsdynamodb.CfnGlobalTable_ReplicaSSESpecificationProperty{
+				KmsMasterKeyId: input.DRKey.KeyArn(),
+			},
+		})
+	}
+
 	table := awsdynamodb.NewCfnGlobalTable(
 		scope,
 		pointer.ToString("StepFunctionsStateDDB"),
@@ -166,7 +538,7 @@ func graphStateDB(scope constructs.Construct) awsdynamodb.CfnGlobalTable {
 					AttributeType: pointer.ToString("S"),
 				},
 			},
-			TableName: pointer.ToString("MetaflowStepFunctionsState"),
+			TableName: pointer.ToString(commons.StateTableName),
 			KeySchema: []interface{}{
 				awsdynamodb.CfnGlobalTable_KeySchemaProperty{
 					AttributeName: pointer.ToString("pathspec"),
@@ -177,12 +549,94 @@ func graphStateDB(scope constructs.Construct) awsdynamodb.CfnGlobalTable {
 				AttributeName: pointer.ToString("ttl"),
 				Enabled:       pointer.ToBool(true),
 			},
-			Replicas: []any{
-				awsdynamodb.CfnGlobalTable_ReplicaSpecificationProperty{
-					Region: pointer.ToString("us-east-2"),
-				},
+			SseSpecification: &awsdynamodb.CfnGlobalTable_SSESpecificationProperty{
+				SseEnabled: pointer.ToBool(true),
+				SseType:    pointer.ToString("KMS"),
 			},
+			Replicas: replicas,
 		},
 	)
 	return table
 }
+
+// datastoreReplicationRules copies every object of the datastore to the DR replica bucket. Replication
+// metrics are enabled so the lag shows up in CloudWatch for `dr status`.
+func datastoreReplicationRules(input PersistenceStackInput) *[]*awss3.ReplicationRule {
+	return &[]*awss3.ReplicationRule{
+		{
+			Id:                      pointer.ToString(commons.DRReplicationRuleId),
+			Destination:             input.DRReplicaBucket,
+			KmsKey:                  input.DRKey,
+			SseKmsEncryptedObjects:  pointer.ToBool(true),
+			DeleteMarkerReplication: pointer.ToBool(true),
+			Metrics:                 awss3.ReplicationTimeValue_FIFTEEN_MINUTES(),
+			Priority:                pointer.ToFloat64(0),
+		},
+	}
+}
+
+// snapshotCopies backs the database up daily and copies each recovery point to the DR vault.
+func snapshotCopies(construct constructs.Construct, db commons.IDatabase, input PersistenceStackInput) awsbackup.BackupPlan {
+	retention := awscdk.Duration_Days(pointer.ToFloat64(float64(input.Config.DisasterRecovery.SnapshotRetentionDays)))
+
+	vault := awsbackup.NewBackupVault(
+		construct,
+		pointer.ToString("MetaflowBackupVault"),
+		&awsbackup.BackupVaultProps{
+			BackupVaultName: pointer.ToString("metaflow"),
+			EncryptionKey:   input.DataKey,
+			RemovalPolicy:   awscdk.RemovalPolicy_RETAIN,
+		},
+	)
+
+	plan := awsbackup.NewBackupPlan(
+		construct,
+		pointer.ToString("MetaflowDBBackupPlan"),
+		&awsbackup.BackupPlanProps{
+			BackupPlanName: pointer.ToString("metaflow-db"),
+			BackupVault:    vault,
+			BackupPlanRules: &[]awsbackup.BackupPlanRule{
+				awsbackup.NewBackupPlanRule(&awsbackup.BackupPlanRuleProps{
+					RuleName:           pointer.ToString("DailyWithDRCopy"),
+					ScheduleExpression: awsevents.Schedule_Cron(&awsevents.CronOptions{Hour: pointer.ToString("3"), Minute: pointer.ToString("0")}),
+					DeleteAfter:        retention,
+					CopyActions: &[]*awsbackup.BackupPlanCopyActionProps{
+						{
+							DestinationBackupVault: input.DRBackupVault,
+							DeleteAfter:            retention,
+						},
+					},
+				}),
+			},
+		},
+	)
+
+	plan.AddSelection(
+		pointer.ToString("MetaflowDB"),
+		&awsbackup.BackupSelectionOptions{
+			Resources: &[]awsbackup.BackupResource{
+				awsbackup.BackupResource_FromArn(db.GetArn()),
+			},
+		},
+	)
+
+	return plan
+}
+
+func drOutputs(stack awscdk.Stack, bucket awss3.Bucket, input PersistenceStackInput) {
+	outputs := map[string]*string{
+		"METAFLOW_DR_REGION":         pointer.ToString(input.Config.DisasterRecovery.SecondaryRegion),
+		"METAFLOW_DR_SOURCE_BUCKET":  bucket.BucketName(),
+		"METAFLOW_DR_REPLICA_BUCKET": input.DRReplicaBucket.BucketName(),
+		"METAFLOW_DR_BACKUP_VAULT":   pointer.ToString(commons.DRBackupVaultName),
+	}
+	for name, value := range outputs {
+		awscdk.NewCfnOutput(
+			stack, pointer.ToString(name),
+			&awscdk.CfnOutputProps{
+				Value:       value,
+				Description: pointer.ToString(name),
+			},
+		)
+	}
+}
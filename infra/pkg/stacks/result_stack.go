@@ -9,8 +9,12 @@ import (
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsapigateway"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsbatch"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsdynamodb"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsecs"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseksv2"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awselasticloadbalancingv2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsevents"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awslogs"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awss3"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awssagemaker"
 	"go.uber.org/fx"
@@ -19,6 +23,7 @@ import (
 type ResultStackInput struct {
 	fx.In
 	Account            commons.Account
+	Config             commons.Config
 	MetaflowBucket     awss3.Bucket                              `name:"s3_bucket"`
 	JobQueue           awsbatch.CfnJobQueue                      `name:"batch_job_queue"`
 	ApiGateway         awsapigateway.RestApi                     `name:"api_gateway"`
@@ -29,6 +34,13 @@ type ResultStackInput struct {
 	EventBridgeRole    awsiam.Role                               `name:"event_bridge_role"`
 	StepFunctionsRole  awsiam.Role                               `name:"step_functions_role"`
 	StateDDB           awsdynamodb.CfnGlobalTable                `name:"state_ddb"`
+	ExecutionLogGroup  awslogs.LogGroup                          `name:"sfn_execution_log_group"`
+	EventBus           awsevents.EventBus                        `name:"metaflow_event_bus"`
+	EKSCluster         awseksv2.Cluster                          `name:"eks_cluster" optional:"true"`
+	ECSCluster         awsecs.Cluster                            `name:"ecs_cluster"`
+	MainService        awsecs.CfnService                         `name:"main_metaflow_service"`
+	ComputeEnv         awsbatch.CfnComputeEnvironment            `name:"batch_compute_environment"`
+	Database           commons.IDatabase                         `name:"database"`
 }
 
 type ResultStackOutput struct {
@@ -45,12 +57,15 @@ type ResultStackOutput struct {
 	StepFunctionRoleARN   awscdk.CfnOutput `name:"step_functions_role_arn"`
 	StepFunctionsDDBARN   awscdk.CfnOutput `name:"step_functions_ddb_arn"`
 	BatchExecutionRoleARN awscdk.CfnOutput `name:"batch_execution_role_arn"`
+	StateMachinePrefix    awscdk.CfnOutput `name:"state_machine_prefix"`
+	ExecutionLogGroupARN  awscdk.CfnOutput `name:"sfn_execution_log_group_arn"`
+	EventBusName          awscdk.CfnOutput `name:"event_bus_name"`
 }
 
 func BuildResultStack(in ResultStackInput) ResultStackOutput {
 	stack := awscdk.NewStack(
 		in.Account.App,
-		pointer.ToString("ResultStack"),
+		pointer.ToString(commons.ResultStackName),
 		&awscdk.StackProps{
 			Env: in.Account.Env(),
 		},
@@ -144,6 +159,36 @@ func BuildResultStack(in ResultStackInput) ResultStackOutput {
 		},
 	)
 
+	stateMachinePrefix := awscdk.NewCfnOutput(
+		stack, pointer.ToString("METAFLOW_SFN_STATE_MACHINE_PREFIX"),
+		&awscdk.CfnOutputProps{
+			Value:       pointer.ToString(in.Config.Scheduling.StateMachinePrefix),
+			Description: pointer.ToString("METAFLOW_SFN_STATE_MACHINE_PREFIX"),
+		},
+	)
+
+	executionLogGroupARN := awscdk.NewCfnOutput(
+		stack, pointer.ToString("METAFLOW_SFN_EXECUTION_LOG_GROUP_ARN"),
+		&awscdk.CfnOutputProps{
+			Value:       in.ExecutionLogGroup.LogGroupArn(),
+			Description: pointer.ToString("METAFLOW_SFN_EXECUTION_LOG_GROUP_ARN"),
+		},
+	)
+
+	// Metaflow has no event bus setting, the bus name is a plain output for whoever sends the trigger events.
+	eventBusName := awscdk.NewCfnOutput(
+		stack, pointer.ToString("EVENT_BUS_NAME"),
+		&awscdk.CfnOutputProps{
+			Value:       in.EventBus.EventBusName(),
+			Description: pointer.ToString("EVENT_BUS_NAME"),
+		},
+	)
+
+	if in.EKSCluster != nil {
+		kubernetesOutputs(stack)
+	}
+	operationsOutputs(stack, in)
+
 	out := ResultStackOutput{
 		Stack:                 stack,
 		MetaflowDataStoreURL:  metaflowDataStoreURL,
@@ -157,7 +202,62 @@ func BuildResultStack(in ResultStackInput) ResultStackOutput {
 		StepFunctionRoleARN:   stepFunctionsRole,
 		StepFunctionsDDBARN:   stepFunctionsDDBARN,
 		BatchExecutionRoleARN: batchExecutionRole,
+		StateMachinePrefix:    stateMachinePrefix,
+		ExecutionLogGroupARN:  executionLogGroupARN,
+		EventBusName:          eventBusName,
 	}
 
 	return out
 }
+
+// operationsOutputs name the resources the CLI checks and operates, they are not Metaflow settings.
+func operationsOutputs(stack awscdk.Stack, in ResultStackInput) {
+	outputs := []struct {
+		name  string
+		value *string
+	}{
+		{"METADATA_ECS_CLUSTER", in.ECSCluster.ClusterName()},
+		{"METADATA_ECS_SERVICE", in.MainService.AttrName()},
+		{"METADATA_DB_IDENTIFIER", in.Database.GetRef()},
+		{"METADATA_DB_TYPE", pointer.ToString(in.Database.GetTargetType())},
+		{"BATCH_COMPUTE_ENVIRONMENT", in.ComputeEnv.Ref()},
+		{"NOTEBOOK_INSTANCE_NAME", in.NotebookInstance.AttrNotebookInstanceName()},
+	}
+
+	for _, output := range outputs {
+		awscdk.NewCfnOutput(
+			stack, pointer.ToString(output.name),
+			&awscdk.CfnOutputProps{
+				Value:       output.value,
+				Description: pointer.ToString(output.name),
+			},
+		)
+	}
+}
+
+// kubernetesOutputs exports the settings of `@kubernetes` and `argo-workflows create`, the webhook is only
+// reachable inside the cluster.
+func kubernetesOutputs(stack awscdk.Stack) {
+	outputs := [][2]string{
+		{"METAFLOW_KUBERNETES_NAMESPACE", commons.KubernetesNamespace},
+		{"METAFLOW_KUBERNETES_SERVICE_ACCOUNT", commons.KubernetesServiceAccount},
+		{"METAFLOW_ARGO_EVENTS_EVENT_BUS", commons.ArgoEventsEventBus},
+		{"METAFLOW_ARGO_EVENTS_EVENT_SOURCE", commons.ArgoEventsEventSource},
+		{"METAFLOW_ARGO_EVENTS_EVENT", commons.ArgoEventsEvent},
+		{"METAFLOW_ARGO_EVENTS_SERVICE_ACCOUNT", commons.ArgoEventsServiceAccount},
+		{"METAFLOW_ARGO_EVENTS_INTERNAL_WEBHOOK_URL", fmt.Sprintf(
+			"http://%s-eventsource-svc.%s:%d/%s",
+			commons.ArgoEventsEventSource, commons.KubernetesNamespace, commons.ArgoEventsWebhookPort, commons.ArgoEventsEvent,
+		)},
+	}
+
+	for _, output := range outputs {
+		awscdk.NewCfnOutput(
+			stack, pointer.ToString(output[0]),
+			&awscdk.CfnOutputProps{
+				Value:       pointer.ToString(output[1]),
+				Description: pointer.ToString(output[0]),
+			},
+		)
+	}
+}
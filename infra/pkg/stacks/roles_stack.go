@@ -9,6 +9,7 @@ import (
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsbatch"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsdynamodb"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awskms"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awss3"
 	"github.com/aws/constructs-go/constructs/v10"
 	"go.uber.org/fx"
@@ -20,6 +21,7 @@ type RolesStackInput struct {
 	MetaflowBucket awss3.Bucket               `name:"s3_bucket"`
 	JobQueue       awsbatch.CfnJobQueue       `name:"batch_job_queue"`
 	StateDDB       awsdynamodb.CfnGlobalTable `name:"state_ddb"`
+	DataKey        awskms.Key                 `name:"data_key"`
 }
 
 type RolesStackOutput struct {
@@ -35,7 +37,7 @@ type RolesStackOutput struct {
 func BuildRolesStack(in RolesStackInput) RolesStackOutput {
 	stack := awscdk.NewStack(
 		in.Account.App,
-		pointer.ToString("RolesStack"),
+		pointer.ToString(commons.RolesStackName),
 		&awscdk.StackProps{
 			Env: in.Account.Env(),
 		},
@@ -139,9 +141,10 @@ func buildMetaflowUserRole(construct constructs.Construct, input RolesStackInput
 				Actions: &[]*string{
 					pointer.ToString("kms:Decrypt"),
 					pointer.ToString("kms:Encrypt"),
+					pointer.ToString("kms:GenerateDataKey*"),
 				},
 				Resources: &[]*string{
-					pointer.ToString(fmt.Sprintf("arn:aws:kms:%[1]s:%[2]s:key/", input.Account.Region, input.Account.AccountId)),
+					input.DataKey.KeyArn(),
 				},
 			},
 		),
@@ -174,9 +177,12 @@ func buildMetaflowUserRole(construct constructs.Construct, input RolesStackInput
 				Effect: awsiam.Effect_ALLOW,
 				Actions: &[]*string{
 					pointer.ToString("batch:SubmitJob"),
+					// Metaflow tags the jobs it submits with their pathspec.
+					pointer.ToString("batch:TagResource"),
 				},
 				Resources: &[]*string{
 					pointer.ToString(fmt.Sprintf("arn:aws:batch:%[1]s:%[2]s:job-definition/*:*", input.Account.Region, input.Account.AccountId)),
+					pointer.ToString(fmt.Sprintf("arn:aws:batch:%[1]s:%[2]s:job/*", input.Account.Region, input.Account.AccountId)),
 					input.JobQueue.Ref(),
 				},
 			},
@@ -319,6 +325,7 @@ func buildStepFunctionsRole(construct constructs.Construct, input RolesStackInpu
 			RoleName:  pointer.ToString("StepFunctionsRole"),
 		},
 	)
+	input.DataKey.GrantEncryptDecrypt(role)
 
 	role.AddToPolicy(
 		awsiam.NewPolicyStatement(
@@ -346,9 +353,11 @@ func buildStepFunctionsRole(construct constructs.Construct, input RolesStackInpu
 				Sid:    pointer.ToString("DefinitionsPermissions"),
 				Actions: &[]*string{
 					pointer.ToString("batch:SubmitJob"),
+					pointer.ToString("batch:TagResource"),
 				},
 				Resources: &[]*string{
 					pointer.ToString(fmt.Sprintf("arn:aws:batch:%[1]s:%[2]s:job-definition/*:*", input.Account.Region, input.Account.AccountId)),
+					pointer.ToString(fmt.Sprintf("arn:aws:batch:%[1]s:%[2]s:job/*", input.Account.Region, input.Account.AccountId)),
 					input.JobQueue.Ref(),
 				},
 			},
@@ -438,57 +447,10 @@ func buildBatchS3Role(construct constructs.Construct, input RolesStackInput) aws
 			RoleName:  pointer.ToString("BatchS3Role"),
 		},
 	)
-	role.AddToPolicy(
-		awsiam.NewPolicyStatement(
-			&awsiam.PolicyStatementProps{
-				Effect: awsiam.Effect_ALLOW,
-				Sid:    pointer.ToString("BucketAccessBatch"),
-				Actions: &[]*string{
-					pointer.ToString("s3:ListBucket"),
-				},
-				Resources: &[]*string{
-					input.MetaflowBucket.BucketArn(),
-				},
-			},
-		),
-	)
-
-	role.AddToPolicy(
-		awsiam.NewPolicyStatement(
-			&awsiam.PolicyStatementProps{
-				Effect: awsiam.Effect_ALLOW,
-				Sid:    pointer.ToString("ObjectAccessBatch"),
-				Actions: &[]*string{
-					pointer.ToString("s3:PutObject"),
-					pointer.ToString("s3:GetObject"),
-					pointer.ToString("s3:DeleteObject"),
-				},
-				Resources: &[]*string{
-					input.MetaflowBucket.ArnForObjects(pointer.ToString("*")),
-				},
-			},
-		),
-	)
-
-	role.AddToPolicy(
-		awsiam.NewPolicyStatement(
-			&awsiam.PolicyStatementProps{
-				Effect: awsiam.Effect_DENY,
-				Sid:    pointer.ToString("DenyPresignedBatch"),
-				Actions: &[]*string{
-					pointer.ToString("s3:*"),
-				},
-				Resources: &[]*string{
-					pointer.ToString("*"),
-				},
-				Conditions: &map[string]interface{}{
-					"StringNotEquals": map[string]*string{
-						"s3:authType": pointer.ToString("REST-HEADER"),
-					},
-				},
-			},
-		),
-	)
+	input.DataKey.GrantEncryptDecrypt(role)
+	for _, statement := range jobDataStatements(input.Account, input.MetaflowBucket, input.StateDDB) {
+		role.AddToPolicy(statement)
+	}
 
 	role.AddToPolicy(
 		awsiam.NewPolicyStatement(
@@ -532,23 +494,6 @@ func buildBatchS3Role(construct constructs.Construct, input RolesStackInput) aws
 		),
 	)
 
-	role.AddToPolicy(
-		awsiam.NewPolicyStatement(
-			&awsiam.PolicyStatementProps{
-				Effect: awsiam.Effect_ALLOW,
-				Sid:    pointer.ToString("Items"),
-				Actions: &[]*string{
-					pointer.ToString("dynamodb:PutItem"),
-					pointer.ToString("dynamodb:GetItem"),
-					pointer.ToString("dynamodb:UpdateItem"),
-				},
-				Resources: &[]*string{
-					pointer.ToString(fmt.Sprintf("arn:aws:dynamodb:%[1]s:%[2]s:table/%[3]s", input.Account.Region, input.Account.AccountId, *input.StateDDB.TableName())),
-				},
-			},
-		),
-	)
-
 	role.AddToPolicy(
 		awsiam.NewPolicyStatement(
 			&awsiam.PolicyStatementProps{
@@ -633,9 +578,10 @@ func buildMetaflowUserPolicy(construct constructs.Construct, input RolesStackInp
 						Actions: &[]*string{
 							pointer.ToString("kms:Decrypt"),
 							pointer.ToString("kms:Encrypt"),
+							pointer.ToString("kms:GenerateDataKey*"),
 						},
 						Resources: &[]*string{
-							pointer.ToString(fmt.Sprintf("arn:aws:kms:%[1]s:%[2]s:key/", input.Account.Region, input.Account.AccountId)),
+							input.DataKey.KeyArn(),
 						},
 					},
 				),
@@ -662,9 +608,11 @@ func buildMetaflowUserPolicy(construct constructs.Construct, input RolesStackInp
 						Effect: awsiam.Effect_ALLOW,
 						Actions: &[]*string{
 							pointer.ToString("batch:SubmitJob"),
+							pointer.ToString("batch:TagResource"),
 						},
 						Resources: &[]*string{
 							pointer.ToString(fmt.Sprintf("arn:aws:batch:%[1]s:%[2]s:job-definition/*:*", input.Account.Region, input.Account.AccountId)),
+							pointer.ToString(fmt.Sprintf("arn:aws:batch:%[1]s:%[2]s:job/*", input.Account.Region, input.Account.AccountId)),
 							input.JobQueue.Ref(),
 						},
 					},
@@ -766,3 +714,67 @@ func buildMetaflowUserPolicy(construct constructs.Construct, input RolesStackInp
 	policy.ApplyRemovalPolicy(awscdk.RemovalPolicy_RETAIN_ON_UPDATE_OR_DELETE)
 	return policy
 }
+
+// jobDataStatements covers the datastore and Step Functions state access of the Metaflow jobs, shared by
+// the Batch role and the Kubernetes service account.
+func jobDataStatements(account commons.Account, bucket awss3.Bucket, stateDDB awsdynamodb.CfnGlobalTable) []awsiam.PolicyStatement {
+	return []awsiam.PolicyStatement{
+		awsiam.NewPolicyStatement(
+			&awsiam.PolicyStatementProps{
+				Effect: awsiam.Effect_ALLOW,
+				Sid:    pointer.ToString("BucketAccessBatch"),
+				Actions: &[]*string{
+					pointer.ToString("s3:ListBucket"),
+				},
+				Resources: &[]*string{
+					bucket.BucketArn(),
+				},
+			},
+		),
+		awsiam.NewPolicyStatement(
+			&awsiam.PolicyStatementProps{
+				Effect: awsiam.Effect_ALLOW,
+				Sid:    pointer.ToString("ObjectAccessBatch"),
+				Actions: &[]*string{
+					pointer.ToString("s3:PutObject"),
+					pointer.ToString("s3:GetObject"),
+					pointer.ToString("s3:DeleteObject"),
+				},
+				Resources: &[]*string{
+					bucket.ArnForObjects(pointer.ToString("*")),
+				},
+			},
+		),
+		awsiam.NewPolicyStatement(
+			&awsiam.PolicyStatementProps{
+				Effect: awsiam.Effect_DENY,
+				Sid:    pointer.ToString("DenyPresignedBatch"),
+				Actions: &[]*string{
+					pointer.ToString("s3:*"),
+				},
+				Resources: &[]*string{
+					pointer.ToString("*"),
+				},
+				Conditions: &map[string]interface{}{
+					"StringNotEquals": map[string]*string{
+						"s3:authType": pointer.ToString("REST-HEADER"),
+					},
+				},
+			},
+		),
+		awsiam.NewPolicyStatement(
+			&awsiam.PolicyStatementProps{
+				Effect: awsiam.Effect_ALLOW,
+				Sid:    pointer.ToString("Items"),
+				Actions: &[]*string{
+					pointer.ToString("dynamodb:PutItem"),
+					pointer.ToString("dynamodb:GetItem"),
+					pointer.ToString("dynamodb:UpdateItem"),
+				},
+				Resources: &[]*string{
+					pointer.ToString(fmt.Sprintf("arn:aws:dynamodb:%[1]s:%[2]s:table/%[3]s", account.Region, account.AccountId, *stateDDB.TableName())),
+				},
+			},
+		),
+	}
+}
@@ -0,0 +1,49 @@
+package stacks
+
+import (
+	"github.com/AlekSi/pointer"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsecs"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsevents"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseventstargets"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssecretsmanager"
+	"github.com/aws/constructs-go/constructs/v10"
+)
+
+// redeployOnRotation forces a new deployment of the service once Secrets Manager finishes rotating
+// the secret, tasks only read their secrets at start so running ones would keep the old password.
+func redeployOnRotation(construct constructs.Construct, name string, secret awssecretsmanager.Secret, cluster awsecs.Cluster, service awsecs.CfnService) awsevents.Rule {
+	rule := awsevents.NewRule(
+		construct,
+		pointer.ToString(name),
+		&awsevents.RuleProps{
+			Description: pointer.ToString("Redeploy the service after the DB credentials rotate"),
+			EventPattern: &awsevents.EventPattern{
+				Source:     &[]*string{pointer.ToString("aws.secretsmanager")},
+				DetailType: &[]*string{pointer.ToString("AWS Service Event via CloudTrail")},
+				Detail: &map[string]any{
+					"eventName": []string{"RotationSucceeded"},
+					"additionalEventData": map[string]any{
+						"SecretId": []*string{secret.SecretArn()},
+					},
+				},
+			},
+		},
+	)
+
+	rule.AddTarget(awseventstargets.NewAwsApi(&awseventstargets.AwsApiProps{
+		Service: pointer.ToString("ECS"),
+		Action:  pointer.ToString("updateService"),
+		Parameters: map[string]any{
+			"cluster":            cluster.ClusterArn(),
+			"service":            service.AttrName(),
+			"forceNewDeployment": true,
+		},
+		PolicyStatement: awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Actions:   &[]*string{pointer.ToString("ecs:UpdateService")},
+			Resources: &[]*string{service.Ref()},
+		}),
+	}))
+
+	return rule
+}
@@ -0,0 +1,210 @@
+package stacks
+
+import (
+	"fmt"
+
+	"github.com/AlekSi/pointer"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awscloudwatch"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awscloudwatchactions"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsevents"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseventstargets"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awskms"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awslogs"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awss3"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssns"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssnssubscriptions"
+	"github.com/aws/constructs-go/constructs/v10"
+	"go.uber.org/fx"
+)
+
+type SchedulingStackInput struct {
+	fx.In
+	Account         commons.Account
+	Config          commons.Config
+	DataKey         awskms.Key   `name:"data_key"`
+	LogsKey         awskms.Key   `name:"logs_key"`
+	MetaflowBucket  awss3.Bucket `name:"s3_bucket"`
+	EventBridgeRole awsiam.Role  `name:"event_bridge_role"`
+}
+
+type SchedulingStackOutput struct {
+	fx.Out
+	Stack             awscdk.Stack        `group:"stacks"`
+	FailuresTopic     awssns.Topic        `name:"sfn_failures_topic"`
+	FailuresRule      awsevents.Rule      `name:"sfn_failures_rule"`
+	FailuresAlarm     awscloudwatch.Alarm `name:"sfn_failures_alarm"`
+	EventBus          awsevents.EventBus  `name:"metaflow_event_bus"`
+	ExecutionLogGroup awslogs.LogGroup    `name:"sfn_execution_log_group"`
+}
+
+// BuildSchedulingStack backs the flows deployed with `step-functions create`: Metaflow creates the state
+// machines and their schedules itself, this stack reports their failures, holds the execution logs and
+// starts the machines on data arrival.
+func BuildSchedulingStack(in SchedulingStackInput) SchedulingStackOutput {
+	stack := awscdk.NewStack(
+		in.Account.App,
+		pointer.ToString(commons.SchedulingStackName),
+		&awscdk.StackProps{
+			Env: in.Account.Env(),
+		},
+	)
+
+	topic := failuresTopic(stack, in)
+	rule := failuresRule(stack, topic, in)
+	alarm := failuresAlarm(stack, topic, rule)
+
+	for _, trigger := range in.Config.Scheduling.Triggers {
+		NewDataArrivalTrigger(stack, trigger.Name+"Trigger", &DataArrivalTriggerProps{
+			Trigger: trigger,
+			Account: in.Account,
+			Bucket:  in.MetaflowBucket,
+			Role:    in.EventBridgeRole,
+		})
+	}
+
+	eventBus := awsevents.NewEventBus(
+		stack,
+		pointer.ToString("MetaflowEventBus"),
+		&awsevents.EventBusProps{
+			EventBusName: pointer.ToString("metaflow"),
+			Description:  pointer.ToString("Events published for Metaflow flows"),
+		},
+	)
+
+	// The vendedlogs prefix keeps Step Functions from growing the account wide log resource policy.
+	logGroup := awslogs.NewLogGroup(
+		stack,
+		pointer.ToString("ExecutionLogGroup"),
+		&awslogs.LogGroupProps{
+			LogGroupName:  pointer.ToString(fmt.Sprintf("/aws/vendedlogs/states/%s", in.Config.Scheduling.StateMachinePrefix)),
+			Retention:     awslogs.RetentionDays_THREE_MONTHS,
+			RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
+			EncryptionKey: in.LogsKey,
+		},
+	)
+
+	awscdk.NewCfnOutput(
+		stack, pointer.ToString("METAFLOW_SFN_FAILURES_TOPIC"),
+		&awscdk.CfnOutputProps{
+			Value:       topic.TopicArn(),
+			Description: pointer.ToString("METAFLOW_SFN_FAILURES_TOPIC"),
+		},
+	)
+
+	return SchedulingStackOutput{
+		Stack:             stack,
+		FailuresTopic:     topic,
+		FailuresRule:      rule,
+		FailuresAlarm:     alarm,
+		EventBus:          eventBus,
+		ExecutionLogGroup: logGroup,
+	}
+}
+
+func failuresTopic(construct constructs.Construct, in SchedulingStackInput) awssns.Topic {
+	topic := awssns.NewTopic(
+		construct,
+		pointer.ToString("StateMachineFailures"),
+		&awssns.TopicProps{
+			TopicName:   pointer.ToString("metaflow-state-machine-failures"),
+			DisplayName: pointer.ToString("Metaflow state machine failures"),
+			MasterKey:   awskms.Key_FromKeyArn(construct, pointer.ToString("TopicKey"), in.DataKey.KeyArn()),
+			EnforceSSL:  pointer.ToBool(true),
+		},
+	)
+
+	if email := in.Config.Scheduling.NotificationEmail; email != "" {
+		topic.AddSubscription(awssnssubscriptions.NewEmailSubscription(pointer.ToString(email), nil))
+	}
+
+	return topic
+}
+
+// failuresRule matches the status changes of the state machines named <prefix>_<flow>, Step Functions
+// only sends them to the default bus.
+func failuresRule(construct constructs.Construct, topic awssns.Topic, in SchedulingStackInput) awsevents.Rule {
+	stateMachines := fmt.Sprintf(
+		"arn:aws:states:%[1]s:%[2]s:stateMachine:%[3]s_",
+		in.Account.Region, in.Account.AccountId, in.Config.Scheduling.StateMachinePrefix,
+	)
+
+	rule := awsevents.NewRule(
+		construct,
+		pointer.ToString("StateMachineFailuresRule"),
+		&awsevents.RuleProps{
+			RuleName:    pointer.ToString("MetaflowStateMachineFailures"),
+			Description: pointer.ToString("Failed and timed out executions of Metaflow state machines"),
+			EventPattern: &awsevents.EventPattern{
+				Source:     &[]*string{pointer.ToString("aws.states")},
+				DetailType: &[]*string{pointer.ToString("Step Functions Execution Status Change")},
+				Detail: &map[string]any{
+					"status":          []string{"FAILED", "TIMED_OUT"},
+					"stateMachineArn": []any{map[string]string{"prefix": stateMachines}},
+				},
+			},
+		},
+	)
+
+	rule.AddTarget(awseventstargets.NewSnsTopic(topic, &awseventstargets.SnsTopicProps{
+		Message: awsevents.RuleTargetInput_FromText(pointer.ToString(fmt.Sprintf(
+			"Metaflow execution %s of %s finished with status %s",
+			*awsevents.EventField_FromPath(pointer.ToString("$.detail.name")),
+			*awsevents.EventField_FromPath(pointer.ToString("$.detail.stateMachineArn")),
+			*awsevents.EventField_FromPath(pointer.ToString("$.detail.status")),
+		))),
+	}))
+
+	return rule
+}
+
+// failuresAlarm counts the matched executions so failures also show up as alarm state. The rule already
+// publishes each failure, so only the rule failing to deliver to the topic notifies it.
+func failuresAlarm(construct constructs.Construct, topic awssns.Topic, rule awsevents.Rule) awscloudwatch.Alarm {
+	ruleMetric := func(name string) awscloudwatch.Metric {
+		return awscloudwatch.NewMetric(&awscloudwatch.MetricProps{
+			Namespace:  pointer.ToString("AWS/Events"),
+			MetricName: pointer.ToString(name),
+			DimensionsMap: &map[string]*string{
+				"RuleName": rule.RuleName(),
+			},
+			Statistic: awscloudwatch.Stats_SUM(),
+			Period:    awscdk.Duration_Minutes(pointer.ToFloat64(5)),
+		})
+	}
+
+	action := awscloudwatchactions.NewSnsAction(topic)
+
+	alarm := awscloudwatch.NewAlarm(
+		construct,
+		pointer.ToString("StateMachineFailuresAlarm"),
+		&awscloudwatch.AlarmProps{
+			AlarmName:          pointer.ToString("MetaflowStateMachineFailures"),
+			AlarmDescription:   pointer.ToString("Metaflow state machine executions failed or timed out"),
+			Metric:             ruleMetric("TriggeredRules"),
+			Threshold:          pointer.ToFloat64(1),
+			EvaluationPeriods:  pointer.ToFloat64(1),
+			ComparisonOperator: awscloudwatch.ComparisonOperator_GREATER_THAN_OR_EQUAL_TO_THRESHOLD,
+			TreatMissingData:   awscloudwatch.TreatMissingData_NOT_BREACHING,
+		},
+	)
+
+	undelivered := awscloudwatch.NewAlarm(
+		construct,
+		pointer.ToString("FailureNotificationsUndeliveredAlarm"),
+		&awscloudwatch.AlarmProps{
+			AlarmName:          pointer.ToString("MetaflowFailureNotificationsUndelivered"),
+			AlarmDescription:   pointer.ToString("EventBridge could not publish Metaflow failures to SNS"),
+			Metric:             ruleMetric("FailedInvocations"),
+			Threshold:          pointer.ToFloat64(1),
+			EvaluationPeriods:  pointer.ToFloat64(1),
+			ComparisonOperator: awscloudwatch.ComparisonOperator_GREATER_THAN_OR_EQUAL_TO_THRESHOLD,
+			TreatMissingData:   awscloudwatch.TreatMissingData_NOT_BREACHING,
+		},
+	)
+	undelivered.AddAlarmAction(action)
+
+	return alarm
+}
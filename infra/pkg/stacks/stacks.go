@@ -0,0 +1,33 @@
+package stacks
+
+import "github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+
+// Constructors are the stack constructors of the app. The disaster recovery and Kubernetes stacks are only
+// built when the configuration enables them.
+func Constructors(config commons.Config) []any {
+	constructors := []any{
+		BuildMetaflowNetworkingStack,
+		BuildKMSStack,
+	}
+	if config.DisasterRecovery.Enabled {
+		constructors = append(constructors, BuildDRStack)
+	}
+	constructors = append(constructors,
+		BuildMetaflowMetadataStack,
+		TaskDefinitionsStack,
+		BuildPersistenceStack,
+		BuildClusterStack,
+		BuildUIStack,
+		BuildIAMStack,
+		BuildApiStack,
+		BuildNotebooksStack,
+		BuildBatchStack,
+		BuildRolesStack,
+		BuildSchedulingStack,
+		BuildObservabilityStack,
+	)
+	if config.Kubernetes.Enabled {
+		constructors = append(constructors, BuildEKSStack)
+	}
+	return append(constructors, BuildResultStack)
+}
@@ -0,0 +1,737 @@
+package stacks_test
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/AlekSi/pointer"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/pkg/bootstrap"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/pkg/ops"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/pkg/policy"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/pkg/stacks"
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
+	"github.com/aws/jsii-runtime-go"
+	"go.uber.org/fx"
+)
+
+// testAccountId is made up, the suite synthesizes without credentials and never looks anything up.
+const testAccountId = "123456789012"
+
+// testConfig enables every optional stack and uses the production persistence profile.
+func testConfig() commons.Config {
+	config := commons.DefaultConfig()
+	config.Persistence = commons.PersistenceProfiles[commons.ProductionPersistenceProfile]
+	config.DisasterRecovery.Enabled = true
+	config.Kubernetes.Enabled = true
+	config.Kubernetes.KubectlLayerArn = "arn:aws:lambda:us-east-2:" + testAccountId + ":layer:kubectl:1"
+	return config
+}
+
+type stacksInput struct {
+	fx.In
+	Stacks []awscdk.Stack `group:"stacks"`
+}
+
+// synthesize builds the stacks of the constructors in a new app, keyed by stack name.
+func synthesize(config commons.Config, constructors ...any) (map[string]awscdk.Stack, error) {
+	account := commons.Account{
+		App:       awscdk.NewApp(nil),
+		AccountId: testAccountId,
+		Region:    bootstrap.MainRegion,
+	}
+
+	// Like cmd/cdk, asking for the stacks group builds every stack, including the ones only built as a dependency.
+	container := fx.New(
+		fx.NopLogger,
+		fx.Supply(account, config),
+		fx.Provide(constructors...),
+		fx.Invoke(func(stacksInput) {}),
+	)
+	if err := container.Err(); err != nil {
+		return nil, err
+	}
+
+	built := map[string]awscdk.Stack{}
+	for _, child := range *account.App.Node().Children() {
+		if *awscdk.Stack_IsStack(child) {
+			stack := awscdk.Stack_Of(child)
+			built[*stack.StackName()] = stack
+		}
+	}
+	return built, nil
+}
+
+var deployment = sync.OnceValues(func() (map[string]awscdk.Stack, error) {
+	return synthesize(testConfig(), stacks.Constructors(testConfig())...)
+})
+
+// template synthesizes the stack of the full test deployment.
+func template(t *testing.T, name string) assertions.Template {
+	t.Helper()
+	built, err := deployment()
+	if err != nil {
+		t.Fatalf("building the stacks: %s", err)
+	}
+	stack, ok := built[name]
+	if !ok {
+		t.Fatalf("no stack named %s", name)
+	}
+	return assertions.Template_FromStack(stack, nil)
+}
+
+// check runs an assertion, the assertions library reports a mismatch by panicking.
+func check(t *testing.T, assertion func()) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Error(r)
+		}
+	}()
+	assertion()
+}
+
+func like(pattern map[string]any) assertions.Matcher {
+	return assertions.Match_ObjectLike(&pattern)
+}
+
+func arrayWith(pattern ...any) assertions.Matcher {
+	return assertions.Match_ArrayWith(&pattern)
+}
+
+// statement matches a policy document with an Allow statement granting the actions.
+func statement(actions ...string) map[string]any {
+	return map[string]any{
+		"PolicyDocument": like(map[string]any{
+			"Statement": arrayWith(like(map[string]any{
+				"Effect": "Allow",
+				"Action": arrayWith(toAny(actions)...),
+			})),
+		}),
+	}
+}
+
+func toAny(values []string) []any {
+	result := make([]any, len(values))
+	for i, value := range values {
+		result[i] = value
+	}
+	return result
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	jsii.Close()
+	os.Exit(code)
+}
+
+func TestNetworkingStack(t *testing.T) {
+	template := template(t, "MetaflowNetworkingStack")
+
+	check(t, func() {
+		template.ResourceCountIs(pointer.ToString("AWS::EC2::Subnet"), pointer.ToFloat64(4))
+	})
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::EC2::SecurityGroupIngress"), map[string]any{
+			"Description": "Allow access to DB from Fargate",
+			"IpProtocol":  "tcp",
+			"FromPort":    5432,
+			"ToPort":      5432,
+		})
+	})
+	// The database only takes connections from Fargate.
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::EC2::SecurityGroup"), map[string]any{
+			"GroupDescription":     "MetaflowNetworkingStack/MetaflowDBSecurityGroup",
+			"SecurityGroupIngress": assertions.Match_Absent(),
+		})
+	})
+
+	config := testConfig()
+	config.Persistence = commons.PersistenceProfiles[commons.DevelopmentPersistenceProfile]
+	built, err := synthesize(config, stacks.BuildMetaflowNetworkingStack)
+	if err != nil {
+		t.Fatalf("building the networking stack: %s", err)
+	}
+	development := assertions.Template_FromStack(built["MetaflowNetworkingStack"], nil)
+	check(t, func() {
+		development.HasResourceProperties(pointer.ToString("AWS::EC2::SecurityGroupIngress"), map[string]any{
+			"Description":           "Allow access to DB from Fargate",
+			"IpProtocol":            "tcp",
+			"FromPort":              5432,
+			"ToPort":                5432,
+			"SourceSecurityGroupId": map[string]any{"Fn::GetAtt": arrayWith("GroupId")},
+		})
+	})
+}
+
+func TestKMSStack(t *testing.T) {
+	template := template(t, "KMSStack")
+
+	check(t, func() {
+		template.ResourceCountIs(pointer.ToString("AWS::KMS::Key"), pointer.ToFloat64(2))
+	})
+	check(t, func() {
+		template.AllResourcesProperties(pointer.ToString("AWS::KMS::Key"), map[string]any{
+			"EnableKeyRotation": true,
+		})
+	})
+}
+
+func TestDRStack(t *testing.T) {
+	template := template(t, "MetaflowDRStack")
+	config := testConfig()
+
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::S3::Bucket"), map[string]any{
+			"BucketName":              "metaflow-datastore-replica-" + testAccountId + "-" + config.DisasterRecovery.SecondaryRegion,
+			"VersioningConfiguration": map[string]any{"Status": "Enabled"},
+			"PublicAccessBlockConfiguration": map[string]any{
+				"BlockPublicAcls":       true,
+				"BlockPublicPolicy":     true,
+				"IgnorePublicAcls":      true,
+				"RestrictPublicBuckets": true,
+			},
+		})
+	})
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::KMS::Key"), map[string]any{
+			"EnableKeyRotation": true,
+		})
+	})
+	check(t, func() {
+		template.ResourceCountIs(pointer.ToString("AWS::Backup::BackupVault"), pointer.ToFloat64(1))
+	})
+}
+
+func TestMetadataStack(t *testing.T) {
+	template := template(t, "MetaflowMetadataStack")
+
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::ElasticLoadBalancingV2::LoadBalancer"), map[string]any{
+			"Scheme": "internal",
+		})
+	})
+	for _, port := range []int{80, 8082} {
+		check(t, func() {
+			template.HasResourceProperties(pointer.ToString("AWS::ElasticLoadBalancingV2::Listener"), map[string]any{
+				"Port":     port,
+				"Protocol": "TCP",
+			})
+		})
+	}
+}
+
+func TestTaskDefinitionsStack(t *testing.T) {
+	template := template(t, "MetaflowCoreStack")
+
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::ECS::Service"), map[string]any{
+			"LaunchType": "FARGATE",
+			"DeploymentConfiguration": like(map[string]any{
+				"DeploymentCircuitBreaker": map[string]any{"Enable": true, "Rollback": true},
+			}),
+		})
+	})
+	// Auto scaling owns the task count, a deploy must not reset it to the minimum.
+	check(t, func() {
+		template.ResourceCountIs(pointer.ToString("AWS::ApplicationAutoScaling::ScalableTarget"), pointer.ToFloat64(1))
+	})
+	check(t, func() {
+		template.AllResourcesProperties(pointer.ToString("AWS::ECS::Service"), map[string]any{
+			"DesiredCount": assertions.Match_Absent(),
+		})
+	})
+	check(t, func() {
+		template.HasOutput(pointer.ToString("*"), map[string]any{
+			"Description": "METAFLOW_METADATA_VERSION",
+			"Value":       commons.MetaflowMetadataVersion,
+		})
+	})
+}
+
+func TestPersistenceStack(t *testing.T) {
+	template := template(t, "PersistenceStack")
+	config := testConfig()
+
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::RDS::DBInstance"), map[string]any{
+			"PubliclyAccessible": false,
+			"StorageEncrypted":   true,
+			"MultiAZ":            true,
+			"DeletionProtection": true,
+		})
+	})
+	check(t, func() {
+		template.HasResource(pointer.ToString("AWS::RDS::DBInstance"), map[string]any{
+			"DeletionPolicy":      "Snapshot",
+			"UpdateReplacePolicy": "Retain",
+		})
+	})
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::S3::Bucket"), map[string]any{
+			"PublicAccessBlockConfiguration": map[string]any{
+				"BlockPublicAcls":       true,
+				"BlockPublicPolicy":     true,
+				"IgnorePublicAcls":      true,
+				"RestrictPublicBuckets": true,
+			},
+		})
+	})
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::DynamoDB::GlobalTable"), map[string]any{
+			"TableName": commons.StateTableName,
+			"Replicas": arrayWith(
+				like(map[string]any{"Region": bootstrap.MainRegion}),
+				like(map[string]any{"Region": config.DisasterRecovery.SecondaryRegion}),
+			),
+		})
+	})
+}
+
+func TestDBProxy(t *testing.T) {
+	config := testConfig()
+	config.Persistence.Proxy = true
+	built, err := synthesize(config, stacks.Constructors(config)...)
+	if err != nil {
+		t.Fatalf("building the stacks: %s", err)
+	}
+
+	persistence := assertions.Template_FromStack(built["PersistenceStack"], nil)
+	check(t, func() {
+		persistence.HasResourceProperties(pointer.ToString("AWS::RDS::DBProxy"), map[string]any{
+			"Auth": assertions.Match_Not(arrayWith(like(map[string]any{"IAMAuth": "DISABLED"}))),
+		})
+	})
+	// Each service role may only connect as the user of its own service.
+	users := map[string][2]string{
+		"MetaflowCoreStack": {commons.MetadataDBUser, commons.UIDBUser},
+		"UIStack":           {commons.UIDBUser, commons.MetadataDBUser},
+	}
+	for name, user := range users {
+		template := assertions.Template_FromStack(built[name], nil)
+		connect := func(user string) map[string]any {
+			return map[string]any{
+				"PolicyDocument": like(map[string]any{
+					"Statement": arrayWith(like(map[string]any{
+						"Action": "rds-db:connect",
+						"Resource": map[string]any{
+							"Fn::Sub": arrayWith(assertions.Match_StringLikeRegexp(pointer.ToString("/" + user + "$"))),
+						},
+					})),
+				}),
+			}
+		}
+		check(t, func() {
+			template.HasResourceProperties(pointer.ToString("AWS::IAM::Policy"), connect(user[0]))
+		})
+		policies, err := json.Marshal(template.FindResources(pointer.ToString("AWS::IAM::Policy"), nil))
+		if err != nil {
+			t.Fatalf("reading the policies of %s: %s", name, err)
+		}
+		if strings.Contains(string(policies), "${ProxyId}/"+user[1]+`"`) {
+			t.Errorf("%s may connect to the proxy as %s", name, user[1])
+		}
+		check(t, func() {
+			template.HasResourceProperties(pointer.ToString("AWS::ECS::TaskDefinition"), map[string]any{
+				"ContainerDefinitions": arrayWith(
+					like(map[string]any{
+						"Environment": arrayWith(map[string]any{"Name": "MF_METADATA_DB_USER", "Value": user[0]}),
+						"DependsOn":   arrayWith(map[string]any{"ContainerName": "db-auth-token", "Condition": "HEALTHY"}),
+						"EntryPoint":  assertions.Match_Absent(),
+						"Secrets":     assertions.Match_Absent(),
+					}),
+					like(map[string]any{
+						"Name":  "db-auth-token",
+						"Image": assertions.Match_StringLikeRegexp(pointer.ToString(commons.AWSCLIRepository + ":")),
+					}),
+				),
+			})
+		})
+	}
+}
+
+// With Aurora the services take the writer of the cluster as their DB instance, with the cluster endpoint.
+func TestAuroraDatabase(t *testing.T) {
+	config := testConfig()
+	config.Persistence.Engine = commons.AuroraServerlessEngine
+	built, err := synthesize(config, stacks.Constructors(config)...)
+	if err != nil {
+		t.Fatalf("building the stacks: %s", err)
+	}
+
+	for _, name := range []string{commons.CoreStackName, commons.UIStackName} {
+		template := assertions.Template_FromStack(built[name], nil)
+		check(t, func() {
+			template.HasResourceProperties(pointer.ToString("AWS::ECS::TaskDefinition"), map[string]any{
+				"ContainerDefinitions": arrayWith(like(map[string]any{
+					"Environment": arrayWith(map[string]any{
+						"Name": "MF_METADATA_DB_HOST",
+						"Value": map[string]any{
+							"Fn::ImportValue": assertions.Match_StringLikeRegexp(pointer.ToString("MetaflowDBCluster.*EndpointAddress")),
+						},
+					}),
+				})),
+			})
+		})
+	}
+
+	// The reader takes over when the writer fails, the CLI reads the status of the cluster and not of an instance.
+	persistence := assertions.Template_FromStack(built[commons.PersistenceStackName], nil)
+	for name, tier := range map[string]int{"MetaflowDBWriter": 0, "MetaflowDBReader": 1} {
+		instances := persistence.FindResources(pointer.ToString("AWS::RDS::DBInstance"), map[string]any{
+			"Properties": map[string]any{"PromotionTier": tier},
+		})
+		if !slices.ContainsFunc(slices.Collect(maps.Keys(*instances)), func(id string) bool { return strings.HasPrefix(id, name) }) {
+			t.Errorf("%s has no promotion tier %d", name, tier)
+		}
+	}
+	result := assertions.Template_FromStack(built[commons.ResultStackName], nil)
+	check(t, func() {
+		result.HasOutput(pointer.ToString("*"), map[string]any{
+			"Description": "METADATA_DB_IDENTIFIER",
+			"Value":       map[string]any{"Fn::ImportValue": assertions.Match_StringLikeRegexp(pointer.ToString("RefMetaflowDBCluster"))},
+		})
+	})
+}
+
+func TestClusterStack(t *testing.T) {
+	template := template(t, "ClusterStack")
+
+	// The metadata and UI services get their own task roles.
+	check(t, func() {
+		template.ResourceCountIs(pointer.ToString("AWS::IAM::Role"), pointer.ToFloat64(2))
+	})
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::ECS::Cluster"), map[string]any{
+			"ClusterSettings": arrayWith(map[string]any{"Name": "containerInsights", "Value": "enabled"}),
+		})
+	})
+}
+
+func TestUIStack(t *testing.T) {
+	template := template(t, "UIStack")
+
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::ElasticLoadBalancingV2::Listener"), map[string]any{
+			"Port":         443,
+			"Protocol":     "HTTPS",
+			"Certificates": arrayWith(like(map[string]any{"CertificateArn": assertions.Match_AnyValue()})),
+		})
+	})
+	check(t, func() {
+		template.AllResourcesProperties(pointer.ToString("AWS::ECS::Service"), map[string]any{
+			"DeploymentConfiguration": like(map[string]any{
+				"DeploymentCircuitBreaker": map[string]any{"Enable": true, "Rollback": true},
+			}),
+		})
+	})
+	// Only the static UI keeps a fixed count, auto scaling owns the count of the backend.
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::ECS::Service"), map[string]any{
+			"LoadBalancers": arrayWith(like(map[string]any{"ContainerName": "metadata-ui-service"})),
+			"DesiredCount":  assertions.Match_Absent(),
+		})
+	})
+	check(t, func() {
+		template.HasOutput(pointer.ToString("*"), map[string]any{
+			"Description": "METAFLOW_UI_VERSION",
+			"Value":       commons.MetaflowStaticUIVersion,
+		})
+	})
+}
+
+func TestIAMStack(t *testing.T) {
+	template := template(t, "IAMStack")
+
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::IAM::Policy"), statement(
+			"ec2:CreateNetworkInterface", "ec2:DescribeNetworkInterfaces", "ec2:DeleteNetworkInterface",
+		))
+	})
+}
+
+func TestApiStack(t *testing.T) {
+	template := template(t, "ApiStack")
+
+	check(t, func() {
+		template.ResourceCountIs(pointer.ToString("AWS::ApiGateway::VpcLink"), pointer.ToFloat64(1))
+	})
+	check(t, func() {
+		template.AllResourcesProperties(pointer.ToString("AWS::ApiGateway::Method"), map[string]any{
+			"Integration": like(map[string]any{"ConnectionType": "VPC_LINK"}),
+		})
+	})
+}
+
+func TestNotebooksStack(t *testing.T) {
+	template := template(t, "NotebookStack")
+
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::SageMaker::NotebookInstance"), map[string]any{
+			"LifecycleConfigName": assertions.Match_AnyValue(),
+			"SubnetId":            assertions.Match_AnyValue(),
+			"SecurityGroupIds":    assertions.Match_AnyValue(),
+		})
+	})
+}
+
+func TestBatchStack(t *testing.T) {
+	template := template(t, "BatchStack")
+
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::Batch::ComputeEnvironment"), map[string]any{
+			"ServiceRole": map[string]any{"Fn::GetAtt": []any{assertions.Match_StringLikeRegexp(pointer.ToString("^BatchExecutionRole")), "Arn"}},
+			"ComputeResources": like(map[string]any{
+				"InstanceTypes": []any{commons.BatchGPUInstanceType},
+				"MaxvCpus":      commons.BatchMaxvCpus,
+				"MinvCpus":      0,
+			}),
+		})
+	})
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::Batch::JobQueue"), map[string]any{
+			"State": "ENABLED",
+			"ComputeEnvironmentOrder": []any{
+				map[string]any{"ComputeEnvironment": map[string]any{"Ref": "ComputeEnvironment"}, "Order": 1},
+			},
+		})
+	})
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::IAM::Role"), map[string]any{
+			"RoleName": commons.BatchExecutionRoleName,
+		})
+	})
+	// The per-job GPU metrics are extracted from the records the hosts write to this group.
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::Logs::LogGroup"), map[string]any{
+			"LogGroupName": commons.GPUJobsLogGroup,
+			"KmsKeyId":     assertions.Match_AnyValue(),
+		})
+	})
+}
+
+func TestRolesStack(t *testing.T) {
+	template := template(t, "RolesStack")
+
+	// Metaflow tags the jobs it submits, the jobs commands read the tags back.
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::IAM::Policy"), statement("batch:SubmitJob", "batch:TagResource"))
+	})
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::IAM::Policy"), statement("dynamodb:PutItem", "dynamodb:GetItem", "dynamodb:UpdateItem"))
+	})
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::IAM::Policy"), statement(
+			"sagemaker:StartNotebookInstance", "sagemaker:StopNotebookInstance", "sagemaker:CreatePresignedNotebookInstanceUrl",
+		))
+	})
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::IAM::Policy"), statement("states:StartExecution"))
+	})
+}
+
+func TestSchedulingStack(t *testing.T) {
+	template := template(t, "SchedulingStack")
+	config := testConfig()
+
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::Events::EventBus"), map[string]any{
+			"Name": "metaflow",
+		})
+	})
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::Events::Rule"), map[string]any{
+			"EventPattern": like(map[string]any{
+				"detail": like(map[string]any{
+					"stateMachineArn": []any{map[string]any{
+						"prefix": "arn:aws:states:" + bootstrap.MainRegion + ":" + testAccountId + ":stateMachine:" + config.Scheduling.StateMachinePrefix + "_",
+					}},
+				}),
+			}),
+		})
+	})
+	// The rule publishes every failure, the alarm must not notify the topic again.
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::CloudWatch::Alarm"), map[string]any{
+			"AlarmName":    "MetaflowStateMachineFailures",
+			"AlarmActions": assertions.Match_Absent(),
+		})
+	})
+}
+
+func TestDataArrivalTrigger(t *testing.T) {
+	config := testConfig()
+	config.Scheduling.Triggers = []commons.TriggerConfig{
+		{Name: "Retrain", Prefix: "training/", StateMachine: "metaflow_TrainFlow", Parameter: "data_key"},
+	}
+	built, err := synthesize(config, stacks.Constructors(config)...)
+	if err != nil {
+		t.Fatalf("building the stacks: %s", err)
+	}
+	template := assertions.Template_FromStack(built["SchedulingStack"], nil)
+
+	// Metaflow parses "Parameters" as a JSON string, EventBridge fills in the object key.
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::Events::Rule"), map[string]any{
+			"Name": "MetaflowTriggerRetrain",
+			"EventPattern": like(map[string]any{
+				"detail": like(map[string]any{
+					"object": map[string]any{"key": []any{map[string]any{"prefix": "data/training/"}}},
+				}),
+			}),
+			"Targets": []any{like(map[string]any{
+				"InputTransformer": map[string]any{
+					"InputPathsMap": map[string]any{"detail-object-key": "$.detail.object.key"},
+					"InputTemplate": `{"Parameters":"{\"data_key\":\"<detail-object-key>\"}"}`,
+				},
+			})},
+		})
+	})
+}
+
+func TestObservabilityStack(t *testing.T) {
+	template := template(t, "ObservabilityStack")
+
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::SNS::Topic"), map[string]any{
+			"TopicName":      "metaflow-alarms",
+			"KmsMasterKeyId": assertions.Match_AnyValue(),
+		})
+	})
+	check(t, func() {
+		template.ResourceCountIs(pointer.ToString("AWS::CloudWatch::Dashboard"), pointer.ToFloat64(1))
+	})
+}
+
+func TestEKSStack(t *testing.T) {
+	template := template(t, "MetaflowEKSStack")
+	config := testConfig()
+
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("AWS::EKS::Cluster"), map[string]any{
+			"Version": config.Kubernetes.Version,
+		})
+	})
+	// The CPU nodes run the Argo controllers, they span both private subnets.
+	subnets := map[string]int{}
+	for _, nodegroup := range *template.FindResources(pointer.ToString("AWS::EKS::Nodegroup"), nil) {
+		properties := (*nodegroup)["Properties"].(map[string]any)
+		subnets[properties["NodegroupName"].(string)] = len(properties["Subnets"].([]any))
+	}
+	if subnets["metaflow-cpu"] < 2 || subnets["metaflow-gpu"] != 1 {
+		t.Errorf("node group subnets = %v, want at least 2 for metaflow-cpu and 1 for metaflow-gpu", subnets)
+	}
+	check(t, func() {
+		template.HasResourceProperties(pointer.ToString("Custom::AWSCDK-EKS-KubernetesResource"), map[string]any{
+			"Manifest": assertions.Match_SerializedJson(arrayWith(like(map[string]any{
+				"kind": "EventBus",
+				"spec": map[string]any{"jetstream": map[string]any{"version": commons.ArgoEventsJetStreamVersion}},
+			}))),
+		})
+	})
+}
+
+func TestResultStack(t *testing.T) {
+	template := template(t, "ResultStack")
+
+	outputs := *template.FindOutputs(pointer.ToString("*"), nil)
+	if len(outputs) == 0 {
+		t.Fatal("ResultStack has no outputs")
+	}
+
+	// metaflow-config fills the configuration by output description, every other description is dropped.
+	settings := reflect.TypeFor[ops.Config]()
+	var descriptions []string
+	for id, output := range outputs {
+		output := *output
+		description, _ := output["Description"].(string)
+		descriptions = append(descriptions, description)
+		if _, ok := settings.FieldByName(description); !ok && !slices.Contains(ops.OperationalOutputs, description) {
+			t.Errorf("output %s has description %q, which is neither a Config field nor an operational output", id, description)
+		}
+	}
+	for _, required := range []string{"METAFLOW_BATCH_JOB_QUEUE", "METAFLOW_DATASTORE_SYSROOT_S3", "METAFLOW_SERVICE_URL", "METAFLOW_SFN_IAM_ROLE"} {
+		if !slices.Contains(descriptions, required) {
+			t.Errorf("ResultStack has no %s output", required)
+		}
+	}
+	for _, operational := range ops.OperationalOutputs {
+		if !slices.Contains(descriptions, operational) {
+			t.Errorf("ResultStack has no %s output, the CLI reads it", operational)
+		}
+	}
+
+	check(t, func() {
+		template.HasOutput(pointer.ToString("*"), map[string]any{
+			"Description": "METAFLOW_BATCH_JOB_QUEUE",
+			"Value":       map[string]any{"Fn::ImportValue": assertions.Match_StringLikeRegexp(pointer.ToString("^BatchStack:ExportsOutputRefJobQueue"))},
+		})
+	})
+}
+
+// The CLI selects stacks by alias, every alias has to name stacks the app builds with every option enabled.
+func TestStackAliases(t *testing.T) {
+	built, err := deployment()
+	if err != nil {
+		t.Fatalf("building the stacks: %s", err)
+	}
+	for alias, names := range ops.StackAliases {
+		for _, name := range names {
+			if _, ok := built[name]; !ok {
+				t.Errorf("alias %s selects %s, which is not a stack of the app", alias, name)
+			}
+		}
+	}
+	for name := range built {
+		if !slices.Contains(ops.StackNames(), name) {
+			t.Errorf("stack %s has no alias", name)
+		}
+	}
+}
+
+// The rule pack reports the known findings of the production configuration, the README lists them.
+func TestComplianceFindings(t *testing.T) {
+	built, err := synthesize(testConfig(), stacks.Constructors(testConfig())...)
+	if err != nil {
+		t.Fatalf("building the stacks: %s", err)
+	}
+	app := built[commons.ResultStackName].Node().Root().(awscdk.App)
+	policy.Apply(app, false, policy.BuiltinRules())
+
+	findings := []struct {
+		stack string
+		path  string
+		rule  string
+	}{
+		{commons.BatchStackName, "/BatchStack/BatchSecurityGroup/Resource", policy.OpenIngressRule},
+		{commons.BatchStackName, "/BatchStack/Device/Resource", policy.UserDataSecretRule},
+		{commons.NetworkingStackName, "/MetaflowNetworkingStack/FargateSecurityGroup/Resource", policy.OpenIngressRule},
+		{commons.NotebookStackName, "/NotebookStack/SageMakerSecurityGroup/Resource", policy.OpenIngressRule},
+		{commons.RolesStackName, "/RolesStack/MetaflowUserPolicy/Resource", policy.WildcardWriteRule},
+		{commons.RolesStackName, "/RolesStack/MetaflowUserRole/DefaultPolicy/Resource", policy.WildcardWriteRule},
+		{commons.RolesStackName, "/RolesStack/StepFunctionsRole/DefaultPolicy/Resource", policy.WildcardWriteRule},
+		{commons.RolesStackName, "/RolesStack/BatchS3Role/DefaultPolicy/Resource", policy.WildcardWriteRule},
+		{commons.RolesStackName, "/RolesStack/ECSExecutionRoleMetaflowUser/DefaultPolicy/Resource", policy.WildcardWriteRule},
+	}
+	for _, finding := range findings {
+		check(t, func() {
+			assertions.Annotations_FromStack(built[finding.stack]).HasWarning(
+				pointer.ToString(finding.path),
+				assertions.Match_StringLikeRegexp(pointer.ToString(`^\[`+finding.rule+`\]`)),
+			)
+		})
+	}
+	for name, stack := range built {
+		for _, message := range *assertions.Annotations_FromStack(stack).FindError(pointer.ToString("*"), assertions.Match_AnyValue()) {
+			t.Errorf("%s: %s %v", name, *message.Id, message.Entry.Data)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package stacks
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/AlekSi/pointer"
+	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsevents"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseventstargets"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awss3"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsstepfunctions"
+	"github.com/aws/constructs-go/constructs/v10"
+)
+
+type DataArrivalTriggerProps struct {
+	Trigger commons.TriggerConfig
+	Account commons.Account
+	Bucket  awss3.IBucket
+	// Role is the role EventBridge starts the state machine with, it must already allow states:StartExecution.
+	Role awsiam.IRole
+}
+
+// DataArrivalTrigger starts a Metaflow state machine for every object created under the datatools root
+// matching the trigger prefix.
+type DataArrivalTrigger struct {
+	constructs.Construct
+	Rule awsevents.Rule
+}
+
+func NewDataArrivalTrigger(scope constructs.Construct, id string, props *DataArrivalTriggerProps) *DataArrivalTrigger {
+	construct := constructs.NewConstruct(scope, &id)
+	trigger := props.Trigger
+
+	key := "data/" + trigger.Prefix
+	keyPattern := map[string]string{"prefix": key}
+	if strings.Contains(key, "*") {
+		keyPattern = map[string]string{"wildcard": key}
+	}
+
+	rule := awsevents.NewRule(
+		construct,
+		pointer.ToString("Rule"),
+		&awsevents.RuleProps{
+			RuleName:    pointer.ToString(fmt.Sprintf("MetaflowTrigger%s", trigger.Name)),
+			Description: pointer.ToString(fmt.Sprintf("Start %s when data lands under %s", trigger.StateMachine, key)),
+			EventPattern: &awsevents.EventPattern{
+				Source:     &[]*string{pointer.ToString("aws.s3")},
+				DetailType: &[]*string{pointer.ToString("Object Created")},
+				Detail: &map[string]any{
+					"bucket": map[string]any{
+						"name": []*string{props.Bucket.BucketName()},
+					},
+					"object": map[string]any{
+						"key": []any{keyPattern},
+					},
+				},
+			},
+		},
+	)
+
+	stateMachine := awsstepfunctions.StateMachine_FromStateMachineArn(
+		construct,
+		pointer.ToString("StateMachine"),
+		pointer.ToString(fmt.Sprintf("arn:aws:states:%[1]s:%[2]s:stateMachine:%[3]s", props.Account.Region, props.Account.AccountId, trigger.StateMachine)),
+	)
+
+	// EventBridgeRole already starts any state machine, importing it immutable keeps the target from
+	// adding a grant to RolesStack.
+	role := awsiam.Role_FromRoleArn(
+		construct,
+		pointer.ToString("Role"),
+		props.Role.RoleArn(),
+		&awsiam.FromRoleArnOptions{Mutable: pointer.ToBool(false)},
+	)
+
+	// Metaflow reads the flow parameters from a JSON string in "Parameters", so the parameters are marshalled
+	// on their own and the string is embedded in the input object. EventBridge substitutes the key placeholder
+	// with the object key. Marshalling a map of strings cannot fail.
+	parameters, _ := json.Marshal(map[string]string{
+		trigger.Parameter: *awsevents.EventField_FromPath(pointer.ToString("$.detail.object.key")),
+	})
+	rule.AddTarget(awseventstargets.NewSfnStateMachine(stateMachine, &awseventstargets.SfnStateMachineProps{
+		Role: role,
+		Input: awsevents.RuleTargetInput_FromObject(map[string]any{
+			"Parameters": string(parameters),
+		}),
+	}))
+
+	return &DataArrivalTrigger{Construct: construct, Rule: rule}
+}
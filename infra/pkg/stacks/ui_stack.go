@@ -3,6 +3,7 @@ package stacks
 import (
 	"fmt"
 	"os"
+	"strconv"
 
 	"github.com/AlekSi/pointer"
 	"github.com/alejovasquero/NN-HIGH-PERFORMANCE/internal/commons"
@@ -11,6 +12,7 @@ import (
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsecs"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awselasticloadbalancingv2"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awskms"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awslogs"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsrds"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awss3"
@@ -22,6 +24,7 @@ import (
 type UIStackInput struct {
 	fx.In
 	Account              commons.Account
+	Config               commons.Config
 	VPC                  awsec2.Vpc               `name:"metaflow_vpc"`
 	SubnetA              awsec2.CfnSubnet         `name:"metaflow_subnet_a"`
 	SubnetB              awsec2.CfnSubnet         `name:"metaflow_subnet_b"`
@@ -29,9 +32,14 @@ type UIStackInput struct {
 	FargateSecurityGroup awsec2.SecurityGroup     `name:"fargate_security_group"`
 	DB                   awsrds.CfnDBInstance     `name:"DB"`
 	Credentials          awssecretsmanager.Secret `name:"db_credentials"`
+	DBProxy              awsrds.CfnDBProxy        `name:"db_proxy"`
+	UIDBCredentials      awssecretsmanager.Secret `name:"ui_db_credentials"`
+	DBUsers              awscdk.CustomResource    `name:"db_users"`
 	Bucket               awss3.Bucket             `name:"s3_bucket"`
 	Cluster              awsecs.Cluster           `name:"ecs_cluster"`
-	ECSTaskRole          awsiam.Role              `name:"ecs_task_role"`
+	DataKey              awskms.Key               `name:"data_key"`
+	LogsKey              awskms.Key               `name:"logs_key"`
+	UITaskRole           awsiam.Role              `name:"ui_task_role"`
 }
 
 type UIStackOutput struct {
@@ -43,7 +51,7 @@ type UIStackOutput struct {
 func BuildUIStack(in UIStackInput) UIStackOutput {
 	stack := awscdk.NewStack(
 		in.Account.App,
-		pointer.ToString("UIStack"),
+		pointer.ToString(commons.UIStackName),
 		&awscdk.StackProps{
 			Env: in.Account.Env(),
 		},
@@ -54,7 +62,26 @@ func BuildUIStack(in UIStackInput) UIStackOutput {
 	uiStaticTask := uiStaticTaskDefinition(stack, in)
 
 	_, listener := uiStaticService(stack, in, loadBalancer, uiStaticTask, in.SubnetA, in.SubnetB)
-	_ = uiServiceFargateService(stack, in, uiServiceTask, listener, in.SubnetA, in.SubnetB)
+	uiService, uiTargetGroup := uiServiceFargateService(stack, in, uiServiceTask, listener, in.SubnetA, in.SubnetB)
+	if in.DBUsers != nil {
+		uiService.Node().AddDependency(in.DBUsers)
+	}
+
+	scalableTarget := serviceScalableTarget(stack, "UIServiceScalableTarget", in.Cluster, uiService, in.Config.UIService)
+	cpuScalingPolicy(stack, "UIServiceCPUScaling", scalableTarget, in.Config.UIService)
+	requestsScalingPolicy(stack, "UIServiceRequestsScaling", scalableTarget, loadBalancer, uiTargetGroup, in.Config.UIService)
+
+	if in.Config.Persistence.RotationDays > 0 && in.DBProxy == nil {
+		redeployOnRotation(stack, "UIServiceCredentialsRotated", in.Credentials, in.Cluster, uiService)
+	}
+
+	awscdk.NewCfnOutput(
+		stack, pointer.ToString("METAFLOW_UI_VERSION"),
+		&awscdk.CfnOutputProps{
+			Value:       pointer.ToString(in.Config.Images.UIVersion),
+			Description: pointer.ToString("METAFLOW_UI_VERSION"),
+		},
+	)
 
 	return UIStackOutput{
 		UIStack:      stack,
@@ -89,12 +116,12 @@ func uiTaskDefinition(construct constructs.Construct, in UIStackInput) awsecs.Ta
 		pointer.ToString("Definition of ui metaflow service"),
 		&awsecs.TaskDefinitionProps{
 			Family:        pointer.ToString("metadata-ui-service"),
-			Cpu:           pointer.ToString("512"),
-			MemoryMiB:     pointer.ToString("1024"),
+			Cpu:           pointer.ToString(strconv.Itoa(in.Config.UIService.Cpu)),
+			MemoryMiB:     pointer.ToString(strconv.Itoa(in.Config.UIService.MemoryMiB)),
 			NetworkMode:   awsecs.NetworkMode_AWS_VPC,
 			Compatibility: awsecs.Compatibility_EC2_AND_FARGATE,
 			ExecutionRole: executionRole,
-			TaskRole:      in.ECSTaskRole,
+			TaskRole:      in.UITaskRole,
 		},
 	)
 
@@ -105,30 +132,41 @@ func uiTaskDefinition(construct constructs.Construct, in UIStackInput) awsecs.Ta
 			LogGroupName:  pointer.ToString("ecs/metadata-ui-service"),
 			Retention:     awslogs.RetentionDays_EIGHTEEN_MONTHS,
 			RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
+			EncryptionKey: in.LogsKey,
 		},
 	)
 	containerLogGroup.GrantWrite(executionRole)
 
-	task.AddContainer(
+	environment := map[string]*string{
+		"MF_METADATA_DB_HOST":        in.DB.AttrEndpointAddress(),
+		"MF_METADATA_DB_PORT":        pointer.ToString("5432"),
+		"MF_METADATA_DB_SSL_MODE":    pointer.ToString("prefer"),
+		"MF_METADATA_DB_NAME":        pointer.ToString("metaflow"),
+		"UI_ENABLED":                 pointer.ToString("1"),
+		"PATH_PREFIX":                pointer.ToString("/api/"),
+		"MF_DATASTORE_ROOT":          pointer.ToString(fmt.Sprintf("s3://%s/metaflow", *in.Bucket.BucketName())),
+		"METAFLOW_SERVICE_URL":       pointer.ToString("http://localhost:8083/api/metadata"),
+		"METAFLOW_DEFAULT_DATASTORE": pointer.ToString("s3"),
+		"METAFLOW_DEFAULT_METADATA":  pointer.ToString("service"),
+	}
+	secrets := map[string]awsecs.Secret{}
+	if in.DBProxy != nil {
+		environment["MF_METADATA_DB_HOST"] = in.DBProxy.AttrEndpoint()
+	} else {
+		secrets["MF_METADATA_DB_USER"] = awsecs.Secret_FromSecretsManager(in.Credentials, pointer.ToString("username"))
+		secrets["MF_METADATA_DB_PSWD"] = awsecs.Secret_FromSecretsManager(in.Credentials, pointer.ToString("password"))
+		in.Credentials.GrantRead(executionRole, nil)
+	}
+
+	container := task.AddContainer(
 		pointer.ToString("Metaflow execution container"),
 		&awsecs.ContainerDefinitionOptions{
-			ContainerName: pointer.ToString("metadata-ui-service"),
-			Environment: &map[string]*string{
-				"MF_METADATA_DB_HOST":        in.DB.AttrEndpointAddress(),
-				"MF_METADATA_DB_PORT":        pointer.ToString("5432"),
-				"MF_METADATA_DB_SSL_MODE":    pointer.ToString("prefer"),
-				"MF_METADATA_DB_NAME":        pointer.ToString("metaflow"),
-				"UI_ENABLED":                 pointer.ToString("1"),
-				"PATH_PREFIX":                pointer.ToString("/api/"),
-				"MF_DATASTORE_ROOT":          pointer.ToString(fmt.Sprintf("s3://%s/metaflow", *in.Bucket.BucketName())),
-				"METAFLOW_SERVICE_URL":       pointer.ToString("http://localhost:8083/api/metadata"),
-				"METAFLOW_DEFAULT_DATASTORE": pointer.ToString("s3"),
-				"METAFLOW_DEFAULT_METADATA":  pointer.ToString("service"),
-			},
-			Cpu:            pointer.ToFloat64(512),
-			MemoryLimitMiB: pointer.ToFloat64(1024),
+			ContainerName:  pointer.ToString("metadata-ui-service"),
+			Environment:    &environment,
+			Cpu:            pointer.ToFloat64(float64(in.Config.UIService.Cpu)),
+			MemoryLimitMiB: pointer.ToFloat64(float64(in.Config.UIService.MemoryMiB)),
 			Image: awsecs.AssetImage_FromRegistry(
-				pointer.ToString(commons.MetaflowMetadataImage),
+				pointer.ToString(in.Config.Images.MetadataImage(in.Account)),
 				nil,
 			),
 			Command: &[]*string{
@@ -147,14 +185,17 @@ func uiTaskDefinition(construct constructs.Construct, in UIStackInput) awsecs.Ta
 					LogGroup:     containerLogGroup,
 				},
 			),
-			Secrets: &map[string]awsecs.Secret{
-				"MF_METADATA_DB_USER": awsecs.Secret_FromSecretsManager(in.Credentials, pointer.ToString("username")),
-				"MF_METADATA_DB_PSWD": awsecs.Secret_FromSecretsManager(in.Credentials, pointer.ToString("password")),
-			},
+			Secrets: &secrets,
 		},
 	)
-	in.Credentials.GrantRead(executionRole, nil)
-	in.Credentials.GrantWrite(executionRole)
+	// Behind the proxy the backend logs in as its own user with IAM auth tokens.
+	if in.DBProxy != nil {
+		proxyIAMAuth(
+			construct, "UIServiceDBConnect", task, container, in.DBProxy, commons.UIDBUser,
+			in.Config.Images.AWSCLIImage(in.Account), containerLogGroup,
+		)
+	}
+	in.DataKey.GrantDecrypt(executionRole)
 
 	return task
 }
@@ -171,7 +212,7 @@ func uiStaticTaskDefinition(construct constructs.Construct, in UIStackInput) aws
 			NetworkMode:   awsecs.NetworkMode_AWS_VPC,
 			Compatibility: awsecs.Compatibility_EC2_AND_FARGATE,
 			ExecutionRole: executionRole,
-			TaskRole:      in.ECSTaskRole,
+			TaskRole:      in.UITaskRole,
 		},
 	)
 
@@ -182,6 +223,7 @@ func uiStaticTaskDefinition(construct constructs.Construct, in UIStackInput) aws
 			LogGroupName:  pointer.ToString("ecs/metadata-ui-static"),
 			Retention:     awslogs.RetentionDays_EIGHTEEN_MONTHS,
 			RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
+			EncryptionKey: in.LogsKey,
 		},
 	)
 	containerLogGroup.GrantWrite(executionRole)
@@ -193,7 +235,7 @@ func uiStaticTaskDefinition(construct constructs.Construct, in UIStackInput) aws
 			Cpu:            pointer.ToFloat64(512),
 			MemoryLimitMiB: pointer.ToFloat64(1024),
 			Image: awsecs.AssetImage_FromRegistry(
-				pointer.ToString(commons.MetaflowStaticUIImage),
+				pointer.ToString(in.Config.Images.StaticUIImage(in.Account)),
 				nil,
 			),
 			PortMappings: &[]*awsecs.PortMapping{
@@ -237,8 +279,8 @@ func uiStaticService(
 		},
 	)
 
-	certificatePrivateKey, _ := os.ReadFile("my-private-key.pem")
-	certificateBody, _ := os.ReadFile("my-certificate.pem")
+	certificatePrivateKey, _ := os.ReadFile(commons.UIPrivateKeyFile)
+	certificateBody, _ := os.ReadFile(commons.UICertificateFile)
 
 	IAMcertificate := awsiam.NewCfnServerCertificate(
 		construct,
@@ -276,8 +318,9 @@ func uiStaticService(
 		&awsecs.CfnServiceProps{
 			LaunchType: pointer.ToString("FARGATE"),
 			DeploymentConfiguration: &awsecs.CfnService_DeploymentConfigurationProperty{
-				MaximumPercent:        pointer.ToFloat64(200),
-				MinimumHealthyPercent: pointer.ToFloat64(75),
+				MaximumPercent:           pointer.ToFloat64(200),
+				MinimumHealthyPercent:    pointer.ToFloat64(75),
+				DeploymentCircuitBreaker: deploymentCircuitBreaker(),
 			},
 			DesiredCount: pointer.ToFloat64(1),
 			NetworkConfiguration: awsecs.CfnService_NetworkConfigurationProperty{
@@ -309,7 +352,7 @@ func uiServiceFargateService(
 	in UIStackInput,
 	taskDefinition awsecs.TaskDefinition,
 	listener awselasticloadbalancingv2.CfnListener,
-	subnets ...awsec2.CfnSubnet) awsecs.CfnService {
+	subnets ...awsec2.CfnSubnet) (awsecs.CfnService, awselasticloadbalancingv2.CfnTargetGroup) {
 
 	subnetsIds := make([]*string, len(subnets))
 
@@ -360,10 +403,12 @@ func uiServiceFargateService(
 		&awsecs.CfnServiceProps{
 			LaunchType: pointer.ToString("FARGATE"),
 			DeploymentConfiguration: &awsecs.CfnService_DeploymentConfigurationProperty{
-				MaximumPercent:        pointer.ToFloat64(200),
-				MinimumHealthyPercent: pointer.ToFloat64(75),
+				MaximumPercent:           pointer.ToFloat64(200),
+				MinimumHealthyPercent:    pointer.ToFloat64(75),
+				DeploymentCircuitBreaker: deploymentCircuitBreaker(),
 			},
-			DesiredCount: pointer.ToFloat64(1),
+			// No DesiredCount, the scalable target owns the task count and a deploy must not reset it.
+			AvailabilityZoneRebalancing: pointer.ToString("ENABLED"),
 			NetworkConfiguration: awsecs.CfnService_NetworkConfigurationProperty{
 				AwsvpcConfiguration: awsecs.CfnService_AwsVpcConfigurationProperty{
 					AssignPublicIp: pointer.ToString("ENABLED"),
@@ -387,5 +432,5 @@ func uiServiceFargateService(
 
 	service.AddDependency(listener)
 	service.AddDependency(listenerRule)
-	return service
+	return service, uiTargetGroup
 }